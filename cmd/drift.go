@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/drift"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features"
+	"os"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// detectDrift detects the drift of every host from its tasks, prints the drift
+// report in the -output format and returns the process exit code. The hosts
+// are only read, and the unreachable hosts are reported instead of aborting.
+func detectDrift() int {
+	if *output != outputText && *output != outputJSON {
+		klog.Errorf("Unsupported output %q, expected %s or %s", *output, outputText, outputJSON)
+		return exitConfigError
+	}
+	ciConfig, _, hosts, err := loadConfig(configFilePaths())
+	if err != nil {
+		klog.Error(err)
+		return exitConfigError
+	}
+	defer func() {
+		for _, host := range hosts {
+			host.Close()
+		}
+	}()
+	ctx, cancel := runContext(ciConfig)
+	defer cancel()
+
+	klog.Infof("******** Start drift detection ********")
+	plans := planAll(ctx, hosts, true, features.DriftHost)
+	klog.Infof("******** Drift detection complete ********")
+	driftReport := drift.New(hosts, plans, time.Now())
+	if *output == outputJSON {
+		err = driftReport.WriteJSON(os.Stdout)
+	} else {
+		err = driftReport.WriteText(os.Stdout)
+	}
+	if err != nil {
+		klog.Errorf("Failed to write drift report: %v", err)
+		return exitFailure
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		return exitInterrupted
+	case driftReport.Summary.Unreachable > 0:
+		return exitConnectionError
+	case driftReport.Summary.Failed > 0:
+		return exitFailure
+	case driftReport.HasDrift():
+		return exitDrift
+	}
+	return exitSuccess
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+)
+
+// Exit codes of the process, so pipelines can gate on the result.
+const (
+	exitSuccess = 0
+	// exitFailure means some hosts failed.
+	exitFailure = 1
+	// exitConfigError means the config file or the command line is invalid,
+	// the flag package uses the same code for invalid flags.
+	exitConfigError = 2
+	// exitConnectionError means some hosts could not be connected.
+	exitConnectionError = 3
+	// exitInterrupted means the run was cancelled or timed out before all
+	// hosts completed.
+	exitInterrupted = 4
+	// exitDrift means some hosts drifted from the config, it is only returned
+	// by the drift command.
+	exitDrift = 5
+)
+
+const exitCodesUsage = `Exit codes:
+  0 All hosts succeeded
+  1 Some hosts failed
+  2 Invalid config file or command line
+  3 Some hosts could not be connected
+  4 The run was interrupted or timed out
+  5 Some hosts drifted from the config (drift)
+`
+
+// hostsExitCode returns the exit code of a run, an interruption takes
+// precedence over unreachable hosts, which take precedence over failures.
+func hostsExitCode(hosts []*config.Host) int {
+	code := exitSuccess
+	for _, host := range hosts {
+		switch {
+		case host.Interrupted:
+			return exitInterrupted
+		case host.Unreachable:
+			code = exitConnectionError
+		case !host.Success && code == exitSuccess:
+			code = exitFailure
+		}
+	}
+	return code
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"testing"
+)
+
+func TestHostsExitCode(t *testing.T) {
+	succeeded := &config.Host{Success: true}
+	failed := &config.Host{}
+	skipped := &config.Host{Skipped: true}
+	unreachable := &config.Host{Unreachable: true}
+	interrupted := &config.Host{Interrupted: true}
+	tests := []struct {
+		name  string
+		hosts []*config.Host
+		want  int
+	}{
+		{name: "no hosts", want: exitSuccess},
+		{name: "all succeeded", hosts: []*config.Host{succeeded, succeeded}, want: exitSuccess},
+		{name: "failed", hosts: []*config.Host{succeeded, failed}, want: exitFailure},
+		{name: "skipped by the rollout", hosts: []*config.Host{failed, skipped}, want: exitFailure},
+		{name: "unreachable", hosts: []*config.Host{succeeded, unreachable}, want: exitConnectionError},
+		{name: "unreachable before failed", hosts: []*config.Host{unreachable, failed}, want: exitConnectionError},
+		{name: "unreachable after failed", hosts: []*config.Host{failed, unreachable}, want: exitConnectionError},
+		{name: "interrupted", hosts: []*config.Host{succeeded, interrupted}, want: exitInterrupted},
+		{name: "interrupted before unreachable", hosts: []*config.Host{interrupted, unreachable, failed}, want: exitInterrupted},
+		{name: "interrupted after unreachable", hosts: []*config.Host{failed, unreachable, interrupted}, want: exitInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostsExitCode(tt.hosts); got != tt.want {
+				t.Errorf("hostsExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,81 +1,348 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/ruicao93/antrea-windows-ci/pkg/config"
 	"github.com/ruicao93/antrea-windows-ci/pkg/features"
+	"github.com/ruicao93/antrea-windows-ci/pkg/report"
+	"github.com/ruicao93/antrea-windows-ci/pkg/rollout"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"github.com/ruicao93/antrea-windows-ci/pkg/state"
 	"io/ioutil"
 	"os"
-	"sync"
+	"strings"
+	"time"
 
-	"gopkg.in/yaml.v2"
 	"k8s.io/klog"
 )
 
-var configFile = flag.String("configFile", "config.yaml", "Hosts config file")
-var dryRun = flag.Bool("dryRun", false, "Dry run")
+var dryRun = flag.Bool("dryRun", false, "Dry run, print the plan of every host without changing it")
+var limit = flag.String("limit", "", "Only apply the hosts and groups matching these comma separated patterns, e.g. containerd,10.176.26.*")
+var selector = flag.String("selector", "", "Only apply the hosts whose labels match this selector, e.g. runtime=containerd,os!=2019")
+var stateDir = flag.String("stateDir", ".antrea-windows-ci", "Directory of the state files of the runs, which record the results of the tasks of every host. A run is only recorded if -stateDir, -runID or -resume is set")
+var runID = flag.String("runID", "", "ID of the run in the state directory, the start time by default, or the latest run with -resume")
+var resume = flag.Bool("resume", false, "Resume the run -runID, skipping the tasks which succeeded with the same parameters")
+var output = flag.String("output", outputText, "Output format of the drift command, text or json")
+var failUnreachable = flag.Bool("failUnreachable", false, "Report the hosts which cannot be connected as failed and apply the other hosts, instead of aborting the run")
+var configFiles stringsFlag
+var reportFiles stringsFlag
+var setVars stringsFlag
+
+const defaultConfigFile = "config.yaml"
+
+func init() {
+	flag.Var(&configFiles, "configFile", "Hosts config file, may be given several times to merge the files in order, the later files override the earlier ones (default "+defaultConfigFile+")")
+	flag.Var(&reportFiles, "report", "Write a report of the run to the file, as JUnit XML if it ends with .xml and as JSON otherwise, may be given several times")
+	flag.Var(&setVars, "set", "Set a variable as key=value, overriding the vars of the config file, may be given several times")
+}
+
+// stringsFlag is a flag which may be given several times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// configFilePaths returns the config files given by -configFile.
+func configFilePaths() []string {
+	if len(configFiles) == 0 {
+		return []string{defaultConfigFile}
+	}
+	return configFiles
+}
+
+const (
+	commandRun      = "run"
+	commandValidate = "validate"
+	commandFeatures = "features"
+	commandPlan     = "plan"
+	commandPing     = "ping"
+	// commandPreflight is an alias of commandPing.
+	commandPreflight = "preflight"
+	commandVault     = "vault"
+	commandRender    = "render"
+	commandDrift     = "drift"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [command]
+
+Commands:
+  %-10s Apply the tasks to the hosts in the config file (default)
+  %-10s Print what the tasks would change on the hosts, same as run -dryRun
+  %-10s Report the hosts whose state drifted from the config, without changing them
+  %-10s Check WinRM and SSH reachability and credentials of the hosts, alias %s
+  %-10s Validate the config files
+  %-10s Print the merged config files with the defaults set and the passwords redacted
+  %-10s List the supported features and their parameters
+  %-10s Encrypt or decrypt a vault file of secrets
+
+%s
+Flags:
+`, os.Args[0], commandRun, commandPlan, commandDrift, commandPing, commandPreflight, commandValidate, commandRender, commandFeatures, commandVault, exitCodesUsage)
+	flag.PrintDefaults()
+}
 
 func main() {
+	flag.Usage = usage
+	klog.InitFlags(nil)
 	flag.Parse()
-	configData, err := ioutil.ReadFile(*configFile)
+	redactLogs()
+	command := commandRun
+	if flag.NArg() > 0 {
+		command = flag.Arg(0)
+	}
+	switch command {
+	case commandRun:
+		os.Exit(run())
+	case commandPlan:
+		*dryRun = true
+		os.Exit(run())
+	case commandDrift:
+		os.Exit(detectDrift())
+	case commandPing, commandPreflight:
+		os.Exit(ping())
+	case commandValidate:
+		os.Exit(validate(configFilePaths()))
+	case commandRender:
+		os.Exit(render(configFilePaths()))
+	case commandFeatures:
+		fmt.Print(features.Doc())
+	case commandVault:
+		os.Exit(vault(flag.Args()[1:]))
+	default:
+		klog.Errorf("Unknown command %s", command)
+		flag.Usage()
+		os.Exit(exitConfigError)
+	}
+}
+
+// redactLogs redacts the secrets from the logs, it must be called after the
+// klog flags are parsed. Every log is written to stderr through the info
+// output, klog writes the logs of every severity to it, so the other outputs
+// are discarded. klog never writes the logs to stderr directly, the stderr
+// threshold is above FATAL.
+func redactLogs() {
+	flag.Set("logtostderr", "false")
+	flag.Set("stderrthreshold", "4")
+	klog.SetOutputBySeverity("INFO", secret.NewRedactingWriter(os.Stderr))
+	for _, severity := range []string{"WARNING", "ERROR", "FATAL"} {
+		klog.SetOutputBySeverity(severity, ioutil.Discard)
+	}
+}
+
+// parseConfig loads, merges and validates the config files, and returns the
+// config with the groups applied and the hosts selected by -limit and
+// -selector. The secrets are not resolved and the defaults are not set.
+func parseConfig(configFiles []string) (*config.CIConfig, error) {
+	overrides, err := varOverrides()
 	if err != nil {
-		klog.Errorf("Failed to load config file: %v", err)
-		os.Exit(1)
+		return nil, err
+	}
+	doc, err := config.LoadDocument(configFiles)
+	if err == nil {
+		err = features.ValidateConfig(doc, overrides)
+	}
+	if _, ok := err.(config.ValidationErrors); ok {
+		return nil, fmt.Errorf("invalid config:\n%v", err)
+	} else if err != nil {
+		return nil, err
 	}
 	ciConfig := config.CIConfig{}
-	err = yaml.Unmarshal(configData, &ciConfig)
+	if err := doc.Decode(&ciConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	ciConfig.Overrides = overrides
+	if err := config.ApplyGroups(&ciConfig); err != nil {
+		return nil, err
+	}
+	hostFilter, err := config.NewHostFilter(*limit, *selector)
 	if err != nil {
-		klog.Errorf("Failed to parse config file: %v", err)
-		os.Exit(1)
+		return nil, err
+	}
+	if err := config.FilterHosts(&ciConfig, hostFilter); err != nil {
+		return nil, err
+	}
+	if !hostFilter.Empty() && len(ciConfig.Hosts) == 0 {
+		return nil, fmt.Errorf("no host matches -limit %q and -selector %q", *limit, *selector)
+	}
+	return &ciConfig, nil
+}
+
+// loadConfig loads the config files, and returns their tasks and the hosts
+// selected by -limit and -selector. The hosts are not connected yet. The
+// secrets of the other hosts are not resolved.
+func loadConfig(configFiles []string) (*config.CIConfig, map[string]*config.Task, []*config.Host, error) {
+	ciConfig, err := parseConfig(configFiles)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := config.ResolveCredentials(ciConfig); err != nil {
+		return nil, nil, nil, err
 	}
 	ciConfig.SetDefaults()
-	if len(ciConfig.Hosts) == 0 {
-		klog.Warningf("Ho host found in config, exit")
-		os.Exit(0)
+	taskMap, err := config.NewTasks(ciConfig)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to init tasks: %v", err)
 	}
-	taskMap, err := config.NewTasks(&ciConfig)
+	hosts, err := config.NewHosts(ciConfig, taskMap)
 	if err != nil {
-		klog.Errorf("Failed to init tasks: %v", err)
-		os.Exit(1)
+		return nil, nil, nil, fmt.Errorf("failed to init hosts: %v", err)
 	}
-	hosts, err := config.NewHosts(&ciConfig, taskMap)
+	return ciConfig, taskMap, hosts, nil
+}
+
+// openState creates the state of a new run, or loads the state of the resumed
+// run. It returns nil if the run is not recorded, see stateEnabled.
+func openState() (*state.State, error) {
+	if !stateEnabled() {
+		return nil, nil
+	}
+	if *resume {
+		return state.Load(*stateDir, *runID)
+	}
+	id := *runID
+	if id == "" {
+		id = state.NewRunID()
+	}
+	return state.New(*stateDir, id)
+}
+
+// stateEnabled returns whether the run is recorded, i.e. -stateDir, -runID or
+// -resume is set, so a run does not leave state files in the working directory
+// unless asked to.
+func stateEnabled() bool {
+	enabled := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "stateDir", "runID", "resume":
+			enabled = true
+		}
+	})
+	return enabled
+}
+
+// varOverrides returns the variables set by -set.
+func varOverrides() (map[string]string, error) {
+	overrides := map[string]string{}
+	for _, setVar := range setVars {
+		name, value, err := config.ParseOverride(setVar)
+		if err != nil {
+			return nil, err
+		}
+		overrides[name] = value
+	}
+	return overrides, nil
+}
+
+// runContext returns the context of a run, which is cancelled on SIGINT or
+// SIGTERM, or once the timeout of the config elapsed.
+func runContext(ciConfig *config.CIConfig) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if ciConfig.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, ciConfig.Timeout)
+		cancelRun := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelRun()
+		}
+	}
+	handleSignals(cancel)
+	return ctx, cancel
+}
+
+// run applies or plans the hosts and returns the process exit code.
+func run() int {
+	ciConfig, taskMap, hosts, err := loadConfig(configFilePaths())
 	if err != nil {
-		klog.Errorf("Failed to init hosts: %v", err)
-		os.Exit(1)
+		klog.Error(err)
+		return exitConfigError
 	}
+	if len(hosts) == 0 {
+		klog.Warningf("Ho host found in config, exit")
+		return exitSuccess
+	}
+	defer func() {
+		for _, host := range hosts {
+			host.Close()
+		}
+	}()
 	config.DumpTasks(taskMap)
 	config.DumpHosts(hosts)
 
+	ctx, cancel := runContext(ciConfig)
+	defer cancel()
+
+	// Unless unreachable hosts are reported as failed, all hosts are checked
+	// before any change is made, and the run is aborted if one fails.
+	if !*failUnreachable {
+		if code := preflightHosts(ctx, hosts); code != exitSuccess {
+			return code
+		}
+	}
+
 	if *dryRun || ciConfig.DryRun {
-		os.Exit(0)
+		return planHosts(ctx, hosts, *failUnreachable)
 	}
 
-	var wg sync.WaitGroup
-	klog.Infof("******** Start works ********")
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(host *config.Host) {
-			if err := features.ApplyHost(host); err != nil {
-				host.Success = false
-				host.Error = fmt.Errorf("failed to apply host %s: %v", host.HostConfig.Host, err)
-			} else {
-				host.Success = true
-			}
-			wg.Done()
-		}(host)
+	runState, err := openState()
+	if err != nil {
+		klog.Error(err)
+		return exitConfigError
 	}
-	wg.Wait()
+	if runState != nil {
+		klog.Infof("Run %s, the results of the tasks are recorded in %s", runState.RunID, runState.Path())
+		for _, host := range hosts {
+			host.State = runState
+		}
+	}
+
+	klog.Infof("******** Start works ********")
+	startTime := time.Now()
+	rollout.Run(ctx, hosts, &ciConfig.Rollout, func(ctx context.Context, host *config.Host) {
+		if *failUnreachable && !checkHost(ctx, host) {
+			return
+		}
+		if err := features.ApplyHost(ctx, host); err != nil {
+			host.Success = false
+			host.Error = fmt.Errorf("failed to apply host %s: %v", host.HostConfig.Host, err)
+			host.Interrupted = ctx.Err() != nil
+		} else {
+			host.Success = true
+		}
+	})
 	klog.Infof("******** Works complete ********")
 	DumpResults(hosts)
-	for _, host := range hosts {
-		host.SSHClient.Close()
+	runReport := report.New(hosts, startTime, time.Now())
+	for _, reportFile := range reportFiles {
+		if err := runReport.WriteFile(reportFile); err != nil {
+			klog.Error(err)
+		} else {
+			klog.Infof("Report written to %s", reportFile)
+		}
 	}
+	return hostsExitCode(hosts)
 }
 
 func DumpResults(hosts []*config.Host) {
 	var successfulHosts []*config.Host
 	var failureHosts []*config.Host
+	interrupted := 0
+	skipped := 0
+	unreachable := 0
 	result := "success!"
 	for _, host := range hosts {
 		if host.Success {
@@ -83,6 +350,15 @@ func DumpResults(hosts []*config.Host) {
 		} else {
 			failureHosts = append(failureHosts, host)
 		}
+		if host.Interrupted {
+			interrupted++
+		}
+		if host.Skipped {
+			skipped++
+		}
+		if host.Unreachable {
+			unreachable++
+		}
 	}
 	if len(failureHosts) > 0 {
 		result = "fail!"
@@ -90,8 +366,38 @@ func DumpResults(hosts []*config.Host) {
 	klog.Infof("Result: %s", result)
 	klog.Infof("Success: %d", len(successfulHosts))
 	klog.Infof("Fail: %d", len(failureHosts))
+	if interrupted > 0 {
+		klog.Infof("Interrupted: %d", interrupted)
+	}
+	if skipped > 0 {
+		klog.Infof("Skipped: %d", skipped)
+	}
+	if unreachable > 0 {
+		klog.Infof("Unreachable: %d", unreachable)
+	}
 	for index, host := range failureHosts {
-		klog.Infof("====== %d. Failure host: %s", index+1, host.HostConfig.Host)
+		if host.Interrupted {
+			klog.Infof("====== %d. Interrupted host: %s", index+1, host.HostConfig.Host)
+		} else if host.Skipped {
+			klog.Infof("====== %d. Skipped host: %s", index+1, host.HostConfig.Host)
+		} else if host.Unreachable {
+			klog.Infof("====== %d. Unreachable host: %s", index+1, host.HostConfig.Host)
+		} else {
+			klog.Infof("====== %d. Failure host: %s", index+1, host.HostConfig.Host)
+		}
 		klog.Info(host.Error)
+		for _, result := range host.TaskResults {
+			retries := ""
+			if result.Retries > 0 {
+				retries = fmt.Sprintf(" after %d retries", result.Retries)
+			}
+			if result.Error != nil {
+				klog.Infof("Task %s %s%s: %v", result.Task, result.Status, retries, result.Error)
+			} else if result.Resumed {
+				klog.Infof("Task %s %s in the resumed run", result.Task, result.Status)
+			} else {
+				klog.Infof("Task %s %s in %v%s", result.Task, result.Status, result.Duration.Round(time.Second), retries)
+			}
+		}
 	}
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/preflight"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"os"
+	"text/tabwriter"
+
+	"k8s.io/klog"
+)
+
+// ping checks every host in parallel, prints what is reachable and returns the
+// process exit code.
+func ping() int {
+	ciConfig, _, hosts, err := loadConfig(configFilePaths())
+	if err != nil {
+		klog.Error(err)
+		return exitConfigError
+	}
+	defer func() {
+		for _, host := range hosts {
+			host.Close()
+		}
+	}()
+	ctx, cancel := runContext(ciConfig)
+	defer cancel()
+	results := preflight.Check(ctx, hosts)
+	printPreflight(results)
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	for _, result := range results {
+		if !result.OK() {
+			return exitConnectionError
+		}
+	}
+	return exitSuccess
+}
+
+func printPreflight(results []*preflight.HostResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tWINRM\tSSH\tPOWERSHELL\tERROR")
+	failed := 0
+	for _, result := range results {
+		version := result.PowerShellVersion
+		if version == "" {
+			version = "-"
+		}
+		errMessage := "-"
+		if err := result.Err(); err != nil {
+			errMessage = secret.Redact(err.Error())
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Host, &result.WinRM, &result.SSH, version, errMessage)
+	}
+	w.Flush()
+	fmt.Printf("%d of %d hosts are reachable\n", len(results)-failed, len(results))
+}
+
+// preflightHosts checks every host in parallel before a run, the failed hosts
+// are marked unreachable. It returns exitSuccess if all hosts passed.
+func preflightHosts(ctx context.Context, hosts []*config.Host) int {
+	klog.Infof("******** Start preflight ********")
+	results := preflight.Check(ctx, hosts)
+	klog.Infof("******** Preflight complete ********")
+	if ctx.Err() != nil {
+		klog.Errorf("Preflight interrupted: %v", ctx.Err())
+		return exitInterrupted
+	}
+	unreachable := 0
+	for i, result := range results {
+		if err := result.Err(); err != nil {
+			markUnreachable(hosts[i], err)
+			klog.Error(err)
+			unreachable++
+		}
+	}
+	if unreachable > 0 {
+		printPreflight(results)
+		klog.Errorf("Abort, %d of %d hosts failed preflight, use -failUnreachable to apply the other hosts", unreachable, len(hosts))
+		return exitConnectionError
+	}
+	return exitSuccess
+}
+
+// checkHost checks a host by preflight in its worker, and marks it unreachable
+// and failed if the check fails.
+func checkHost(ctx context.Context, host *config.Host) bool {
+	result := preflight.CheckHost(ctx, host)
+	if err := result.Err(); err != nil {
+		klog.Error(err)
+		markUnreachable(host, err)
+		host.Interrupted = ctx.Err() != nil
+		return false
+	}
+	return true
+}
+
+func markUnreachable(host *config.Host, err error) {
+	host.Success = false
+	host.Unreachable = true
+	host.Error = err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features"
+	"github.com/ruicao93/antrea-windows-ci/pkg/plan"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// planHosts prints the plan of every host in the order of the config file and
+// returns the process exit code, the hosts are only read. If check is true,
+// every host is checked by preflight before it is planned.
+func planHosts(ctx context.Context, hosts []*config.Host, check bool) int {
+	klog.Infof("******** Start planning ********")
+	plans := planAll(ctx, hosts, check, features.PlanHost)
+	klog.Infof("******** Planning complete ********")
+	code := exitSuccess
+	changedHosts := 0
+	for i, hostPlan := range plans {
+		fmt.Print(secret.Redact(hostPlan.String()))
+		if hostPlan.HasChanges() {
+			changedHosts++
+		}
+		if err := hostPlan.Err(); err != nil {
+			klog.Error(err)
+			if hosts[i].Unreachable {
+				code = exitConnectionError
+			} else if code == exitSuccess {
+				code = exitFailure
+			}
+		}
+	}
+	fmt.Printf("%d of %d hosts would be changed\n", changedHosts, len(hosts))
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	return code
+}
+
+// planAll plans the hosts in parallel with planHost, and returns their plans in
+// the same order. If check is true, every host is checked by preflight before
+// it is planned.
+func planAll(ctx context.Context, hosts []*config.Host, check bool, planHost func(ctx context.Context, host *config.Host) *plan.HostPlan) []*plan.HostPlan {
+	plans := make([]*plan.HostPlan, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host *config.Host) {
+			defer wg.Done()
+			if check && !checkHost(ctx, host) {
+				plans[i] = unreachablePlan(host)
+				return
+			}
+			plans[i] = planHost(ctx, host)
+		}(i, host)
+	}
+	wg.Wait()
+	return plans
+}
+
+// unreachablePlan returns the plan of a host which could not be connected,
+// every task fails with the connection error.
+func unreachablePlan(host *config.Host) *plan.HostPlan {
+	hostPlan := &plan.HostPlan{Host: host.HostConfig.Host}
+	for _, task := range host.Tasks {
+		hostPlan.Tasks = append(hostPlan.Tasks, &plan.TaskPlan{Task: task.Name, Feature: task.Feature.Name, Error: host.Error})
+	}
+	return hostPlan
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"os"
+
+	"k8s.io/klog"
+)
+
+// render prints the merged config files with the groups applied and the
+// defaults set, only with the hosts selected by -limit and -selector, and
+// returns the process exit code.
+func render(configFiles []string) int {
+	ciConfig, err := parseConfig(configFiles)
+	if err != nil {
+		klog.Error(err)
+		return exitConfigError
+	}
+	data, err := config.Render(ciConfig)
+	if err != nil {
+		klog.Errorf("Failed to render config: %v", err)
+		return exitConfigError
+	}
+	os.Stdout.Write(data)
+	return exitSuccess
+}
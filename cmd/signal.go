@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog"
+)
+
+// handleSignals cancels the run on the first SIGINT or SIGTERM, so in-flight
+// work stops and the results are still dumped, and exits on the second one.
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		klog.Warningf("Received signal %v, cancelling in-flight work, send it again to exit immediately", sig)
+		cancel()
+		sig = <-signals
+		klog.Errorf("Received signal %v again, exit", sig)
+		os.Exit(130)
+	}()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features"
+	"os"
+	"strings"
+)
+
+// validate validates the merged config files and returns the process exit
+// code. Every validation error is prefixed with its file, e.g.
+// "config.yaml:12:7: hosts[1]: unknown field ...".
+func validate(configFiles []string) int {
+	overrides, err := varOverrides()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	doc, err := config.LoadDocument(configFiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	if err := features.ValidateConfig(doc, overrides); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	if len(configFiles) == 1 {
+		fmt.Printf("%s is valid\n", configFiles[0])
+	} else {
+		fmt.Printf("%s are valid\n", strings.Join(configFiles, ", "))
+	}
+	return exitSuccess
+}
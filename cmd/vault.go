@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+var vaultPasswordFrom = flag.String("vaultPasswordFrom", secret.DefaultVaultPasswordFrom, "Reference of the password of the vault command, env:VAR or file:/path")
+
+const (
+	vaultEncrypt = "encrypt"
+	vaultDecrypt = "decrypt"
+)
+
+// vault encrypts a YAML map of secrets into a vault file, or prints the
+// secrets of a vault file, and returns the process exit code.
+func vault(args []string) int {
+	if len(args) == 0 {
+		return vaultUsage()
+	}
+	if strings.HasPrefix(*vaultPasswordFrom, secret.PrefixVault) {
+		klog.Error("The vault password cannot be stored in the vault")
+		return exitConfigError
+	}
+	resolver := &secret.Resolver{}
+	password, err := resolver.Resolve(*vaultPasswordFrom)
+	if err != nil {
+		klog.Errorf("Failed to get vault password: %v", err)
+		return exitConfigError
+	}
+	switch {
+	case args[0] == vaultEncrypt && len(args) == 3:
+		plaintext, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			klog.Errorf("Failed to read secrets: %v", err)
+			return exitFailure
+		}
+		data, err := secret.EncryptVault(plaintext, password)
+		if err != nil {
+			klog.Errorf("Failed to encrypt vault: %v", err)
+			return exitFailure
+		}
+		if err := ioutil.WriteFile(args[2], data, 0600); err != nil {
+			klog.Errorf("Failed to write vault: %v", err)
+			return exitFailure
+		}
+		return exitSuccess
+	case args[0] == vaultDecrypt && len(args) == 2:
+		data, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			klog.Errorf("Failed to read vault: %v", err)
+			return exitFailure
+		}
+		plaintext, err := secret.DecryptVault(data, password)
+		if err != nil {
+			klog.Errorf("Failed to open vault %s: %v", args[1], err)
+			return exitFailure
+		}
+		os.Stdout.Write(plaintext)
+		return exitSuccess
+	default:
+		return vaultUsage()
+	}
+}
+
+func vaultUsage() int {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage:
+  %[1]s [-vaultPasswordFrom REF] %[2]s %[3]s SECRETS_FILE VAULT_FILE
+  %[1]s [-vaultPasswordFrom REF] %[2]s %[4]s VAULT_FILE
+`, os.Args[0], commandVault, vaultEncrypt, vaultDecrypt)
+	return exitConfigError
+}
@@ -3,9 +3,11 @@ module github.com/ruicao93/antrea-windows-ci
 go 1.15
 
 require (
+	github.com/Azure/go-ntlmssp v0.0.0-20180810175552-4a21cbd618b4
 	github.com/masterzen/winrm v0.0.0-20201030141608-56ca5c5f2380
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 	k8s.io/apimachinery v0.20.2
 	k8s.io/klog v1.0.0
 )
@@ -0,0 +1,338 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// includeKey is the field of a config file listing the files it includes, the
+// paths are relative to the file and may be glob patterns.
+const includeKey = "include"
+
+// keyedSequences maps the paths of the sequences whose items are merged by a
+// key field, instead of being replaced, to the key field.
+var keyedSequences = map[string]string{
+	"tasks":       "name",
+	"groups":      "name",
+	"credentials": "name",
+	"hosts":       "host",
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// Document is the YAML of config files merged by LoadDocument. Its nodes keep
+// the files they are loaded from, so validation errors point to the right
+// file.
+type Document struct {
+	// Files are the config files of the document, without the files they
+	// include.
+	Files []string
+
+	root *yaml.Node
+	// files maps the nodes to the files they are loaded from.
+	files map[*yaml.Node]string
+}
+
+// LoadDocument loads the config files and merges them in order, a file is
+// merged over the files it includes. The values of a later file replace the
+// values of the earlier files, except that:
+//   - mappings are merged key by key, e.g. vars, labels, winrm or keyValues.
+//   - tasks, groups and credentials are merged by name and hosts by host, a
+//     task of a later file with the same name as an earlier task is merged
+//     into it, other tasks are appended.
+//
+// Other sequences are replaced, e.g. the tasks or groups of a host. The
+// returned error is a ValidationErrors if a file or an include is invalid.
+func LoadDocument(files []string) (*Document, error) {
+	l := &documentLoader{files: map[*yaml.Node]string{}}
+	var root *yaml.Node
+	for _, file := range files {
+		fileRoot, err := l.load(file, nil)
+		if err != nil {
+			if _, ok := err.(ValidationErrors); ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to load config file: %v", err)
+		}
+		root = mergeRoot(root, fileRoot)
+	}
+	return &Document{Files: files, root: root, files: l.files}, nil
+}
+
+// Decode decodes the merged config files into ciConfig.
+func (d *Document) Decode(ciConfig *CIConfig) error {
+	if d.root == nil {
+		return nil
+	}
+	return d.root.Decode(ciConfig)
+}
+
+type documentLoader struct {
+	files map[*yaml.Node]string
+}
+
+// load returns the root mapping of file merged over the files it includes, or
+// nil if file is empty. including are the files including file.
+func (l *documentLoader) load(file string, including []string) (*yaml.Node, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		validationErr := &ValidationError{File: file, Line: 1, Message: err.Error()}
+		if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+			validationErr.Line, _ = strconv.Atoi(match[1])
+			validationErr.Message = match[2]
+		}
+		return nil, ValidationErrors{validationErr}
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+	root := document.Content[0]
+	l.setFile(root, file)
+	if root.Kind != yaml.MappingNode {
+		return nil, ValidationErrors{l.errorf(root, "", "expected a mapping, got %s", nodeKind(root))}
+	}
+	include, err := l.popInclude(root)
+	if err != nil {
+		return nil, err
+	}
+	var merged *yaml.Node
+	for i, item := range include {
+		path := fmt.Sprintf("%s[%d]", includeKey, i)
+		if item.Kind != yaml.ScalarNode || item.Value == "" {
+			return nil, ValidationErrors{l.errorf(item, path, "expected a file name, got %s", nodeKind(item))}
+		}
+		pattern := item.Value
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+		matches := []string{pattern}
+		if strings.ContainsAny(item.Value, "*?[") {
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, ValidationErrors{l.errorf(item, path, "invalid pattern %q: %v", item.Value, err)}
+			}
+			if len(matches) == 0 {
+				return nil, ValidationErrors{l.errorf(item, path, "%q matches no file", item.Value)}
+			}
+		}
+		chain := append(append([]string(nil), including...), file)
+		for _, match := range matches {
+			if cycle := includeCycle(chain, match); cycle != "" {
+				return nil, ValidationErrors{l.errorf(item, path, "include cycle %s", cycle)}
+			}
+			included, err := l.load(match, chain)
+			if err != nil {
+				if _, ok := err.(ValidationErrors); ok {
+					return nil, err
+				}
+				return nil, ValidationErrors{l.errorf(item, path, "failed to include file: %v", err)}
+			}
+			merged = mergeRoot(merged, included)
+		}
+	}
+	return mergeRoot(merged, root), nil
+}
+
+// popInclude removes the include field from root and returns its items.
+func (l *documentLoader) popInclude(root *yaml.Node) ([]*yaml.Node, error) {
+	var include *yaml.Node
+	for i := 0; i+1 < len(root.Content); {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != includeKey {
+			i += 2
+			continue
+		}
+		if include != nil {
+			return nil, ValidationErrors{l.errorf(key, "", "duplicated field %q", includeKey)}
+		}
+		include = value
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+	}
+	if include == nil || include.Tag == "!!null" {
+		return nil, nil
+	}
+	if include.Kind != yaml.SequenceNode {
+		return nil, ValidationErrors{l.errorf(include, includeKey, "expected a sequence, got %s", nodeKind(include))}
+	}
+	return include.Content, nil
+}
+
+// setFile records file as the file of node and its descendants.
+func (l *documentLoader) setFile(node *yaml.Node, file string) {
+	l.files[node] = file
+	for _, child := range node.Content {
+		l.setFile(child, file)
+	}
+}
+
+func (l *documentLoader) errorf(node *yaml.Node, path string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		File:    l.files[node],
+		Line:    node.Line,
+		Column:  node.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// includeCycle returns the include chain if file is already in it, e.g.
+// "a.yml -> b.yml -> a.yml", or "" otherwise.
+func includeCycle(chain []string, file string) string {
+	for i, including := range chain {
+		if sameFile(including, file) {
+			return strings.Join(append(append([]string(nil), chain[i:]...), file), " -> ")
+		}
+	}
+	return ""
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// mergeRoot merges the root mapping src into dst and returns the merged root,
+// either may be nil for an empty file.
+func mergeRoot(dst, src *yaml.Node) *yaml.Node {
+	if dst == nil {
+		return src
+	}
+	if src != nil {
+		mergeMapping(dst, src, "")
+	}
+	return dst
+}
+
+// mergeMapping merges the mapping src into dst, see LoadDocument. A key
+// duplicated in src is appended to dst, so that it is reported by Validate.
+func mergeMapping(dst, src *yaml.Node, path string) {
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		index := mappingIndex(dst, key.Value)
+		if index < 0 || seen[key.Value] {
+			dst.Content = append(dst.Content, key, value)
+			seen[key.Value] = true
+			continue
+		}
+		seen[key.Value] = true
+		childPath := joinPath(path, key.Value)
+		dstValue := dst.Content[index+1]
+		switch {
+		case dstValue.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeMapping(dstValue, value, childPath)
+		case dstValue.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode && keyedSequences[childPath] != "":
+			mergeSequence(dstValue, value, keyedSequences[childPath], childPath)
+		default:
+			dst.Content[index], dst.Content[index+1] = key, value
+		}
+	}
+}
+
+// mergeSequence merges the items of src into the items of dst with the same
+// key, and appends the other items. Items with the same key in src are all
+// appended, so that they are reported by Validate.
+func mergeSequence(dst, src *yaml.Node, key, path string) {
+	dstItems := len(dst.Content)
+	merged := map[string]bool{}
+	for _, item := range src.Content {
+		itemKey := mappingValue(item, key)
+		if itemKey == "" || merged[itemKey] {
+			dst.Content = append(dst.Content, item)
+			continue
+		}
+		merged[itemKey] = true
+		found := false
+		for _, dstItem := range dst.Content[:dstItems] {
+			if mappingValue(dstItem, key) == itemKey {
+				mergeMapping(dstItem, item, path+"[]")
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst.Content = append(dst.Content, item)
+		}
+	}
+}
+
+// mappingIndex returns the index of key in the mapping node, or -1.
+func mappingIndex(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the scalar value of key if node is a mapping, or "".
+func mappingValue(node *yaml.Node, key string) string {
+	if node.Kind != yaml.MappingNode {
+		return ""
+	}
+	index := mappingIndex(node, key)
+	if index < 0 || node.Content[index+1].Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Content[index+1].Value
+}
+
+// Render returns the config as YAML with the defaults set as the hosts are
+// applied, it must be called after ApplyGroups. The secrets are not resolved,
+// the users of the credentials are set on their hosts and the passwords are
+// redacted.
+func Render(ciConfig *CIConfig) ([]byte, error) {
+	rendered := *ciConfig
+	rendered.Credentials = append([]Credential(nil), ciConfig.Credentials...)
+	credentials := map[string]*Credential{}
+	for i := range rendered.Credentials {
+		credential := &rendered.Credentials[i]
+		if credential.Password != "" {
+			credential.Password = secret.Redacted
+		}
+		credentials[credential.Name] = credential
+	}
+	rendered.Hosts = append([]HostConfig(nil), ciConfig.Hosts...)
+	for i := range rendered.Hosts {
+		hostConfig := &rendered.Hosts[i]
+		if credential, ok := credentials[hostConfig.Credential]; ok && hostConfig.User == "" {
+			hostConfig.User = credential.User
+		}
+		if hostConfig.Password != "" {
+			hostConfig.Password = secret.Redacted
+		}
+	}
+	rendered.Groups = append([]Group(nil), ciConfig.Groups...)
+	for i := range rendered.Groups {
+		if rendered.Groups[i].Defaults.Password != "" {
+			rendered.Groups[i].Defaults.Password = secret.Redacted
+		}
+	}
+	rendered.SetDefaults()
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&rendered); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(secret.Redact(buf.String())), nil
+}
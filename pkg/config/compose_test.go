@@ -0,0 +1,205 @@
+package config_test
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDocument(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		load  []string
+		// wantVars are the merged vars, wantTasks maps the merged tasks to
+		// their ovsVersion key value and wantHosts maps the merged hosts to
+		// their tasks.
+		wantVars  map[string]string
+		wantTasks []string
+		wantHosts []string
+		wantErr   string
+	}{
+		{
+			name: "overlay",
+			files: map[string]string{
+				"base.yml": `
+vars: {a: base, b: base}
+hosts:
+- {host: win-1, tasks: [ovs, containers]}
+- {host: win-2, tasks: [ovs]}
+tasks:
+- {name: ovs, feature: {name: InstallOVS, keyValues: {ovsVersion: 2.13.1, ovsType: nsx}}}
+- {name: containers, feature: {name: WindowsContainer}}
+`,
+				"overlay.yml": `
+vars: {b: overlay}
+hosts:
+- {host: win-1, tasks: [ovs]}
+- {host: win-3, tasks: [antrea]}
+tasks:
+- {name: ovs, feature: {keyValues: {ovsVersion: 2.14.0}}}
+- {name: antrea, feature: {name: InstallAntrea}}
+`,
+			},
+			load:      []string{"base.yml", "overlay.yml"},
+			wantVars:  map[string]string{"a": "base", "b": "overlay"},
+			wantTasks: []string{"ovs InstallOVS 2.14.0 nsx", "containers WindowsContainer", "antrea InstallAntrea"},
+			wantHosts: []string{"win-1 [ovs]", "win-2 [ovs]", "win-3 [antrea]"},
+		},
+		{
+			name: "file takes precedence over its includes",
+			files: map[string]string{
+				"main.yml": `
+include: [common.yml, site.yml]
+vars: {a: main}
+`,
+				"common.yml": `
+vars: {a: common, b: common, c: common}
+tasks:
+- {name: ovs, feature: {name: InstallOVS, keyValues: {ovsVersion: 2.13.1}}}
+`,
+				"site.yml": `
+vars: {b: site}
+tasks:
+- {name: ovs, feature: {keyValues: {ovsVersion: 2.14.0}}}
+`,
+			},
+			load:      []string{"main.yml"},
+			wantVars:  map[string]string{"a": "main", "b": "site", "c": "common"},
+			wantTasks: []string{"ovs InstallOVS 2.14.0"},
+		},
+		{
+			name: "later file takes precedence over the includes of earlier files",
+			files: map[string]string{
+				"main.yml":   "include: [common.yml]\nvars: {a: main}\n",
+				"common.yml": "vars: {a: common, b: common}\n",
+				"ci.yml":     "include: [common.yml]\nvars: {c: ci}\n",
+			},
+			load:     []string{"main.yml", "ci.yml"},
+			wantVars: map[string]string{"a": "common", "b": "common", "c": "ci"},
+		},
+		{
+			name: "glob include in order",
+			files: map[string]string{
+				"main.yml":   "include: ['site-*.yml']\n",
+				"site-a.yml": "vars: {a: a, b: a}\n",
+				"site-b.yml": "vars: {b: b}\n",
+			},
+			load:     []string{"main.yml"},
+			wantVars: map[string]string{"a": "a", "b": "b"},
+		},
+		{
+			name: "empty files",
+			files: map[string]string{
+				"main.yml":  "include: [empty.yml]\nvars: {a: main}\n",
+				"empty.yml": "",
+			},
+			load:     []string{"main.yml", "empty.yml"},
+			wantVars: map[string]string{"a": "main"},
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"a.yml": "include: [b.yml]\n",
+				"b.yml": "include: [c.yml]\n",
+				"c.yml": "include: [a.yml]\n",
+			},
+			load:    []string{"a.yml"},
+			wantErr: "c.yml:1:11: include[0]: include cycle ",
+		},
+		{
+			name: "self include",
+			files: map[string]string{
+				"a.yml": "include: [a.yml]\n",
+			},
+			load:    []string{"a.yml"},
+			wantErr: "a.yml:1:11: include[0]: include cycle ",
+		},
+		{
+			name: "file included twice is not a cycle",
+			files: map[string]string{
+				"main.yml":   "include: [a.yml, b.yml]\n",
+				"a.yml":      "include: [common.yml]\n",
+				"b.yml":      "include: [common.yml]\n",
+				"common.yml": "vars: {a: common}\n",
+			},
+			load:     []string{"main.yml"},
+			wantVars: map[string]string{"a": "common"},
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"main.yml": "vars: {a: main}\ninclude:\n- missing.yml\n",
+			},
+			load:    []string{"main.yml"},
+			wantErr: "main.yml:3:3: include[0]: failed to include file",
+		},
+		{
+			name: "glob matches no file",
+			files: map[string]string{
+				"main.yml": "include: ['site-*.yml']\n",
+			},
+			load:    []string{"main.yml"},
+			wantErr: `main.yml:1:11: include[0]: "site-*.yml" matches no file`,
+		},
+		{
+			name: "include is not a sequence",
+			files: map[string]string{
+				"main.yml": "include: common.yml\n",
+			},
+			load:    []string{"main.yml"},
+			wantErr: "main.yml:1:10: include: expected a sequence",
+		},
+		{
+			name: "invalid YAML in an include",
+			files: map[string]string{
+				"main.yml":   "include: [common.yml]\n",
+				"common.yml": "vars:\n  a: [b\n",
+			},
+			load:    []string{"main.yml"},
+			wantErr: "common.yml:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := loadDocument(t, tt.files, tt.load...)
+			if tt.wantErr != "" {
+				if _, ok := err.(config.ValidationErrors); !ok || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadDocument returned error %v, want ValidationErrors %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadDocument failed: %v", err)
+			}
+			ciConfig := &config.CIConfig{}
+			if err := doc.Decode(ciConfig); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(ciConfig.Vars, tt.wantVars) {
+				t.Errorf("vars %v, want %v", ciConfig.Vars, tt.wantVars)
+			}
+			var tasks []string
+			for _, task := range ciConfig.Tasks {
+				fields := []string{task.Name, task.Feature.Name}
+				for _, key := range []string{"ovsVersion", "ovsType"} {
+					if value, ok := task.Feature.KeyValues[key]; ok {
+						fields = append(fields, value)
+					}
+				}
+				tasks = append(tasks, strings.Join(fields, " "))
+			}
+			if !reflect.DeepEqual(tasks, tt.wantTasks) {
+				t.Errorf("tasks %q, want %q", tasks, tt.wantTasks)
+			}
+			var hosts []string
+			for _, host := range ciConfig.Hosts {
+				hosts = append(hosts, host.Host+" ["+strings.Join(host.Tasks, " ")+"]")
+			}
+			if !reflect.DeepEqual(hosts, tt.wantHosts) {
+				t.Errorf("hosts %q, want %q", hosts, tt.wantHosts)
+			}
+		})
+	}
+}
@@ -1,15 +1,47 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
-	"github.com/masterzen/winrm"
-	"golang.org/x/crypto/ssh"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"github.com/ruicao93/antrea-windows-ci/pkg/state"
 	"k8s.io/klog"
+	"net"
+	"strconv"
 	"time"
 )
 
 const (
 	DefaultUser = "Administrator"
+
+	DefaultWinRMPort             = 5985
+	DefaultWinRMHTTPSPort        = 5986
+	DefaultWinRMOperationTimeout = 60 * time.Second
+
+	// WinRMAuthBasic, WinRMAuthNTLM and WinRMAuthCertificate are the
+	// authentication methods of WinRM.
+	WinRMAuthBasic       = "basic"
+	WinRMAuthNTLM        = "ntlm"
+	WinRMAuthCertificate = "certificate"
+	// winrmAuthKerberos is rejected by validation, the WinRM transport cannot
+	// authenticate with Kerberos.
+	winrmAuthKerberos = "kerberos"
+
+	DefaultSSHPort           = 22
+	DefaultSSHTimeout        = 10 * time.Second
+	DefaultSSHKnownHostsFile = "~/.ssh/known_hosts"
+
+	DefaultRebootDownTimeout  = 3 * time.Minute
+	DefaultRebootUpTimeout    = 10 * time.Minute
+	DefaultRebootPollInterval = 10 * time.Second
+
+	// ProbeWinRM, ProbeSSH and ProbeServicePrefix followed by a service name
+	// are the readiness probes of a restarted host.
+	ProbeWinRM         = "winrm"
+	ProbeSSH           = "ssh"
+	ProbeServicePrefix = "service:"
 )
 
 type Feature struct {
@@ -18,24 +50,218 @@ type Feature struct {
 	KeyValues map[string]string `yaml:"keyValues,omitempty"`
 }
 
+// Fingerprint identifies the name and parameters of the feature, it changes
+// if any of them changes.
+func (feature *Feature) Fingerprint() string {
+	// The keys of maps are sorted by json.Marshal.
+	data, _ := json.Marshal(feature)
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
 type Task struct {
 	Name    string  `yaml:"name"`
 	Feature Feature `yaml:"feature"`
+	// DependsOn are the names of the tasks which must succeed on a host before
+	// the task runs on it, the tasks must be assigned to the same hosts.
+	DependsOn []string `yaml:"dependsOn,omitempty"`
+	// Parallel marks the task as safe to run together with other parallel
+	// tasks of a host, other tasks run alone in dependency and config order.
+	Parallel bool `yaml:"parallel,omitempty"`
+	// RebootBarrier restarts the host after the task if any task requires a
+	// restart, before later tasks start. Restarts are otherwise deferred until
+	// a task depends on a task requiring one, or until all tasks are applied.
+	RebootBarrier bool `yaml:"rebootBarrier,omitempty"`
+	// Timeout limits the detect, apply and verify phases of the task on a
+	// host, 0 means no limit. Restarts are limited by the reboot timeouts of
+	// the host instead.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Retry overrides the fields of the global retry policy it sets for the
+	// commands of the task. The commands of the apply and rollback phases are
+	// never retried.
+	Retry *executor.RetryPolicy `yaml:"retry,omitempty"`
+}
+
+type TaskStatus string
+
+const (
+	TaskPending   TaskStatus = "pending"
+	TaskSucceeded TaskStatus = "succeeded"
+	TaskFailed    TaskStatus = "failed"
+	TaskSkipped   TaskStatus = "skipped"
+	// TaskRebootPending tasks are applied and wait for a restart of the host
+	// to be verified.
+	TaskRebootPending TaskStatus = "reboot pending"
+)
+
+// TaskResult is the result of a task on a host, Error is the failure or the
+// reason the task was skipped.
+type TaskResult struct {
+	Task      string
+	Status    TaskStatus
+	Error     error
+	StartTime time.Time
+	EndTime   time.Time
+	// Duration is the time spent in the task, the restarts of the host are
+	// not included.
+	Duration time.Duration
+	// Retries is the number of command attempts of the task which were
+	// retried.
+	Retries int
+	// Reboots is the number of restarts of the host the task waited for.
+	Reboots int
+	// Resumed is set if the task succeeded with the same parameters in the
+	// resumed run, so it was not applied again.
+	Resumed bool
+	// Stdout and Stderr are the end of the output of the commands of the
+	// task.
+	Stdout string
+	Stderr string
+}
+
+// RebootConfig configures how a restarted host is waited for.
+type RebootConfig struct {
+	// DownTimeout is how long to wait for the host to go down, or to report a
+	// new boot time, after Restart-Computer.
+	DownTimeout time.Duration `yaml:"downTimeout,omitempty"`
+	// UpTimeout is how long to wait for the host to report a new boot time and
+	// pass all probes after it went down.
+	UpTimeout    time.Duration `yaml:"upTimeout,omitempty"`
+	PollInterval time.Duration `yaml:"pollInterval,omitempty"`
+	// Probes must all pass before the host is ready, e.g. "winrm", "ssh" or
+	// "service:docker" for a running service.
+	Probes []string `yaml:"probes,omitempty"`
+}
+
+// RolloutConfig configures how many hosts are applied at the same time.
+type RolloutConfig struct {
+	// MaxParallel limits the hosts applied at the same time, 0 means no limit.
+	MaxParallel int `yaml:"maxParallel,omitempty"`
+	// BatchSize splits the hosts into batches in config order, a batch starts
+	// once all hosts of the previous batch completed. 0 means a single batch.
+	BatchSize int `yaml:"batchSize,omitempty"`
+	// MaxFailures is the number of failed hosts tolerated, once more hosts
+	// failed no further host is started. nil means no limit.
+	MaxFailures *int `yaml:"maxFailures,omitempty"`
+	// MaxFailurePercentage is MaxFailures as a percentage of all hosts.
+	MaxFailurePercentage *int `yaml:"maxFailurePercentage,omitempty"`
+	// Pause is how long to wait between batches.
+	Pause time.Duration `yaml:"pause,omitempty"`
+}
+
+// SSHConfig configures the SSH connection of a host. The password of the host
+// is tried after the private key and the agent if it is set.
+type SSHConfig struct {
+	// Port is the port of the SSH server, 22 by default.
+	Port int `yaml:"port,omitempty"`
+	// PrivateKeyFile is an unencrypted private key to authenticate with.
+	PrivateKeyFile string `yaml:"privateKeyFile,omitempty"`
+	// Agent authenticates with the keys of the ssh-agent at SSH_AUTH_SOCK.
+	Agent bool `yaml:"agent,omitempty"`
+	// KnownHostsFile verifies the host key, ~/.ssh/known_hosts by default.
+	KnownHostsFile string `yaml:"knownHostsFile,omitempty"`
+	// TrustOnFirstUse adds the key of a host which is not in KnownHostsFile
+	// to it instead of rejecting the host, a changed key is still rejected.
+	TrustOnFirstUse bool `yaml:"trustOnFirstUse,omitempty"`
+	// InsecureIgnoreHostKey accepts any host key.
+	InsecureIgnoreHostKey bool `yaml:"insecureIgnoreHostKey,omitempty"`
+	// Timeout limits connecting to the server and the handshake, 10s by
+	// default.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// WinRMConfig configures the WinRM connection of a host.
+type WinRMConfig struct {
+	// HTTPS connects over HTTPS, the server certificate is verified with the
+	// system roots unless CACertFile or CertFingerprint is set.
+	HTTPS bool `yaml:"https,omitempty"`
+	// CACertFile is a PEM bundle of the CAs which verify the server
+	// certificate.
+	CACertFile string `yaml:"caCertFile,omitempty"`
+	// CertFingerprint pins the SHA-256 fingerprint of the server certificate
+	// in hex, the certificate chain and name are not verified then.
+	CertFingerprint string `yaml:"certFingerprint,omitempty"`
+	// TLSServerName is the name the server certificate is verified for, the
+	// host by default.
+	TLSServerName string `yaml:"tlsServerName,omitempty"`
+	// InsecureSkipVerify accepts any server certificate.
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify,omitempty"`
+	// Auth is the authentication method, basic, ntlm or certificate. Basic by
+	// default. Kerberos is not supported, domain accounts can use ntlm.
+	Auth string `yaml:"auth,omitempty"`
+	// ClientCertFile and ClientKeyFile are the PEM certificate and key of the
+	// certificate authentication, which requires HTTPS.
+	ClientCertFile string `yaml:"clientCertFile,omitempty"`
+	ClientKeyFile  string `yaml:"clientKeyFile,omitempty"`
+	// OperationTimeout is how long the server may take to respond to a
+	// request, 60s by default.
+	OperationTimeout time.Duration `yaml:"operationTimeout,omitempty"`
+}
+
+// Credential is a user and password shared by the hosts which reference it
+// by name.
+type Credential struct {
+	Name     string `yaml:"name"`
+	User     string `yaml:"user,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordFrom references the password instead, e.g. "env:VAR",
+	// "file:/path" or "vault:name".
+	PasswordFrom string `yaml:"passwordFrom,omitempty"`
+}
+
+// VaultConfig configures the encrypted vault of the "vault:" references.
+type VaultConfig struct {
+	File string `yaml:"file,omitempty"`
+	// PasswordFrom references the password of the vault, "env:" or "file:",
+	// env:CI_VAULT_PASSWORD by default.
+	PasswordFrom string `yaml:"passwordFrom,omitempty"`
 }
 
 type HostConfig struct {
-	Host     string   `yaml:"host"`
-	Port     int      `yaml:"port"`
-	User     string   `yaml:"user,omitempty"`
-	DryRun   bool     `yaml:"dryRun,omitempty"`
-	Password string   `yaml:"password"`
-	Tasks    []string `yaml:"tasks"`
+	Host string `yaml:"host"`
+	// Port is the port of the WinRM service, 5985 by default or 5986 with
+	// HTTPS.
+	Port     int    `yaml:"port,omitempty"`
+	User     string `yaml:"user,omitempty"`
+	DryRun   bool   `yaml:"dryRun,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordFrom references the password instead, e.g. "env:VAR",
+	// "file:/path" or "vault:name".
+	PasswordFrom string `yaml:"passwordFrom,omitempty"`
+	// Credential is the name of the credential providing the password, and
+	// the user unless User is set.
+	Credential string   `yaml:"credential,omitempty"`
+	Tasks      []string `yaml:"tasks"`
+	// Groups are the names of the groups of the host, it inherits their
+	// defaults, tasks, labels and vars.
+	Groups []string `yaml:"groups,omitempty"`
+	// Labels select the host with the -selector flag.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	Vars   map[string]string `yaml:"vars,omitempty"`
+	Reboot RebootConfig      `yaml:"reboot,omitempty"`
+	SSH    SSHConfig         `yaml:"ssh,omitempty"`
+	WinRM  WinRMConfig       `yaml:"winrm,omitempty"`
 }
 
 type CIConfig struct {
 	Hosts  []HostConfig `yaml:"hosts"`
+	Groups []Group      `yaml:"groups,omitempty"`
 	Tasks  []Task       `yaml:"tasks"`
 	DryRun bool         `yaml:"dryRun,omitempty"`
+	// Timeout limits the whole run, 0 means no limit.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	Rollout RolloutConfig `yaml:"rollout,omitempty"`
+	// Retry is the retry policy of the commands run on the hosts, except the
+	// commands which change the hosts, e.g. those of the apply and rollback
+	// phases of the tasks.
+	Retry       executor.RetryPolicy `yaml:"retry,omitempty"`
+	Credentials []Credential         `yaml:"credentials,omitempty"`
+	Vault       VaultConfig          `yaml:"vault,omitempty"`
+	// Vars are the variables of all hosts, referenced as "${name}" by the
+	// args and key values of the features.
+	Vars map[string]string `yaml:"vars,omitempty"`
+	// Overrides are the variables set on the command line, they take
+	// precedence over the vars of the config file.
+	Overrides map[string]string `yaml:"-"`
 }
 
 type Host struct {
@@ -43,49 +269,111 @@ type Host struct {
 	Tasks      []*Task
 	Success    bool
 	Error      error
-	Client     *winrm.Client
-	SSHClient  *ssh.Client
+	// Interrupted is set if the host did not complete because the run was
+	// cancelled or timed out.
+	Interrupted bool
+	// Skipped is set if the host was not started because the rollout was
+	// aborted after too many hosts failed.
+	Skipped bool
+	// Unreachable is set if the host failed the reachability preflight, Error
+	// is the connection error.
+	Unreachable bool
+	// TaskResults are the results of Tasks, in the same order.
+	TaskResults []*TaskResult
+	StartTime   time.Time
+	EndTime     time.Time
+	// Reboots is the number of restarts of the host.
+	Reboots int
+	// State records the results of the tasks of the run, it is nil if the run
+	// is not recorded, e.g. a dry run.
+	State *state.State
+	// Executor runs commands over WinRM, it connects on the first command.
+	Executor executor.Executor
+	// SSHExecutor runs commands over SSH, it is used for long running commands
+	// which may break the WinRM connection, e.g. OVS installation. It connects
+	// on the first command and reconnects after the connection broke.
+	SSHExecutor executor.Executor
 }
 
-func (hostConfig *HostConfig) SetDefaults() {
-	if hostConfig.User == "" {
-		hostConfig.User = DefaultUser
+func (host *Host) Close() {
+	if host.Executor != nil {
+		host.Executor.Close()
+	}
+	if host.SSHExecutor != nil {
+		host.SSHExecutor.Close()
 	}
 }
 
-func (ciConfig *CIConfig) SetDefaults() {
-	for _, hostConfig := range ciConfig.Hosts {
-		hostConfig.SetDefaults()
+func (rebootConfig *RebootConfig) SetDefaults() {
+	if rebootConfig.DownTimeout == 0 {
+		rebootConfig.DownTimeout = DefaultRebootDownTimeout
+	}
+	if rebootConfig.UpTimeout == 0 {
+		rebootConfig.UpTimeout = DefaultRebootUpTimeout
+	}
+	if rebootConfig.PollInterval == 0 {
+		rebootConfig.PollInterval = DefaultRebootPollInterval
+	}
+	if len(rebootConfig.Probes) == 0 {
+		rebootConfig.Probes = []string{ProbeWinRM}
+	}
+}
+
+func (sshConfig *SSHConfig) SetDefaults() {
+	if sshConfig.Port == 0 {
+		sshConfig.Port = DefaultSSHPort
+	}
+	if sshConfig.KnownHostsFile == "" {
+		sshConfig.KnownHostsFile = DefaultSSHKnownHostsFile
+	}
+	if sshConfig.Timeout == 0 {
+		sshConfig.Timeout = DefaultSSHTimeout
 	}
 }
 
-func (feature *Feature) GetValue(key string) string {
-	if value, ok := feature.KeyValues[key]; ok {
-		return value
-	} else {
-		return ""
+func (winrmConfig *WinRMConfig) SetDefaults() {
+	if winrmConfig.Auth == "" {
+		winrmConfig.Auth = WinRMAuthBasic
+	}
+	if winrmConfig.OperationTimeout == 0 {
+		winrmConfig.OperationTimeout = DefaultWinRMOperationTimeout
+	}
+}
+
+func (hostConfig *HostConfig) SetDefaults() {
+	if hostConfig.User == "" {
+		hostConfig.User = DefaultUser
+	}
+	if hostConfig.Port == 0 {
+		hostConfig.Port = DefaultWinRMPort
+		if hostConfig.WinRM.HTTPS {
+			hostConfig.Port = DefaultWinRMHTTPSPort
+		}
 	}
+	hostConfig.Reboot.SetDefaults()
+	hostConfig.SSH.SetDefaults()
+	hostConfig.WinRM.SetDefaults()
 }
 
-func NewWinRMClient(hostConfig *HostConfig) (*winrm.Client, error) {
-	endpoint := winrm.NewEndpoint(hostConfig.Host, hostConfig.Port, false, true, nil, nil, nil, 0)
-	client, err := winrm.NewClient(endpoint, hostConfig.User, hostConfig.Password)
-	if err != nil {
-		return nil, err
+func (ciConfig *CIConfig) SetDefaults() {
+	ciConfig.Retry.SetDefaults()
+	for i := range ciConfig.Hosts {
+		ciConfig.Hosts[i].SetDefaults()
 	}
-	return client, nil
 }
 
-func NewSSHClient(hostConfig *HostConfig) (*ssh.Client, error) {
-	sshConfig := &ssh.ClientConfig{
-		Timeout:         time.Second, //ssh 连接time out 时间一秒钟, 如果ssh验证错误 会在一秒内返回
-		User:            hostConfig.User,
-		Auth:            []ssh.AuthMethod{ssh.Password(hostConfig.Password)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), //这个可以， 但是不够安全
-		//HostKeyCallback: hostKeyCallBackFunc(h.Host),
+// SSHAddr returns the address of the SSH server of the host.
+func (hostConfig *HostConfig) SSHAddr() string {
+	port := hostConfig.SSH.Port
+	if port == 0 {
+		port = DefaultSSHPort
 	}
-	addr := fmt.Sprintf("%s:%d", hostConfig.Host, 22)
-	return ssh.Dial("tcp", addr, sshConfig)
+	return net.JoinHostPort(hostConfig.Host, strconv.Itoa(port))
+}
+
+// WinRMAddr returns the address of the WinRM service of the host.
+func (hostConfig *HostConfig) WinRMAddr() string {
+	return net.JoinHostPort(hostConfig.Host, strconv.Itoa(hostConfig.winrmPort()))
 }
 
 func NewTasks(ciConfig *CIConfig) (map[string]*Task, error) {
@@ -97,28 +385,43 @@ func NewTasks(ciConfig *CIConfig) (map[string]*Task, error) {
 	return taskMap, nil
 }
 
+// NewHosts returns the hosts of the config, their executors connect on the
+// first command so no host is connected yet. The tasks of a host are copies
+// with the variables of the host expanded.
 func NewHosts(ciConfig *CIConfig, taskMap map[string]*Task) ([]*Host, error) {
-	var err error
 	hosts := make([]*Host, 0, len(ciConfig.Hosts))
 	for i := 0; i < len(ciConfig.Hosts); i++ {
 		hostConfig := &ciConfig.Hosts[i]
 		host := Host{HostConfig: hostConfig}
 		//host.Tasks = []*Task{}
+		vars := ciConfig.HostVars(hostConfig)
 		for _, taskName := range hostConfig.Tasks {
 			if task, ok := taskMap[taskName]; !ok {
 				return hosts, fmt.Errorf("host %s uses a undefiend task %s", hostConfig.Host, taskName)
 			} else {
-				host.Tasks = append(host.Tasks, task)
+				renderedTask, err := renderTask(task, vars)
+				if err != nil {
+					return hosts, fmt.Errorf("failed to render task %s for host %s: %v", taskName, hostConfig.Host, err)
+				}
+				host.Tasks = append(host.Tasks, renderedTask)
 			}
 		}
-		if host.Client, err = NewWinRMClient(host.HostConfig); err != nil {
-			return hosts, fmt.Errorf("failed to init winrm client for host %s: %v", hostConfig.Host, err)
-		}
-
-		if host.SSHClient, err = NewSSHClient(host.HostConfig); err != nil {
-			return hosts, fmt.Errorf("failed to init ssh client for host %s: %v", hostConfig.Host, err)
-		}
-
+		winrmExecutor := executor.NewLazyExecutor(func() (executor.Executor, error) {
+			winrmClient, err := NewWinRMClient(hostConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to init winrm client for host %s: %v", hostConfig.Host, err)
+			}
+			return executor.NewWinRMExecutor(winrmClient), nil
+		})
+		host.Executor = executor.NewRetryExecutor(winrmExecutor, ciConfig.Retry, fmt.Sprintf("winrm://%s", hostConfig.Host))
+		sshExecutor := executor.NewLazyExecutor(func() (executor.Executor, error) {
+			sshClient, err := NewSSHClient(hostConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to init ssh client for host %s: %v", hostConfig.Host, err)
+			}
+			return executor.NewSSHExecutor(sshClient), nil
+		})
+		host.SSHExecutor = executor.NewRetryExecutor(sshExecutor, ciConfig.Retry, fmt.Sprintf("ssh://%s", hostConfig.Host))
 		hosts = append(hosts, &host)
 	}
 	return hosts, nil
@@ -134,6 +437,10 @@ func DumpTasks(taskMap map[string]*Task) {
 func DumpHosts(hosts []*Host) {
 	for _, host := range hosts {
 		klog.Infof("===========Host: %s=========", host.HostConfig.Host)
-		klog.Infof("%v", host.HostConfig)
+		hostConfig := *host.HostConfig
+		if hostConfig.Password != "" {
+			hostConfig.Password = secret.Redacted
+		}
+		klog.Infof("%v", &hostConfig)
 	}
 }
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+)
+
+// ResolveCredentials sets the user and password of the hosts from their
+// credentials and password references, it must be called before SetDefaults.
+// Only the credentials used by the hosts are resolved. All passwords,
+// including those in the config file, are registered to be redacted.
+func ResolveCredentials(ciConfig *CIConfig) error {
+	resolver := &secret.Resolver{
+		VaultFile:         ciConfig.Vault.File,
+		VaultPasswordFrom: ciConfig.Vault.PasswordFrom,
+	}
+	credentials := map[string]*Credential{}
+	for i := range ciConfig.Credentials {
+		credentials[ciConfig.Credentials[i].Name] = &ciConfig.Credentials[i]
+	}
+	resolved := map[string]bool{}
+	for i := range ciConfig.Hosts {
+		hostConfig := &ciConfig.Hosts[i]
+		if hostConfig.Credential != "" {
+			credential, ok := credentials[hostConfig.Credential]
+			if !ok {
+				return fmt.Errorf("host %s uses an undefined credential %s", hostConfig.Host, hostConfig.Credential)
+			}
+			if !resolved[credential.Name] && credential.PasswordFrom != "" {
+				password, err := resolver.Resolve(credential.PasswordFrom)
+				if err != nil {
+					return fmt.Errorf("failed to resolve password of credential %s: %v", credential.Name, err)
+				}
+				credential.Password = password
+			}
+			resolved[credential.Name] = true
+			secret.Register(credential.Password)
+			if hostConfig.User == "" {
+				hostConfig.User = credential.User
+			}
+			hostConfig.Password = credential.Password
+		}
+		if hostConfig.PasswordFrom != "" {
+			password, err := resolver.Resolve(hostConfig.PasswordFrom)
+			if err != nil {
+				return fmt.Errorf("failed to resolve password of host %s: %v", hostConfig.Host, err)
+			}
+			hostConfig.Password = password
+		}
+		secret.Register(hostConfig.Password)
+	}
+	return nil
+}
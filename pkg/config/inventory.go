@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/labels"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// Group is a named set of hosts, the hosts list the groups they belong to.
+type Group struct {
+	Name string `yaml:"name"`
+	// Defaults sets the fields which the hosts of the group leave unset, e.g.
+	// port, user, credential, ssh or winrm. Host, tasks, groups, labels and
+	// vars are set on the group instead.
+	Defaults HostConfig `yaml:"defaults,omitempty"`
+	// Tasks are assigned to the hosts of the group before their own tasks.
+	Tasks  []string          `yaml:"tasks,omitempty"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+	Vars   map[string]string `yaml:"vars,omitempty"`
+}
+
+// ApplyGroups merges the defaults, tasks, labels and vars of their groups
+// into the hosts, it must be called before ResolveCredentials. A host inherits
+// from its groups in the order it lists them, its own values take precedence.
+func ApplyGroups(ciConfig *CIConfig) error {
+	groups := groupMap(ciConfig)
+	for i := range ciConfig.Hosts {
+		if err := applyGroups(&ciConfig.Hosts[i], groups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupMap maps the names of the groups to the first group with the name.
+func groupMap(ciConfig *CIConfig) map[string]*Group {
+	groups := map[string]*Group{}
+	for i := range ciConfig.Groups {
+		if _, ok := groups[ciConfig.Groups[i].Name]; !ok {
+			groups[ciConfig.Groups[i].Name] = &ciConfig.Groups[i]
+		}
+	}
+	return groups
+}
+
+// applyGroups merges the groups into the host, an undefined group is skipped
+// and returned as an error.
+func applyGroups(hostConfig *HostConfig, groups map[string]*Group) error {
+	var tasks []string
+	seenTasks := map[string]bool{}
+	addTasks := func(names []string) {
+		for _, name := range names {
+			if !seenTasks[name] {
+				seenTasks[name] = true
+				tasks = append(tasks, name)
+			}
+		}
+	}
+	hostLabels := copyMap(hostConfig.Labels)
+	hostVars := copyMap(hostConfig.Vars)
+	var err error
+	for _, name := range hostConfig.Groups {
+		group, ok := groups[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("host %s uses an undefined group %s", hostConfig.Host, name)
+			}
+			continue
+		}
+		defaults := group.Defaults
+		// The password is inherited as a whole, so the password of a host
+		// is not replaced by the credential of its group.
+		if hostConfig.Password != "" || hostConfig.PasswordFrom != "" || hostConfig.Credential != "" {
+			defaults.Password, defaults.PasswordFrom, defaults.Credential = "", "", ""
+		}
+		fillDefaults(reflect.ValueOf(hostConfig).Elem(), reflect.ValueOf(defaults))
+		addTasks(group.Tasks)
+		hostLabels = mergeMap(hostLabels, group.Labels)
+		hostVars = mergeMap(hostVars, group.Vars)
+	}
+	addTasks(hostConfig.Tasks)
+	hostConfig.Tasks = tasks
+	hostConfig.Labels = hostLabels
+	hostConfig.Vars = hostVars
+	return err
+}
+
+// fillDefaults sets the zero fields of dst to the fields of defaults, nested
+// structs are filled field by field.
+func fillDefaults(dst, defaults reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			fillDefaults(field, defaults.Field(i))
+		} else if field.IsZero() {
+			field.Set(defaults.Field(i))
+		}
+	}
+}
+
+func copyMap(m map[string]string) map[string]string {
+	return mergeMap(nil, m)
+}
+
+// mergeMap adds the keys of defaults which m does not have to m.
+func mergeMap(m, defaults map[string]string) map[string]string {
+	for key, value := range defaults {
+		if m == nil {
+			m = map[string]string{}
+		}
+		if _, ok := m[key]; !ok {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+// HostFilter selects the hosts of a run by name, group and labels.
+type HostFilter struct {
+	// Limit are patterns of the names of the hosts and groups to select as
+	// matched by path.Match, e.g. "10.176.26.*" or "containerd".
+	Limit    []string
+	Selector labels.Selector
+}
+
+// NewHostFilter parses a comma separated list of host and group patterns, and
+// a label selector like "runtime=containerd,os!=2019". Empty strings select
+// all hosts.
+func NewHostFilter(limit, selector string) (*HostFilter, error) {
+	filter := &HostFilter{}
+	for _, pattern := range strings.Split(limit, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid limit pattern %q: %v", pattern, err)
+		}
+		filter.Limit = append(filter.Limit, pattern)
+	}
+	if selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+		}
+		filter.Selector = parsed
+	}
+	return filter, nil
+}
+
+// Empty returns whether the filter selects all hosts.
+func (f *HostFilter) Empty() bool {
+	return len(f.Limit) == 0 && f.Selector == nil
+}
+
+// Match returns whether the host matches a limit pattern, by its name or the
+// name of one of its groups, and the selector.
+func (f *HostFilter) Match(hostConfig *HostConfig) bool {
+	if f.Selector != nil && !f.Selector.Matches(labels.Set(hostConfig.Labels)) {
+		return false
+	}
+	if len(f.Limit) == 0 {
+		return true
+	}
+	for _, pattern := range f.Limit {
+		if matchPattern(pattern, hostConfig.Host) {
+			return true
+		}
+		for _, group := range hostConfig.Groups {
+			if matchPattern(pattern, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern, name string) bool {
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// FilterHosts removes the hosts which do not match filter from the config, it
+// must be called after ApplyGroups. A limit pattern which matches no host and
+// no group is an error, as it is likely a typo.
+func FilterHosts(ciConfig *CIConfig, filter *HostFilter) error {
+	for _, pattern := range filter.Limit {
+		if !limitMatchesAny(ciConfig, pattern) {
+			return fmt.Errorf("limit %q matches no host and no group", pattern)
+		}
+	}
+	var selected []HostConfig
+	for i := range ciConfig.Hosts {
+		if filter.Match(&ciConfig.Hosts[i]) {
+			selected = append(selected, ciConfig.Hosts[i])
+		}
+	}
+	ciConfig.Hosts = selected
+	return nil
+}
+
+func limitMatchesAny(ciConfig *CIConfig, pattern string) bool {
+	for i := range ciConfig.Hosts {
+		if matchPattern(pattern, ciConfig.Hosts[i].Host) {
+			return true
+		}
+	}
+	for i := range ciConfig.Groups {
+		if matchPattern(pattern, ciConfig.Groups[i].Name) {
+			return true
+		}
+	}
+	return false
+}
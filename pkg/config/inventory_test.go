@@ -0,0 +1,253 @@
+package config_test
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyGroups(t *testing.T) {
+	groups := []config.Group{
+		{
+			Name:     "containerd",
+			Defaults: config.HostConfig{User: "ci", Port: 5986, Credential: "ci-admin", Reboot: config.RebootConfig{PollInterval: 5 * time.Second}},
+			Tasks:    []string{"containers", "ovs"},
+			Labels:   map[string]string{"runtime": "containerd", "os": "2019"},
+			Vars:     map[string]string{"ovsVersion": "2.14.0"},
+		},
+		{
+			Name:     "nsx",
+			Defaults: config.HostConfig{User: "nsx", WinRM: config.WinRMConfig{HTTPS: true}},
+			Tasks:    []string{"ovs", "nsx"},
+			Labels:   map[string]string{"runtime": "docker", "ovs": "nsx"},
+			Vars:     map[string]string{"ovsVersion": "2.13.1", "ovsType": "nsx"},
+		},
+	}
+	tests := []struct {
+		name       string
+		host       config.HostConfig
+		wantUser   string
+		wantPort   int
+		wantCred   string
+		wantHTTPS  bool
+		wantPoll   time.Duration
+		wantTasks  []string
+		wantLabels map[string]string
+		wantVars   map[string]string
+		wantErr    string
+	}{
+		{
+			name:      "no group",
+			host:      config.HostConfig{Host: "win-1", User: "admin", Tasks: []string{"containers"}},
+			wantUser:  "admin",
+			wantTasks: []string{"containers"},
+		},
+		{
+			name:       "group defaults",
+			host:       config.HostConfig{Host: "win-1", Groups: []string{"containerd"}, Tasks: []string{"antrea"}},
+			wantUser:   "ci",
+			wantPort:   5986,
+			wantCred:   "ci-admin",
+			wantPoll:   5 * time.Second,
+			wantTasks:  []string{"containers", "ovs", "antrea"},
+			wantLabels: map[string]string{"runtime": "containerd", "os": "2019"},
+			wantVars:   map[string]string{"ovsVersion": "2.14.0"},
+		},
+		{
+			name:       "host values take precedence",
+			host:       config.HostConfig{Host: "win-1", User: "admin", Port: 5985, Groups: []string{"containerd"}, Labels: map[string]string{"os": "2022"}, Vars: map[string]string{"ovsVersion": "2.15.0"}},
+			wantUser:   "admin",
+			wantPort:   5985,
+			wantCred:   "ci-admin",
+			wantPoll:   5 * time.Second,
+			wantTasks:  []string{"containers", "ovs"},
+			wantLabels: map[string]string{"runtime": "containerd", "os": "2022"},
+			wantVars:   map[string]string{"ovsVersion": "2.15.0"},
+		},
+		{
+			name:       "host password replaces the group credential",
+			host:       config.HostConfig{Host: "win-1", PasswordFrom: "env:PASSWORD", Groups: []string{"containerd"}},
+			wantUser:   "ci",
+			wantPort:   5986,
+			wantPoll:   5 * time.Second,
+			wantTasks:  []string{"containers", "ovs"},
+			wantLabels: map[string]string{"runtime": "containerd", "os": "2019"},
+			wantVars:   map[string]string{"ovsVersion": "2.14.0"},
+		},
+		{
+			name:       "first group takes precedence",
+			host:       config.HostConfig{Host: "win-1", Groups: []string{"nsx", "containerd"}, Tasks: []string{"ovs"}},
+			wantUser:   "nsx",
+			wantPort:   5986,
+			wantCred:   "ci-admin",
+			wantHTTPS:  true,
+			wantPoll:   5 * time.Second,
+			wantTasks:  []string{"ovs", "nsx", "containers"},
+			wantLabels: map[string]string{"runtime": "docker", "ovs": "nsx", "os": "2019"},
+			wantVars:   map[string]string{"ovsVersion": "2.13.1", "ovsType": "nsx"},
+		},
+		{
+			name:       "undefined group",
+			host:       config.HostConfig{Host: "win-1", Groups: []string{"missing", "containerd"}},
+			wantUser:   "ci",
+			wantPort:   5986,
+			wantCred:   "ci-admin",
+			wantPoll:   5 * time.Second,
+			wantTasks:  []string{"containers", "ovs"},
+			wantLabels: map[string]string{"runtime": "containerd", "os": "2019"},
+			wantVars:   map[string]string{"ovsVersion": "2.14.0"},
+			wantErr:    "host win-1 uses an undefined group missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciConfig := &config.CIConfig{Hosts: []config.HostConfig{tt.host}, Groups: groups}
+			err := config.ApplyGroups(ciConfig)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("ApplyGroups failed: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("ApplyGroups returned error %v, want %q", err, tt.wantErr)
+			}
+			host := ciConfig.Hosts[0]
+			if host.User != tt.wantUser {
+				t.Errorf("user %q, want %q", host.User, tt.wantUser)
+			}
+			if host.Port != tt.wantPort {
+				t.Errorf("port %d, want %d", host.Port, tt.wantPort)
+			}
+			if host.Credential != tt.wantCred {
+				t.Errorf("credential %q, want %q", host.Credential, tt.wantCred)
+			}
+			if host.WinRM.HTTPS != tt.wantHTTPS {
+				t.Errorf("WinRM HTTPS %v, want %v", host.WinRM.HTTPS, tt.wantHTTPS)
+			}
+			if host.Reboot.PollInterval != tt.wantPoll {
+				t.Errorf("reboot poll interval %v, want %v", host.Reboot.PollInterval, tt.wantPoll)
+			}
+			if !reflect.DeepEqual(host.Tasks, tt.wantTasks) {
+				t.Errorf("tasks %v, want %v", host.Tasks, tt.wantTasks)
+			}
+			if !reflect.DeepEqual(host.Labels, tt.wantLabels) {
+				t.Errorf("labels %v, want %v", host.Labels, tt.wantLabels)
+			}
+			if !reflect.DeepEqual(host.Vars, tt.wantVars) {
+				t.Errorf("vars %v, want %v", host.Vars, tt.wantVars)
+			}
+		})
+	}
+}
+
+func TestHostFilter(t *testing.T) {
+	hosts := []config.HostConfig{
+		{Host: "10.176.26.1", Groups: []string{"containerd"}, Labels: map[string]string{"runtime": "containerd", "os": "2019"}},
+		{Host: "10.176.26.2", Groups: []string{"docker"}, Labels: map[string]string{"runtime": "docker", "os": "2019"}},
+		{Host: "10.176.27.1", Groups: []string{"containerd", "nsx"}, Labels: map[string]string{"runtime": "containerd", "os": "2022"}},
+		{Host: "win-build"},
+	}
+	groups := []config.Group{{Name: "containerd"}, {Name: "docker"}, {Name: "nsx"}, {Name: "empty"}}
+	tests := []struct {
+		name     string
+		limit    string
+		selector string
+		want     []string
+		// wantErr is a substring of the error of NewHostFilter or
+		// FilterHosts.
+		wantErr string
+	}{
+		{
+			name: "no filter",
+			want: []string{"10.176.26.1", "10.176.26.2", "10.176.27.1", "win-build"},
+		},
+		{
+			name:  "host name",
+			limit: "win-build",
+			want:  []string{"win-build"},
+		},
+		{
+			name:  "host pattern",
+			limit: "10.176.26.*",
+			want:  []string{"10.176.26.1", "10.176.26.2"},
+		},
+		{
+			name:  "group",
+			limit: "containerd",
+			want:  []string{"10.176.26.1", "10.176.27.1"},
+		},
+		{
+			name:  "hosts and groups",
+			limit: "nsx, win-build",
+			want:  []string{"10.176.27.1", "win-build"},
+		},
+		{
+			name:  "group without hosts",
+			limit: "empty",
+		},
+		{
+			name:     "selector",
+			selector: "runtime=containerd",
+			want:     []string{"10.176.26.1", "10.176.27.1"},
+		},
+		{
+			name:     "set based selector",
+			selector: "os in (2019),runtime!=docker",
+			want:     []string{"10.176.26.1"},
+		},
+		{
+			name:     "host without labels",
+			selector: "!runtime",
+			want:     []string{"win-build"},
+		},
+		{
+			name:     "limit and selector",
+			limit:    "10.176.*",
+			selector: "os=2022",
+			want:     []string{"10.176.27.1"},
+		},
+		{
+			name:    "limit matches nothing",
+			limit:   "10.176.28.*",
+			wantErr: `limit "10.176.28.*" matches no host and no group`,
+		},
+		{
+			name:    "invalid pattern",
+			limit:   "10.176.[",
+			wantErr: "invalid limit pattern",
+		},
+		{
+			name:     "invalid selector",
+			selector: "runtime in containerd",
+			wantErr:  "invalid selector",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciConfig := &config.CIConfig{Hosts: append([]config.HostConfig(nil), hosts...), Groups: groups}
+			filter, err := config.NewHostFilter(tt.limit, tt.selector)
+			if err == nil {
+				err = config.FilterHosts(ciConfig, filter)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("filter returned error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("filter failed: %v", err)
+			}
+			if got := filter.Empty(); got != (tt.limit == "" && tt.selector == "") {
+				t.Errorf("Empty() = %v", got)
+			}
+			var got []string
+			for _, host := range ciConfig.Hosts {
+				got = append(got, host.Host)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selected hosts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"k8s.io/klog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// knownHostsLock serializes the host key checks, so keys trusted on first use
+// by concurrent connections are not lost.
+var knownHostsLock sync.Mutex
+
+// NewSSHClient connects to the SSH server of the host with the settings of
+// hostConfig.SSH.
+func NewSSHClient(hostConfig *HostConfig) (*ssh.Client, error) {
+	sshConfig := hostConfig.SSH
+	sshConfig.SetDefaults()
+	var auth []ssh.AuthMethod
+	if sshConfig.PrivateKeyFile != "" {
+		signer, err := loadPrivateKey(sshConfig.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if sshConfig.Agent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, fmt.Errorf("cannot use ssh-agent, SSH_AUTH_SOCK is not set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to ssh-agent: %v", err)
+		}
+		// The agent is only used during the handshake.
+		defer conn.Close()
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	if hostConfig.Password != "" {
+		auth = append(auth, ssh.Password(hostConfig.Password))
+	}
+	hostKeyCallback, err := newHostKeyCallback(&sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig := &ssh.ClientConfig{
+		Timeout:         sshConfig.Timeout,
+		User:            hostConfig.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}
+	return ssh.Dial("tcp", hostConfig.SSHAddr(), clientConfig)
+}
+
+// expandHome replaces a leading "~" of path with the home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+func loadPrivateKey(path string) (ssh.Signer, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			return nil, fmt.Errorf("private key %s is encrypted, use an unencrypted key or ssh-agent", path)
+		}
+		return nil, fmt.Errorf("failed to parse private key %s: %v", path, err)
+	}
+	return signer, nil
+}
+
+// newHostKeyCallback verifies host keys against the known hosts file, and adds
+// unknown keys to it if they are trusted on first use.
+func newHostKeyCallback(sshConfig *SSHConfig) (ssh.HostKeyCallback, error) {
+	if sshConfig.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	path, err := expandHome(sshConfig.KnownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsLock.Lock()
+		defer knownHostsLock.Unlock()
+		if sshConfig.TrustOnFirstUse {
+			if err := touchFile(path); err != nil {
+				return fmt.Errorf("failed to create known hosts file %s: %v", path, err)
+			}
+		}
+		// The file is loaded for every connection, so it has the keys
+		// trusted by the previous ones.
+		callback, err := knownhosts.New(path)
+		if err != nil {
+			return fmt.Errorf("failed to load known hosts file %s: %v", path, err)
+		}
+		err = callback(hostname, remote, key)
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok || len(keyErr.Want) > 0 {
+			// The key is known, or it changed.
+			return err
+		}
+		if !sshConfig.TrustOnFirstUse {
+			return fmt.Errorf("host key %s of %s is not in known hosts file %s, add it or set trustOnFirstUse", ssh.FingerprintSHA256(key), hostname, path)
+		}
+		klog.Infof("Trust host key %s of %s on first use, add it to %s", ssh.FingerprintSHA256(key), hostname, path)
+		return appendKnownHost(path, hostname, remote, key)
+	}, nil
+}
+
+func touchFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func appendKnownHost(path, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil {
+		if address := knownhosts.Normalize(remote.String()); address != addresses[0] {
+			addresses = append(addresses, address)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known hosts file %s: %v", path, err)
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintln(file, knownhosts.Line(addresses, key)); err != nil {
+		return fmt.Errorf("failed to add host key to known hosts file %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,771 @@
+package config
+
+import (
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/dag"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/schema"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError is an error found in a config file. Line and Column are
+// 1-based, Column is 0 if it is unknown. It is formatted as
+// "file:line:column: path: message".
+type ValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	location := strconv.Itoa(e.Line)
+	if e.Column > 0 {
+		location = fmt.Sprintf("%d:%d", e.Line, e.Column)
+	}
+	if e.File != "" {
+		location = e.File + ":" + location
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", location, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, e.Path, e.Message)
+}
+
+// ValidationErrors are all errors found in a config file, sorted by location.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type validator struct {
+	errs ValidationErrors
+	// nodes maps the path of every value to its node.
+	nodes map[string]*yaml.Node
+	// files maps the nodes to their config files.
+	files map[*yaml.Node]string
+}
+
+// Validate validates the merged config files of doc. It reports unknown and
+// duplicated fields, mismatched types, undefined or duplicated tasks and hosts,
+// and arguments and key values which do not match the feature schemas. The
+// arguments and key values referencing variables are validated for every
+// host with its variables and overrides.
+// The returned error is a ValidationErrors if the file is invalid.
+func Validate(doc *Document, schemas map[string]*schema.Schema, overrides map[string]string) error {
+	if doc.root == nil {
+		return nil
+	}
+	v := &validator{nodes: map[string]*yaml.Node{}, files: doc.files}
+	v.walk(doc.root, reflect.TypeOf(CIConfig{}), "")
+	// Unknown fields are ignored by Decode, so the semantic checks still run
+	// unless a value has a mismatched type, which is already reported by walk.
+	ciConfig := CIConfig{}
+	if err := doc.Decode(&ciConfig); err != nil {
+		if len(v.errs) == 0 {
+			v.errorf(doc.root, "", "%v", err)
+		}
+	} else {
+		ciConfig.Overrides = overrides
+		if ciConfig.Timeout < 0 {
+			v.errorAt("timeout", "timeout must not be negative")
+		}
+		v.validateRollout(&ciConfig.Rollout, "rollout")
+		v.validateRetry(&ciConfig.Retry, "retry")
+		v.validateCredentials(&ciConfig)
+		v.validateTasks(&ciConfig, schemas)
+		v.validateGroups(&ciConfig)
+		v.validateHosts(&ciConfig, schemas)
+	}
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].File != v.errs[j].File {
+			return v.errs[i].File < v.errs[j].File
+		}
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	return v.errs
+}
+
+func (v *validator) errorf(node *yaml.Node, path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		File:    v.files[node],
+		Line:    node.Line,
+		Column:  node.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// errorAt reports an error at the node of path, or at the closest parent node
+// if path has no node, e.g. for a missing field.
+func (v *validator) errorAt(path string, format string, args ...interface{}) {
+	for p := path; ; {
+		if node, ok := v.nodes[p]; ok {
+			v.errorf(node, path, format, args...)
+			return
+		}
+		index := strings.LastIndexAny(p, ".[")
+		if index < 0 {
+			if node, ok := v.nodes[""]; ok {
+				v.errorf(node, path, format, args...)
+			} else {
+				v.errs = append(v.errs, &ValidationError{Line: 1, Path: path, Message: fmt.Sprintf(format, args...)})
+			}
+			return
+		}
+		p = p[:index]
+	}
+}
+
+func joinPath(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "." + child
+}
+
+func yamlFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("yaml")
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a sequence"
+	default:
+		return fmt.Sprintf("%q", node.Value)
+	}
+}
+
+// walk checks node against the Go type it is decoded into.
+func (v *validator) walk(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	v.nodes[path] = node
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(node, path, "expected a mapping, got %s", nodeKind(node))
+			return
+		}
+		fields := map[string]reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" || field.Tag.Get("yaml") == "-" {
+				continue
+			}
+			fields[yamlFieldName(field)] = field
+		}
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if seen[key.Value] {
+				v.errorf(key, path, "duplicated field %q", key.Value)
+				continue
+			}
+			seen[key.Value] = true
+			field, ok := fields[key.Value]
+			if !ok {
+				v.errorf(key, path, "unknown field %q%s", key.Value, suggestField(key.Value, fields))
+				continue
+			}
+			v.walk(value, field.Type, joinPath(path, key.Value))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.errorf(node, path, "expected a sequence, got %s", nodeKind(node))
+			return
+		}
+		for i, item := range node.Content {
+			v.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(node, path, "expected a mapping, got %s", nodeKind(node))
+			return
+		}
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if seen[key.Value] {
+				v.errorf(key, path, "duplicated key %q", key.Value)
+				continue
+			}
+			seen[key.Value] = true
+			v.walk(value, t.Elem(), joinPath(path, key.Value))
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			v.errorf(node, path, "expected a %s value, got %s", t.Kind(), nodeKind(node))
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			if t == durationType {
+				v.errorf(node, path, "invalid duration %q, expected e.g. 30s or 5m", node.Value)
+			} else {
+				v.errorf(node, path, "invalid %s value %q", t.Kind(), node.Value)
+			}
+		}
+	}
+}
+
+// suggestField returns a hint if name only differs in case from a known field,
+// e.g. "KeyValues" for "keyValues".
+func suggestField(name string, fields map[string]reflect.StructField) string {
+	for known := range fields {
+		if strings.EqualFold(known, name) {
+			return fmt.Sprintf(", did you mean %q?", known)
+		}
+	}
+	return ""
+}
+
+func (v *validator) validateRollout(rolloutConfig *RolloutConfig, path string) {
+	if rolloutConfig.MaxParallel < 0 {
+		v.errorAt(joinPath(path, "maxParallel"), "maxParallel must not be negative")
+	}
+	if rolloutConfig.BatchSize < 0 {
+		v.errorAt(joinPath(path, "batchSize"), "batchSize must not be negative")
+	}
+	if rolloutConfig.MaxFailures != nil && *rolloutConfig.MaxFailures < 0 {
+		v.errorAt(joinPath(path, "maxFailures"), "maxFailures must not be negative")
+	}
+	if percentage := rolloutConfig.MaxFailurePercentage; percentage != nil && (*percentage < 0 || *percentage > 100) {
+		v.errorAt(joinPath(path, "maxFailurePercentage"), "maxFailurePercentage must be between 0 and 100")
+	}
+	if rolloutConfig.Pause < 0 {
+		v.errorAt(joinPath(path, "pause"), "pause must not be negative")
+	}
+}
+
+func (v *validator) validateRetry(policy *executor.RetryPolicy, path string) {
+	if policy.MaxAttempts < 0 {
+		v.errorAt(joinPath(path, "maxAttempts"), "maxAttempts must not be negative")
+	}
+	if policy.Backoff < 0 {
+		v.errorAt(joinPath(path, "backoff"), "backoff must not be negative")
+	}
+	if policy.MaxBackoff < 0 {
+		v.errorAt(joinPath(path, "maxBackoff"), "maxBackoff must not be negative")
+	}
+	if policy.Jitter != nil && (*policy.Jitter < 0 || *policy.Jitter > 1) {
+		v.errorAt(joinPath(path, "jitter"), "jitter must be between 0 and 1")
+	}
+}
+
+func (v *validator) validateTasks(ciConfig *CIConfig, schemas map[string]*schema.Schema) {
+	taskNames := map[string]bool{}
+	for i := range ciConfig.Tasks {
+		task := &ciConfig.Tasks[i]
+		path := fmt.Sprintf("tasks[%d]", i)
+		if task.Name == "" {
+			v.errorAt(joinPath(path, "name"), "task name is required")
+		} else if taskNames[task.Name] {
+			v.errorAt(joinPath(path, "name"), "duplicated task %q", task.Name)
+		}
+		taskNames[task.Name] = true
+		if task.Timeout < 0 {
+			v.errorAt(joinPath(path, "timeout"), "timeout must not be negative")
+		}
+		if task.Retry != nil {
+			v.validateRetry(task.Retry, joinPath(path, "retry"))
+		}
+		featurePath := joinPath(path, "feature")
+		if v.validateTemplates(&task.Feature, featurePath) {
+			// The args and key values referencing variables are validated for
+			// every host with its variables.
+			feature := &task.Feature
+			v.validateFeature(feature, featurePath, schemas, "", func(err *schema.Error) bool {
+				return !isTemplatedError(feature, err)
+			})
+		} else {
+			v.validateFeature(&task.Feature, featurePath, schemas, "", nil)
+		}
+	}
+	v.validateDependencies(ciConfig, taskNames)
+}
+
+// validateDependencies reports undefined and duplicated dependencies, and
+// dependency cycles at the first task of the cycle.
+func (v *validator) validateDependencies(ciConfig *CIConfig, taskNames map[string]bool) {
+	graph := dag.New()
+	taskPaths := map[string]string{}
+	for i := range ciConfig.Tasks {
+		task := &ciConfig.Tasks[i]
+		path := fmt.Sprintf("tasks[%d]", i)
+		if _, ok := taskPaths[task.Name]; !ok {
+			taskPaths[task.Name] = path
+		}
+		graph.AddNode(task.Name)
+		dependencies := map[string]bool{}
+		for j, dependency := range task.DependsOn {
+			dependencyPath := fmt.Sprintf("%s.dependsOn[%d]", path, j)
+			if !taskNames[dependency] {
+				v.errorAt(dependencyPath, "undefined task %q", dependency)
+			} else if dependencies[dependency] {
+				v.errorAt(dependencyPath, "duplicated dependency %q", dependency)
+			} else if dependency == task.Name {
+				v.errorAt(dependencyPath, "task %q depends on itself", dependency)
+			} else {
+				graph.AddEdge(task.Name, dependency)
+			}
+			dependencies[dependency] = true
+		}
+	}
+	if cycle := graph.Cycle(); cycle != nil {
+		v.errorAt(joinPath(taskPaths[cycle[0]], "dependsOn"), "dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+}
+
+// featureSchema returns the schema of feature, or nil after reporting an error
+// if the feature is not supported.
+func (v *validator) featureSchema(feature *Feature, path string, schemas map[string]*schema.Schema) *schema.Schema {
+	if feature.Name == "" {
+		v.errorAt(joinPath(path, "name"), "feature name is required")
+		return nil
+	}
+	featureSchema, ok := schemas[feature.Name]
+	if !ok {
+		v.errorAt(joinPath(path, "name"), "unsupported feature %q", feature.Name)
+		return nil
+	}
+	return featureSchema
+}
+
+// validateFeature validates the args and key values of feature against its
+// schema, suffix is appended to the messages, e.g. the host the variables of
+// which are expanded. Only the errors accepted by filter are reported if it is
+// not nil.
+func (v *validator) validateFeature(feature *Feature, path string, schemas map[string]*schema.Schema, suffix string, filter func(err *schema.Error) bool) {
+	featureSchema := v.featureSchema(feature, path, schemas)
+	if featureSchema == nil {
+		return
+	}
+	_, err := featureSchema.Decode(feature.Args, feature.KeyValues)
+	if err == nil {
+		return
+	}
+	errs, ok := err.(schema.Errors)
+	if !ok {
+		errs = schema.Errors{{Arg: -1, Message: err.Error()}}
+	}
+	for _, paramErr := range errs {
+		if filter != nil && !filter(paramErr) {
+			continue
+		}
+		switch {
+		case paramErr.Arg >= 0:
+			v.errorAt(fmt.Sprintf("%s.args[%d]", path, paramErr.Arg), "%s%s", paramErr.Message, suffix)
+		case paramErr.Key != "":
+			v.errorAt(joinPath(joinPath(path, "keyValues"), paramErr.Key), "%s%s", paramErr.Message, suffix)
+		default:
+			v.errorAt(path, "%s%s", paramErr.Message, suffix)
+		}
+	}
+}
+
+// validateTemplates reports invalid variable references in the args and key
+// values of feature, and returns whether any references a variable.
+func (v *validator) validateTemplates(feature *Feature, path string) bool {
+	hasReference := false
+	check := func(value, valuePath string) {
+		if !hasVarReference(value) {
+			return
+		}
+		hasReference = true
+		if _, err := Expand(value, nil); err != nil {
+			if _, undefined := err.(*UndefinedVarError); !undefined {
+				v.errorAt(valuePath, "%v", err)
+			}
+		}
+	}
+	for i, arg := range feature.Args {
+		check(arg, fmt.Sprintf("%s.args[%d]", path, i))
+	}
+	for _, key := range sortedKeys(feature.KeyValues) {
+		check(feature.KeyValues[key], joinPath(joinPath(path, "keyValues"), key))
+	}
+	return hasReference
+}
+
+// isTemplatedError returns whether err is about an arg or key value of feature
+// which references a variable, or about the feature as a whole, which may
+// depend on such values.
+func isTemplatedError(feature *Feature, err *schema.Error) bool {
+	switch {
+	case err.Arg >= 0:
+		return err.Arg < len(feature.Args) && hasVarReference(feature.Args[err.Arg])
+	case err.Key != "":
+		return hasVarReference(feature.KeyValues[err.Key])
+	default:
+		return true
+	}
+}
+
+// validateHostFeatures validates the args and key values of the tasks of a
+// host which reference variables, with the variables of the host. The other
+// args and key values are validated once with the task.
+func (v *validator) validateHostFeatures(ciConfig *CIConfig, hostConfig *HostConfig, schemas map[string]*schema.Schema) {
+	taskIndexes := map[string]int{}
+	for i := len(ciConfig.Tasks) - 1; i >= 0; i-- {
+		taskIndexes[ciConfig.Tasks[i].Name] = i
+	}
+	vars := ciConfig.HostVars(hostConfig)
+	suffix := fmt.Sprintf(" (host %s)", hostConfig.Host)
+	for _, taskName := range hostConfig.Tasks {
+		i, ok := taskIndexes[taskName]
+		if !ok {
+			continue
+		}
+		task := &ciConfig.Tasks[i]
+		if !featureHasReference(&task.Feature) || schemas[task.Feature.Name] == nil {
+			continue
+		}
+		path := fmt.Sprintf("tasks[%d].feature", i)
+		feature, err := renderFeature(&task.Feature, vars)
+		if err != nil {
+			// Only undefined variables depend on the host, the other errors
+			// are reported with the task.
+			if renderErr, ok := err.(*renderError); ok {
+				if _, undefined := renderErr.err.(*UndefinedVarError); undefined {
+					v.errorAt(renderErr.path(path), "%v%s", renderErr.err, suffix)
+				}
+			}
+			continue
+		}
+		v.validateFeature(&feature, path, schemas, suffix, func(err *schema.Error) bool {
+			return isTemplatedError(&task.Feature, err)
+		})
+	}
+}
+
+// path returns the path of the arg or key value of the error in the feature
+// at featurePath.
+func (e *renderError) path(featurePath string) string {
+	if e.arg >= 0 {
+		return fmt.Sprintf("%s.args[%d]", featurePath, e.arg)
+	}
+	return joinPath(joinPath(featurePath, "keyValues"), e.key)
+}
+
+func (v *validator) validateHosts(ciConfig *CIConfig, schemas map[string]*schema.Schema) {
+	tasks := map[string]*Task{}
+	for i := range ciConfig.Tasks {
+		tasks[ciConfig.Tasks[i].Name] = &ciConfig.Tasks[i]
+	}
+	groups := groupMap(ciConfig)
+	hostNames := map[string]bool{}
+	for i := range ciConfig.Hosts {
+		rawHostConfig := &ciConfig.Hosts[i]
+		path := fmt.Sprintf("hosts[%d]", i)
+		if rawHostConfig.Host == "" {
+			v.errorAt(joinPath(path, "host"), "host is required")
+		} else if hostNames[rawHostConfig.Host] {
+			v.errorAt(joinPath(path, "host"), "duplicated host %q", rawHostConfig.Host)
+		}
+		hostNames[rawHostConfig.Host] = true
+		hostGroups := map[string]bool{}
+		for j, groupName := range rawHostConfig.Groups {
+			groupPath := fmt.Sprintf("%s.groups[%d]", path, j)
+			if groups[groupName] == nil {
+				v.errorAt(groupPath, "undefined group %q", groupName)
+			} else if hostGroups[groupName] {
+				v.errorAt(groupPath, "duplicated group %q", groupName)
+			}
+			hostGroups[groupName] = true
+		}
+		v.validateLabels(rawHostConfig.Labels, joinPath(path, "labels"))
+		v.validateTaskNames(rawHostConfig.Tasks, tasks, joinPath(path, "tasks"))
+		// The credentials of the groups are validated with the groups, and
+		// are not inherited by a host with its own password.
+		v.validateCredential(ciConfig, rawHostConfig, path)
+
+		// The settings are validated with the defaults of the groups, which
+		// may e.g. enable the HTTPS required by other WinRM settings.
+		hostConfig := *rawHostConfig
+		applyGroups(&hostConfig, groups)
+		if hostConfig.Port < 0 || hostConfig.Port > 65535 {
+			v.errorAt(joinPath(path, "port"), "port must be between 1 and 65535")
+		}
+		v.validateReboot(&hostConfig.Reboot, joinPath(path, "reboot"))
+		v.validateSSH(&hostConfig.SSH, joinPath(path, "ssh"))
+		v.validateWinRM(&hostConfig.WinRM, joinPath(path, "winrm"))
+		v.validateHostFeatures(ciConfig, &hostConfig, schemas)
+		hostTasks := map[string]bool{}
+		for _, taskName := range hostConfig.Tasks {
+			hostTasks[taskName] = true
+		}
+		for _, taskName := range hostConfig.Tasks {
+			task := tasks[taskName]
+			if task == nil {
+				continue
+			}
+			taskPath := joinPath(path, "tasks")
+			for j, rawTaskName := range rawHostConfig.Tasks {
+				if rawTaskName == taskName {
+					taskPath = fmt.Sprintf("%s.tasks[%d]", path, j)
+					break
+				}
+			}
+			for _, dependency := range task.DependsOn {
+				if tasks[dependency] != nil && !hostTasks[dependency] {
+					v.errorAt(taskPath, "task %q depends on task %q which is not assigned to the host", taskName, dependency)
+				}
+			}
+		}
+	}
+}
+
+// validateTaskNames validates the names of the tasks assigned at path.
+func (v *validator) validateTaskNames(taskNames []string, tasks map[string]*Task, path string) {
+	seen := map[string]bool{}
+	for i, taskName := range taskNames {
+		taskPath := fmt.Sprintf("%s[%d]", path, i)
+		if tasks[taskName] == nil {
+			v.errorAt(taskPath, "undefined task %q", taskName)
+		} else if seen[taskName] {
+			v.errorAt(taskPath, "duplicated task %q", taskName)
+		}
+		seen[taskName] = true
+	}
+}
+
+// validateCredential validates the password, passwordFrom and credential
+// fields of a host or the defaults of a group at path.
+func (v *validator) validateCredential(ciConfig *CIConfig, hostConfig *HostConfig, path string) {
+	v.validatePassword(ciConfig, hostConfig.Password, hostConfig.PasswordFrom, path)
+	if hostConfig.Credential == "" {
+		return
+	}
+	found := false
+	for _, credential := range ciConfig.Credentials {
+		found = found || credential.Name == hostConfig.Credential
+	}
+	if !found {
+		v.errorAt(joinPath(path, "credential"), "undefined credential %q", hostConfig.Credential)
+	}
+	if hostConfig.Password != "" || hostConfig.PasswordFrom != "" {
+		v.errorAt(joinPath(path, "credential"), "credential conflicts with password and passwordFrom")
+	}
+}
+
+func (v *validator) validateGroups(ciConfig *CIConfig) {
+	tasks := map[string]*Task{}
+	for i := range ciConfig.Tasks {
+		tasks[ciConfig.Tasks[i].Name] = &ciConfig.Tasks[i]
+	}
+	names := map[string]bool{}
+	for i := range ciConfig.Groups {
+		group := &ciConfig.Groups[i]
+		path := fmt.Sprintf("groups[%d]", i)
+		if group.Name == "" {
+			v.errorAt(joinPath(path, "name"), "name is required")
+		} else if names[group.Name] {
+			v.errorAt(joinPath(path, "name"), "duplicated group %q", group.Name)
+		}
+		names[group.Name] = true
+		defaultsPath := joinPath(path, "defaults")
+		notDefaults := []struct {
+			name string
+			set  bool
+		}{
+			{"host", group.Defaults.Host != ""},
+			{"tasks", len(group.Defaults.Tasks) > 0},
+			{"groups", len(group.Defaults.Groups) > 0},
+			{"labels", len(group.Defaults.Labels) > 0},
+			{"vars", len(group.Defaults.Vars) > 0},
+		}
+		for _, field := range notDefaults {
+			if field.set {
+				v.errorAt(joinPath(defaultsPath, field.name), "%s cannot be a group default, set it on the group instead", field.name)
+			}
+		}
+		v.validateCredential(ciConfig, &group.Defaults, defaultsPath)
+		v.validateTaskNames(group.Tasks, tasks, joinPath(path, "tasks"))
+		v.validateLabels(group.Labels, joinPath(path, "labels"))
+	}
+}
+
+// validateLabels validates label keys and values like Kubernetes labels, so
+// they can be matched by selectors.
+func (v *validator) validateLabels(labels map[string]string, path string) {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			v.errorAt(joinPath(path, key), "invalid label key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			v.errorAt(joinPath(path, key), "invalid label value %q: %s", value, strings.Join(errs, "; "))
+		}
+	}
+}
+
+func (v *validator) validateCredentials(ciConfig *CIConfig) {
+	names := map[string]bool{}
+	for i, credential := range ciConfig.Credentials {
+		path := fmt.Sprintf("credentials[%d]", i)
+		if credential.Name == "" {
+			v.errorAt(joinPath(path, "name"), "name is required")
+		} else if names[credential.Name] {
+			v.errorAt(joinPath(path, "name"), "duplicated credential %q", credential.Name)
+		}
+		names[credential.Name] = true
+		v.validatePassword(ciConfig, credential.Password, credential.PasswordFrom, path)
+	}
+	if ciConfig.Vault.PasswordFrom != "" {
+		path := "vault.passwordFrom"
+		if !secret.IsReference(ciConfig.Vault.PasswordFrom) {
+			v.errorAt(path, "unsupported secret reference %q, it must start with %s or %s", ciConfig.Vault.PasswordFrom, secret.PrefixEnv, secret.PrefixFile)
+		} else if strings.HasPrefix(ciConfig.Vault.PasswordFrom, secret.PrefixVault) {
+			v.errorAt(path, "the vault password cannot be stored in the vault")
+		}
+	}
+}
+
+// validatePassword validates the password and passwordFrom fields of a host or
+// a credential at path.
+func (v *validator) validatePassword(ciConfig *CIConfig, password, passwordFrom, path string) {
+	if passwordFrom == "" {
+		return
+	}
+	path = joinPath(path, "passwordFrom")
+	if password != "" {
+		v.errorAt(path, "passwordFrom conflicts with password")
+	}
+	if !secret.IsReference(passwordFrom) {
+		v.errorAt(path, "unsupported secret reference %q, it must start with %s, %s or %s", passwordFrom, secret.PrefixEnv, secret.PrefixFile, secret.PrefixVault)
+	} else if strings.HasPrefix(passwordFrom, secret.PrefixVault) && ciConfig.Vault.File == "" {
+		v.errorAt(path, "vault references require vault.file")
+	}
+}
+
+func (v *validator) validateSSH(sshConfig *SSHConfig, path string) {
+	if sshConfig.Port < 0 || sshConfig.Port > 65535 {
+		v.errorAt(joinPath(path, "port"), "port must be between 1 and 65535")
+	}
+	if sshConfig.Timeout < 0 {
+		v.errorAt(joinPath(path, "timeout"), "timeout must not be negative")
+	}
+	if sshConfig.TrustOnFirstUse && sshConfig.InsecureIgnoreHostKey {
+		v.errorAt(joinPath(path, "trustOnFirstUse"), "trustOnFirstUse has no effect with insecureIgnoreHostKey")
+	}
+}
+
+func (v *validator) validateWinRM(winrmConfig *WinRMConfig, path string) {
+	switch winrmConfig.Auth {
+	case "", WinRMAuthBasic, WinRMAuthNTLM:
+	case WinRMAuthCertificate:
+		if !winrmConfig.HTTPS {
+			v.errorAt(joinPath(path, "auth"), "certificate auth requires https")
+		}
+		if winrmConfig.ClientCertFile == "" {
+			v.errorAt(joinPath(path, "clientCertFile"), "clientCertFile is required by certificate auth")
+		}
+		if winrmConfig.ClientKeyFile == "" {
+			v.errorAt(joinPath(path, "clientKeyFile"), "clientKeyFile is required by certificate auth")
+		}
+	case winrmAuthKerberos:
+		v.errorAt(joinPath(path, "auth"), "kerberos auth is not supported, use ntlm for domain accounts or certificate auth")
+	default:
+		v.errorAt(joinPath(path, "auth"), "unsupported auth %q, supported auths: %s, %s, %s", winrmConfig.Auth, WinRMAuthBasic, WinRMAuthNTLM, WinRMAuthCertificate)
+	}
+	if winrmConfig.Auth != WinRMAuthCertificate {
+		for _, field := range []struct {
+			name  string
+			value string
+		}{
+			{"clientCertFile", winrmConfig.ClientCertFile},
+			{"clientKeyFile", winrmConfig.ClientKeyFile},
+		} {
+			if field.value != "" {
+				v.errorAt(joinPath(path, field.name), "%s is only used by certificate auth", field.name)
+			}
+		}
+	}
+	tlsFields := []struct {
+		name string
+		set  bool
+	}{
+		{"caCertFile", winrmConfig.CACertFile != ""},
+		{"certFingerprint", winrmConfig.CertFingerprint != ""},
+		{"tlsServerName", winrmConfig.TLSServerName != ""},
+		{"insecureSkipVerify", winrmConfig.InsecureSkipVerify},
+	}
+	for _, field := range tlsFields {
+		if field.set && !winrmConfig.HTTPS {
+			v.errorAt(joinPath(path, field.name), "%s requires https", field.name)
+		}
+	}
+	if winrmConfig.CertFingerprint != "" {
+		if _, err := parseCertFingerprint(winrmConfig.CertFingerprint); err != nil {
+			v.errorAt(joinPath(path, "certFingerprint"), "%v", err)
+		}
+	}
+	if winrmConfig.InsecureSkipVerify && (winrmConfig.CACertFile != "" || winrmConfig.CertFingerprint != "") {
+		v.errorAt(joinPath(path, "insecureSkipVerify"), "insecureSkipVerify conflicts with caCertFile and certFingerprint")
+	}
+	if winrmConfig.OperationTimeout < 0 {
+		v.errorAt(joinPath(path, "operationTimeout"), "operationTimeout must not be negative")
+	} else if winrmConfig.OperationTimeout > 0 && winrmConfig.OperationTimeout < time.Second {
+		v.errorAt(joinPath(path, "operationTimeout"), "operationTimeout must be at least 1s")
+	}
+}
+
+func (v *validator) validateReboot(rebootConfig *RebootConfig, path string) {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"downTimeout", rebootConfig.DownTimeout},
+		{"upTimeout", rebootConfig.UpTimeout},
+		{"pollInterval", rebootConfig.PollInterval},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			v.errorAt(joinPath(path, duration.name), "%s must not be negative", duration.name)
+		}
+	}
+	for i, probe := range rebootConfig.Probes {
+		if probe == ProbeWinRM || probe == ProbeSSH {
+			continue
+		}
+		if strings.HasPrefix(probe, ProbeServicePrefix) && len(probe) > len(ProbeServicePrefix) {
+			continue
+		}
+		v.errorAt(fmt.Sprintf("%s.probes[%d]", path, i), "unsupported probe %q, supported probes: %s, %s, %s<name>", probe, ProbeWinRM, ProbeSSH, ProbeServicePrefix)
+	}
+}
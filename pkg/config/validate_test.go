@@ -0,0 +1,86 @@
+package config_test
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"strings"
+	"testing"
+)
+
+func TestValidateWinRM(t *testing.T) {
+	tests := []struct {
+		name  string
+		winrm string
+		// wantErrs are substrings of the validation errors in order.
+		wantErrs []string
+	}{
+		{name: "default auth"},
+		{name: "ntlm", winrm: "{auth: ntlm}"},
+		{
+			name:  "certificate",
+			winrm: "{auth: certificate, https: true, clientCertFile: client.pem, clientKeyFile: client.key}",
+		},
+		{
+			name:     "kerberos is rejected",
+			winrm:    "{auth: kerberos}",
+			wantErrs: []string{"hosts[0].winrm.auth: kerberos auth is not supported, use ntlm for domain accounts or certificate auth"},
+		},
+		{
+			name:     "unsupported auth",
+			winrm:    "{auth: digest}",
+			wantErrs: []string{`unsupported auth "digest", supported auths: basic, ntlm, certificate`},
+		},
+		{
+			name:     "certificate without https",
+			winrm:    "{auth: certificate, clientCertFile: client.pem, clientKeyFile: client.key}",
+			wantErrs: []string{"certificate auth requires https"},
+		},
+		{
+			name:     "client certificate without certificate auth",
+			winrm:    "{auth: ntlm, clientCertFile: client.pem}",
+			wantErrs: []string{"clientCertFile is only used by certificate auth"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winrm := ""
+			if tt.winrm != "" {
+				winrm = "\n  winrm: " + tt.winrm
+			}
+			doc, err := loadDocument(t, map[string]string{"config.yml": `
+hosts:
+- host: win-1
+  password: password
+  tasks: [ovs]` + winrm + `
+tasks:
+- name: ovs
+  feature:
+    name: InstallOVS
+    keyValues:
+      ovsType: upstream
+      ovsVersion: 2.14.0
+`}, "config.yml")
+			if err != nil {
+				t.Fatalf("LoadDocument failed: %v", err)
+			}
+			err = config.Validate(doc, ovsSchemas, nil)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Validate failed: %v", err)
+				}
+				return
+			}
+			errs, ok := err.(config.ValidationErrors)
+			if !ok {
+				t.Fatalf("Validate returned error %v, want ValidationErrors", err)
+			}
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("Validate returned errors:\n%v\nwant %d errors", errs, len(tt.wantErrs))
+			}
+			for i, want := range tt.wantErrs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("error %q, want %q", errs[i].Error(), want)
+				}
+			}
+		})
+	}
+}
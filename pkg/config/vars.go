@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envVarPrefix marks a reference to an environment variable, e.g.
+// "${env:OVS_VERSION}".
+const envVarPrefix = "env:"
+
+var varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// UndefinedVarError is returned by Expand for a reference to an undefined
+// variable.
+type UndefinedVarError struct {
+	Name string
+}
+
+func (e *UndefinedVarError) Error() string {
+	if strings.HasPrefix(e.Name, envVarPrefix) {
+		return fmt.Sprintf("undefined environment variable %q", strings.TrimPrefix(e.Name, envVarPrefix))
+	}
+	return fmt.Sprintf("undefined variable %q", e.Name)
+}
+
+// Expand replaces the references "${name}" in s with the value of name in
+// vars, and "${env:NAME}" with the environment variable NAME. "$$" is a
+// literal "$".
+func Expand(s string, vars map[string]string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			out.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed variable reference in %q, use $$ for a literal $", s)
+			}
+			name := s[i+2 : i+2+end]
+			value, err := lookupVar(name, vars)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			i += 2 + end
+		default:
+			out.WriteByte('$')
+		}
+	}
+	return out.String(), nil
+}
+
+func lookupVar(name string, vars map[string]string) (string, error) {
+	if strings.HasPrefix(name, envVarPrefix) {
+		envName := strings.TrimPrefix(name, envVarPrefix)
+		if !varNamePattern.MatchString(envName) {
+			return "", fmt.Errorf("invalid environment variable name %q", envName)
+		}
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			return "", &UndefinedVarError{Name: name}
+		}
+		return value, nil
+	}
+	if !varNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid variable name %q", name)
+	}
+	value, ok := vars[name]
+	if !ok {
+		return "", &UndefinedVarError{Name: name}
+	}
+	return value, nil
+}
+
+// hasVarReference returns whether s references a variable.
+func hasVarReference(s string) bool {
+	return strings.Contains(strings.ReplaceAll(s, "$$", ""), "${")
+}
+
+// featureHasReference returns whether the args or key values of feature
+// reference a variable.
+func featureHasReference(feature *Feature) bool {
+	for _, arg := range feature.Args {
+		if hasVarReference(arg) {
+			return true
+		}
+	}
+	for _, value := range feature.KeyValues {
+		if hasVarReference(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostVars returns the variables of a host after ApplyGroups: the overrides,
+// then the vars of the host and its groups, then the global vars.
+func (ciConfig *CIConfig) HostVars(hostConfig *HostConfig) map[string]string {
+	vars := copyMap(ciConfig.Overrides)
+	vars = mergeMap(vars, hostConfig.Vars)
+	vars = mergeMap(vars, ciConfig.Vars)
+	return vars
+}
+
+// ParseOverride parses a "key=value" variable override.
+func ParseOverride(s string) (string, string, error) {
+	index := strings.IndexByte(s, '=')
+	if index < 0 {
+		return "", "", fmt.Errorf("invalid variable %q, expected key=value", s)
+	}
+	name := s[:index]
+	if !varNamePattern.MatchString(name) {
+		return "", "", fmt.Errorf("invalid variable name %q", name)
+	}
+	return name, s[index+1:], nil
+}
+
+// renderFeature returns a copy of feature with the variables of its args and
+// key values expanded.
+func renderFeature(feature *Feature, vars map[string]string) (Feature, error) {
+	rendered := Feature{Name: feature.Name}
+	for i, arg := range feature.Args {
+		value, err := Expand(arg, vars)
+		if err != nil {
+			return rendered, &renderError{arg: i, err: err}
+		}
+		rendered.Args = append(rendered.Args, value)
+	}
+	if feature.KeyValues != nil {
+		rendered.KeyValues = make(map[string]string, len(feature.KeyValues))
+	}
+	for _, key := range sortedKeys(feature.KeyValues) {
+		expanded, err := Expand(feature.KeyValues[key], vars)
+		if err != nil {
+			return rendered, &renderError{arg: -1, key: key, err: err}
+		}
+		rendered.KeyValues[key] = expanded
+	}
+	return rendered, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renderError is an error expanding the arg at index arg, or the value of
+// key.
+type renderError struct {
+	arg int
+	key string
+	err error
+}
+
+func (e *renderError) Error() string {
+	if e.arg >= 0 {
+		return fmt.Sprintf("args[%d]: %v", e.arg, e.err)
+	}
+	return fmt.Sprintf("keyValues.%s: %v", e.key, e.err)
+}
+
+// renderTask returns a copy of task with the variables of its feature
+// expanded.
+func renderTask(task *Task, vars map[string]string) (*Task, error) {
+	feature, err := renderFeature(&task.Feature, vars)
+	if err != nil {
+		return nil, err
+	}
+	rendered := *task
+	rendered.Feature = feature
+	return &rendered, nil
+}
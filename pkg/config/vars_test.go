@@ -0,0 +1,281 @@
+package config_test
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/schema"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	os.Setenv("VARS_TEST_OVS_VERSION", "2.14.0")
+	defer os.Unsetenv("VARS_TEST_OVS_VERSION")
+	vars := map[string]string{
+		"ovsVersion":   "2.13.1",
+		"ovs.type":     "nsx",
+		"empty":        "",
+		"hasReference": "${ovsVersion}",
+	}
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr string
+		// wantUndefined is the name of the undefined variable, if any.
+		wantUndefined string
+	}{
+		{name: "no reference", s: "2.14.0", want: "2.14.0"},
+		{name: "variable", s: "${ovsVersion}", want: "2.13.1"},
+		{name: "variable in text", s: "OVS ${ovs.type} ${ovsVersion}!", want: "OVS nsx 2.13.1!"},
+		{name: "empty variable", s: "a${empty}b", want: "ab"},
+		{name: "value is not expanded again", s: "${hasReference}", want: "${ovsVersion}"},
+		{name: "environment variable", s: "${env:VARS_TEST_OVS_VERSION}", want: "2.14.0"},
+		{name: "escaped dollar", s: "$${ovsVersion}", want: "${ovsVersion}"},
+		{name: "escaped dollars", s: "$$$$", want: "$$"},
+		{name: "dollar without brace", s: "$env:PATH $", want: "$env:PATH $"},
+		{name: "undefined variable", s: "${ovsType}", wantErr: `undefined variable "ovsType"`, wantUndefined: "ovsType"},
+		{name: "undefined environment variable", s: "${env:VARS_TEST_UNSET}", wantErr: `undefined environment variable "VARS_TEST_UNSET"`, wantUndefined: "env:VARS_TEST_UNSET"},
+		{name: "unclosed reference", s: "${ovsVersion", wantErr: "unclosed variable reference"},
+		{name: "invalid name", s: "${ovs version}", wantErr: `invalid variable name "ovs version"`},
+		{name: "invalid environment variable name", s: "${env:}", wantErr: `invalid environment variable name ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.Expand(tt.s, vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expand(%q) returned error %v, want %q", tt.s, err, tt.wantErr)
+				}
+				undefinedErr, ok := err.(*config.UndefinedVarError)
+				if tt.wantUndefined == "" && ok {
+					t.Errorf("Expand(%q) returned an UndefinedVarError", tt.s)
+				}
+				if tt.wantUndefined != "" && (!ok || undefinedErr.Name != tt.wantUndefined) {
+					t.Errorf("Expand(%q) returned error %#v, want undefined variable %q", tt.s, err, tt.wantUndefined)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", tt.s, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expand(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostVars(t *testing.T) {
+	ciConfig := &config.CIConfig{
+		Vars:      map[string]string{"ovsVersion": "2.13.1", "ovsType": "upstream", "runtime": "docker"},
+		Overrides: map[string]string{"ovsVersion": "2.15.0"},
+	}
+	hostConfig := &config.HostConfig{Host: "win-1", Vars: map[string]string{"ovsVersion": "2.14.0", "runtime": "containerd"}}
+	want := map[string]string{"ovsVersion": "2.15.0", "ovsType": "upstream", "runtime": "containerd"}
+	if got := ciConfig.HostVars(hostConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("HostVars() = %v, want %v", got, want)
+	}
+	if ciConfig.Overrides["runtime"] != "" {
+		t.Errorf("HostVars modified the overrides: %v", ciConfig.Overrides)
+	}
+}
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		s         string
+		wantName  string
+		wantValue string
+		wantErr   bool
+	}{
+		{s: "ovsVersion=2.14.0", wantName: "ovsVersion", wantValue: "2.14.0"},
+		{s: "args=a=b", wantName: "args", wantValue: "a=b"},
+		{s: "empty=", wantName: "empty"},
+		{s: "ovsVersion", wantErr: true},
+		{s: "=2.14.0", wantErr: true},
+		{s: "ovs version=2.14.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		name, value, err := config.ParseOverride(tt.s)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("ParseOverride(%q) returned error %v, want error: %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if name != tt.wantName || value != tt.wantValue {
+			t.Errorf("ParseOverride(%q) = (%q, %q), want (%q, %q)", tt.s, name, value, tt.wantName, tt.wantValue)
+		}
+	}
+}
+
+// loadDocument writes files into a temporary directory and loads the files
+// named by load from it.
+func loadDocument(t *testing.T, files map[string]string, load ...string) (*config.Document, error) {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	paths := make([]string, 0, len(load))
+	for _, name := range load {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	return config.LoadDocument(paths)
+}
+
+var ovsSchemas = map[string]*schema.Schema{
+	"InstallOVS": {
+		Params: []schema.Param{
+			{Name: "ovsType", Type: schema.TypeString, Allowed: []string{"nsx", "upstream"}},
+			{Name: "ovsVersion", Type: schema.TypeVersion},
+		},
+	},
+}
+
+func TestValidateVars(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		overrides map[string]string
+		// wantErrs are substrings of the validation errors in order.
+		wantErrs []string
+	}{
+		{
+			name: "variables of the hosts",
+			config: `
+vars:
+  ovsType: upstream
+hosts:
+- host: win-1
+  password: password
+  tasks: [ovs]
+  vars:
+    ovsVersion: 2.14.0
+tasks:
+- name: ovs
+  feature:
+    name: InstallOVS
+    keyValues:
+      ovsType: ${ovsType}
+      ovsVersion: ${ovsVersion}
+`,
+		},
+		{
+			name: "undefined variable",
+			config: `
+hosts:
+- host: win-1
+  password: password
+  tasks: [ovs]
+  vars:
+    ovsVersion: 2.14.0
+- host: win-2
+  password: password
+  tasks: [ovs]
+tasks:
+- name: ovs
+  feature:
+    name: InstallOVS
+    keyValues:
+      ovsVersion: ${ovsVersion}
+`,
+			wantErrs: []string{`tasks[0].feature.keyValues.ovsVersion: undefined variable "ovsVersion" (host win-2)`},
+		},
+		{
+			name: "override defines the variable",
+			config: `
+hosts:
+- host: win-1
+  password: password
+  tasks: [ovs]
+tasks:
+- name: ovs
+  feature:
+    name: InstallOVS
+    keyValues:
+      ovsVersion: ${ovsVersion}
+`,
+			overrides: map[string]string{"ovsVersion": "2.14.0"},
+		},
+		{
+			name: "invalid value of a host",
+			config: `
+hosts:
+- host: win-1
+  password: password
+  tasks: [ovs]
+  vars:
+    ovsVersion: latest
+tasks:
+- name: ovs
+  feature:
+    name: InstallOVS
+    keyValues:
+      ovsVersion: ${ovsVersion}
+`,
+			wantErrs: []string{"(host win-1)"},
+		},
+		{
+			name: "invalid reference",
+			config: `
+hosts:
+- host: win-1
+  password: password
+  tasks: [ovs]
+tasks:
+- name: ovs
+  feature:
+    name: InstallOVS
+    keyValues:
+      ovsVersion: ${ovsVersion
+`,
+			wantErrs: []string{"tasks[0].feature.keyValues.ovsVersion: unclosed variable reference"},
+		},
+		{
+			name: "escaped reference is not a variable",
+			config: `
+hosts:
+- host: win-1
+  password: password
+  tasks: [ovs]
+tasks:
+- name: ovs
+  feature:
+    name: InstallOVS
+    keyValues:
+      ovsType: $${ovsType}
+`,
+			wantErrs: []string{"tasks[0].feature.keyValues.ovsType"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := loadDocument(t, map[string]string{"config.yml": tt.config}, "config.yml")
+			if err != nil {
+				t.Fatalf("LoadDocument failed: %v", err)
+			}
+			err = config.Validate(doc, ovsSchemas, tt.overrides)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Validate failed: %v", err)
+				}
+				return
+			}
+			errs, ok := err.(config.ValidationErrors)
+			if !ok {
+				t.Fatalf("Validate returned error %v, want ValidationErrors", err)
+			}
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("Validate returned errors:\n%v\nwant %d errors", errs, len(tt.wantErrs))
+			}
+			for i, want := range tt.wantErrs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("error %q, want %q", errs[i].Error(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/Azure/go-ntlmssp"
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	winrmDialTimeout     = 30 * time.Second
+	winrmIdleConnTimeout = 90 * time.Second
+	// winrmResponseMargin is added to the operation timeout for the HTTP
+	// response timeout, so the server reports an operation timeout first.
+	winrmResponseMargin = 30 * time.Second
+
+	winrmMutualAuth = "http://schemas.dmtf.org/wbem/wsman/1/wsman/secprofile/https/mutual"
+)
+
+// NewWinRMClient creates a WinRM client with the settings of hostConfig.WinRM,
+// it connects on the first command.
+func NewWinRMClient(hostConfig *HostConfig) (*winrm.Client, error) {
+	winrmConfig := hostConfig.WinRM
+	winrmConfig.SetDefaults()
+	transport, err := newWinRMTransport(hostConfig, &winrmConfig)
+	if err != nil {
+		return nil, err
+	}
+	responseTimeout := winrmConfig.OperationTimeout + winrmResponseMargin
+	endpoint := winrm.NewEndpoint(hostConfig.Host, hostConfig.winrmPort(), winrmConfig.HTTPS, winrmConfig.InsecureSkipVerify, nil, nil, nil, responseTimeout)
+	params := *winrm.DefaultParameters
+	params.Timeout = fmt.Sprintf("PT%dS", int(winrmConfig.OperationTimeout.Seconds()))
+	params.TransportDecorator = func() winrm.Transporter {
+		return transport
+	}
+	return winrm.NewClientWithParameters(endpoint, hostConfig.User, hostConfig.Password, &params)
+}
+
+// winrmTransport posts the requests of a WinRM client. The transports of the
+// winrm package cannot pin the server certificate, and only authenticate with
+// a client certificate or NTLM without the other TLS settings.
+type winrmTransport struct {
+	url      string
+	auth     string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func newWinRMTransport(hostConfig *HostConfig, winrmConfig *WinRMConfig) (*winrmTransport, error) {
+	tlsConfig, err := newWinRMTLSConfig(winrmConfig)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: winrmDialTimeout, KeepAlive: 30 * time.Second}
+	var roundTripper http.RoundTripper = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		ResponseHeaderTimeout: winrmConfig.OperationTimeout + winrmResponseMargin,
+		IdleConnTimeout:       winrmIdleConnTimeout,
+	}
+	if winrmConfig.Auth == WinRMAuthNTLM {
+		roundTripper = ntlmssp.Negotiator{RoundTripper: roundTripper}
+	}
+	scheme := "http"
+	if winrmConfig.HTTPS {
+		scheme = "https"
+	}
+	return &winrmTransport{
+		url:      fmt.Sprintf("%s://%s/wsman", scheme, hostConfig.WinRMAddr()),
+		auth:     winrmConfig.Auth,
+		user:     hostConfig.User,
+		password: hostConfig.Password,
+		client:   &http.Client{Transport: roundTripper},
+	}, nil
+}
+
+func newWinRMTLSConfig(winrmConfig *WinRMConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         winrmConfig.TLSServerName,
+		InsecureSkipVerify: winrmConfig.InsecureSkipVerify,
+	}
+	if winrmConfig.CACertFile != "" {
+		data, err := readFile(winrmConfig.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in CA file %s", winrmConfig.CACertFile)
+		}
+	}
+	if winrmConfig.CertFingerprint != "" {
+		fingerprint, err := parseCertFingerprint(winrmConfig.CertFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// The pinned fingerprint replaces the verification of the chain.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("server sent no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], fingerprint) {
+				return fmt.Errorf("server certificate fingerprint %s does not match the pinned fingerprint %s", hex.EncodeToString(sum[:]), hex.EncodeToString(fingerprint))
+			}
+			return nil
+		}
+	}
+	if winrmConfig.Auth == WinRMAuthCertificate {
+		certFile, err := expandHome(winrmConfig.ClientCertFile)
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err := expandHome(winrmConfig.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		tlsConfig.Renegotiation = tls.RenegotiateOnceAsClient
+	}
+	return tlsConfig, nil
+}
+
+// parseCertFingerprint parses a SHA-256 fingerprint in hex, the bytes may be
+// separated by colons.
+func parseCertFingerprint(s string) ([]byte, error) {
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("invalid certificate fingerprint %q, it must be a SHA-256 hash in hex", s)
+	}
+	return fingerprint, nil
+}
+
+func readFile(path string) ([]byte, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Transport is a no-op, the transport is built from the host config.
+func (t *winrmTransport) Transport(endpoint *winrm.Endpoint) error {
+	return nil
+}
+
+func (t *winrmTransport) Post(client *winrm.Client, request *soap.SoapMessage) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, t.url, strings.NewReader(request.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create http request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	if t.auth == WinRMAuthCertificate {
+		req.Header.Set("Authorization", winrmMutualAuth)
+	} else {
+		// NTLM negotiates with the basic auth credentials.
+		req.SetBasicAuth(t.user, t.password)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read http response: %v", err)
+	}
+	// SOAP faults are returned like responses as the winrm package does, e.g.
+	// an operation timeout of a receive request is retried by the command.
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/soap+xml") {
+		return "", fmt.Errorf("http error %d: %s", resp.StatusCode, body)
+	}
+	return string(body), nil
+}
+
+// winrmPort returns the port of the WinRM service of the host.
+func (hostConfig *HostConfig) winrmPort() int {
+	if hostConfig.Port != 0 {
+		return hostConfig.Port
+	}
+	if hostConfig.WinRM.HTTPS {
+		return DefaultWinRMHTTPSPort
+	}
+	return DefaultWinRMPort
+}
@@ -0,0 +1,126 @@
+// Package dag orders named nodes by their dependencies.
+package dag
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Graph is a directed graph of named nodes, an edge from a node to another one
+// means the node depends on the other one. Nodes keep the order they are added
+// in, which is used to break ties when sorting.
+type Graph struct {
+	nodes     []string
+	index     map[string]int
+	dependsOn map[string][]string
+}
+
+func New() *Graph {
+	return &Graph{index: map[string]int{}, dependsOn: map[string][]string{}}
+}
+
+// AddNode adds a node, adding an existing node is a no-op.
+func (g *Graph) AddNode(name string) {
+	if _, ok := g.index[name]; ok {
+		return
+	}
+	g.index[name] = len(g.nodes)
+	g.nodes = append(g.nodes, name)
+}
+
+// AddEdge records that node depends on dependency, both are added as nodes if
+// they do not exist.
+func (g *Graph) AddEdge(node, dependency string) {
+	g.AddNode(node)
+	g.AddNode(dependency)
+	g.dependsOn[node] = append(g.dependsOn[node], dependency)
+}
+
+// DependsOn returns the direct dependencies of node.
+func (g *Graph) DependsOn(node string) []string {
+	return g.dependsOn[node]
+}
+
+// CycleError is returned by Sort if the graph has a cycle, Cycle starts and
+// ends with the same node, e.g. [a b a].
+type CycleError struct {
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("dependency cycle: %s", strings.Join(e.Cycle, " -> "))
+}
+
+// Cycle returns a cycle of the graph, or nil if it has none.
+func (g *Graph) Cycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(g.nodes))
+	var stack []string
+	var visit func(node string) []string
+	visit = func(node string) []string {
+		state[node] = visiting
+		stack = append(stack, node)
+		for _, dependency := range g.dependsOn[node] {
+			switch state[dependency] {
+			case visiting:
+				for i := range stack {
+					if stack[i] == dependency {
+						cycle := append([]string{}, stack[i:]...)
+						return append(cycle, dependency)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[node] = visited
+		return nil
+	}
+	for _, node := range g.nodes {
+		if state[node] == unvisited {
+			if cycle := visit(node); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// Sort returns the nodes ordered so that every node comes after its
+// dependencies. Among the nodes whose dependencies are sorted, the one added
+// first comes first, so a graph without edges keeps its order. The returned
+// error is a *CycleError if the graph has a cycle.
+func (g *Graph) Sort() ([]string, error) {
+	if cycle := g.Cycle(); cycle != nil {
+		return nil, &CycleError{Cycle: cycle}
+	}
+	sorted := make([]string, 0, len(g.nodes))
+	done := make(map[string]bool, len(g.nodes))
+	for len(sorted) < len(g.nodes) {
+		for _, node := range g.nodes {
+			if done[node] || !g.ready(node, done) {
+				continue
+			}
+			done[node] = true
+			sorted = append(sorted, node)
+			break
+		}
+	}
+	return sorted, nil
+}
+
+func (g *Graph) ready(node string, done map[string]bool) bool {
+	for _, dependency := range g.dependsOn[node] {
+		if !done[dependency] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package dag_test
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/dag"
+	"reflect"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []string
+		// edges are pairs of a node and its dependency.
+		edges [][2]string
+		// wantSorted is nil if Sort fails with wantCycle.
+		wantSorted []string
+		wantCycle  []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:       "no edges keep the order",
+			nodes:      []string{"c", "a", "b"},
+			wantSorted: []string{"c", "a", "b"},
+		},
+		{
+			name:       "chain",
+			nodes:      []string{"a", "b", "c"},
+			edges:      [][2]string{{"a", "b"}, {"b", "c"}},
+			wantSorted: []string{"c", "b", "a"},
+		},
+		{
+			name:       "ties are broken by the order of the nodes",
+			nodes:      []string{"a", "b", "c", "d"},
+			edges:      [][2]string{{"a", "d"}, {"c", "d"}},
+			wantSorted: []string{"b", "d", "a", "c"},
+		},
+		{
+			name:       "diamond",
+			nodes:      []string{"top", "left", "right", "bottom"},
+			edges:      [][2]string{{"top", "left"}, {"top", "right"}, {"left", "bottom"}, {"right", "bottom"}},
+			wantSorted: []string{"bottom", "left", "right", "top"},
+		},
+		{
+			name:       "duplicate edge",
+			nodes:      []string{"a", "b"},
+			edges:      [][2]string{{"a", "b"}, {"a", "b"}},
+			wantSorted: []string{"b", "a"},
+		},
+		{
+			name:       "unknown dependency is added as a node",
+			nodes:      []string{"a"},
+			edges:      [][2]string{{"a", "unknown"}},
+			wantSorted: []string{"unknown", "a"},
+		},
+		{
+			name:      "self dependency",
+			nodes:     []string{"a", "b"},
+			edges:     [][2]string{{"b", "b"}},
+			wantCycle: []string{"b", "b"},
+		},
+		{
+			name:      "cycle",
+			nodes:     []string{"a", "b", "c"},
+			edges:     [][2]string{{"a", "b"}, {"b", "c"}, {"c", "a"}},
+			wantCycle: []string{"a", "b", "c", "a"},
+		},
+		{
+			name:      "cycle behind a dependency",
+			nodes:     []string{"a", "b", "c", "d"},
+			edges:     [][2]string{{"a", "b"}, {"b", "c"}, {"c", "d"}, {"d", "b"}},
+			wantCycle: []string{"b", "c", "d", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph := dag.New()
+			for _, node := range tt.nodes {
+				graph.AddNode(node)
+			}
+			for _, edge := range tt.edges {
+				graph.AddEdge(edge[0], edge[1])
+			}
+			if cycle := graph.Cycle(); !reflect.DeepEqual(cycle, tt.wantCycle) {
+				t.Errorf("Cycle() = %v, want %v", cycle, tt.wantCycle)
+			}
+			sorted, err := graph.Sort()
+			if tt.wantCycle != nil {
+				cycleErr, ok := err.(*dag.CycleError)
+				if !ok {
+					t.Fatalf("Sort() returned error %v, want a *CycleError", err)
+				}
+				if !reflect.DeepEqual(cycleErr.Cycle, tt.wantCycle) {
+					t.Errorf("Sort() returned cycle %v, want %v", cycleErr.Cycle, tt.wantCycle)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Sort() failed: %v", err)
+			}
+			if len(sorted) == 0 && len(tt.wantSorted) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(sorted, tt.wantSorted) {
+				t.Errorf("Sort() = %v, want %v", sorted, tt.wantSorted)
+			}
+		})
+	}
+}
+
+func TestCycleError(t *testing.T) {
+	err := &dag.CycleError{Cycle: []string{"a", "b", "a"}}
+	if got, want := err.Error(), "dependency cycle: a -> b -> a"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
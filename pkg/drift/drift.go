@@ -0,0 +1,151 @@
+// Package drift reports the hosts whose state diverges from the desired state
+// of their tasks, as text or as JSON for scheduled jobs.
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/plan"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"io"
+	"time"
+)
+
+const (
+	StatusInSync      = "in sync"
+	StatusDrifted     = "drifted"
+	StatusFailed      = "failed"
+	StatusUnreachable = "unreachable"
+)
+
+type Report struct {
+	Time    time.Time     `json:"time"`
+	Summary Summary       `json:"summary"`
+	Hosts   []*HostReport `json:"hosts"`
+}
+
+// Summary counts the hosts by status.
+type Summary struct {
+	Hosts       int `json:"hosts"`
+	InSync      int `json:"inSync"`
+	Drifted     int `json:"drifted"`
+	Failed      int `json:"failed"`
+	Unreachable int `json:"unreachable"`
+}
+
+type HostReport struct {
+	Host   string        `json:"host"`
+	Status string        `json:"status"`
+	Tasks  []*TaskReport `json:"tasks"`
+}
+
+// TaskReport is the drift of a task, Error is set if its state could not be
+// detected.
+type TaskReport struct {
+	Task    string  `json:"task"`
+	Feature string  `json:"feature"`
+	Status  string  `json:"status"`
+	Error   string  `json:"error,omitempty"`
+	Items   []*Item `json:"items"`
+}
+
+// Item is an item of the host checked by a task, e.g. a Windows feature or a
+// service. Action fixes the drift of a drifted item.
+type Item struct {
+	Item    string `json:"item"`
+	Current string `json:"current"`
+	Desired string `json:"desired"`
+	Drifted bool   `json:"drifted"`
+	Action  string `json:"action,omitempty"`
+}
+
+// New builds the report from the drift plans of the hosts, in the same order.
+func New(hosts []*config.Host, plans []*plan.HostPlan, reportTime time.Time) *Report {
+	r := &Report{Time: reportTime, Summary: Summary{Hosts: len(hosts)}, Hosts: []*HostReport{}}
+	for i, hostPlan := range plans {
+		hostReport := newHostReport(hostPlan)
+		switch {
+		case hosts[i].Unreachable:
+			hostReport.Status = StatusUnreachable
+			r.Summary.Unreachable++
+		case hostPlan.Err() != nil:
+			hostReport.Status = StatusFailed
+			r.Summary.Failed++
+		case hostPlan.HasChanges():
+			hostReport.Status = StatusDrifted
+			r.Summary.Drifted++
+		default:
+			hostReport.Status = StatusInSync
+			r.Summary.InSync++
+		}
+		r.Hosts = append(r.Hosts, hostReport)
+	}
+	return r
+}
+
+func newHostReport(hostPlan *plan.HostPlan) *HostReport {
+	hostReport := &HostReport{Host: hostPlan.Host, Tasks: []*TaskReport{}}
+	for _, taskPlan := range hostPlan.Tasks {
+		taskReport := &TaskReport{Task: taskPlan.Task, Feature: taskPlan.Feature, Status: StatusInSync, Items: []*Item{}}
+		if taskPlan.Error != nil {
+			taskReport.Status = StatusFailed
+			taskReport.Error = secret.Redact(taskPlan.Error.Error())
+		} else {
+			if taskPlan.Result.HasChanges() {
+				taskReport.Status = StatusDrifted
+			}
+			for _, change := range taskPlan.Result.Changes {
+				taskReport.Items = append(taskReport.Items, &Item{
+					Item:    change.Item,
+					Current: change.Current,
+					Desired: change.Desired,
+					Drifted: change.Action != "",
+					Action:  change.Action,
+				})
+			}
+		}
+		hostReport.Tasks = append(hostReport.Tasks, taskReport)
+	}
+	return hostReport
+}
+
+// HasDrift returns whether any host drifted.
+func (r *Report) HasDrift() bool {
+	return r.Summary.Drifted > 0
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteText writes the status of every host, with the drifted items and the
+// errors of the hosts which are not in sync.
+func (r *Report) WriteText(w io.Writer) error {
+	for _, hostReport := range r.Hosts {
+		if _, err := fmt.Fprintf(w, "Host %s: %s\n", hostReport.Host, hostReport.Status); err != nil {
+			return err
+		}
+		for _, taskReport := range hostReport.Tasks {
+			if taskReport.Status == StatusInSync {
+				continue
+			}
+			fmt.Fprintf(w, "  Task %s (%s): %s\n", taskReport.Task, taskReport.Feature, taskReport.Status)
+			if taskReport.Error != "" {
+				fmt.Fprintf(w, "    ! %s\n", taskReport.Error)
+			}
+			for _, item := range taskReport.Items {
+				if item.Drifted {
+					fmt.Fprintf(w, "    ~ %s: %s, desired %s\n", item.Item, item.Current, item.Desired)
+				}
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d of %d hosts drifted, %d failed, %d unreachable\n", r.Summary.Drifted, r.Summary.Hosts, r.Summary.Failed, r.Summary.Unreachable)
+	return err
+}
@@ -0,0 +1,163 @@
+package drift_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/drift"
+	"github.com/ruicao93/antrea-windows-ci/pkg/plan"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	inSync = &plan.Result{Changes: []plan.Change{
+		{Item: "OVS", Current: "installed 2.14.0", Desired: "installed 2.14.0"},
+	}}
+	drifted = &plan.Result{Changes: []plan.Change{
+		{Item: "Containers", Current: "Enabled", Desired: "Enabled"},
+		{Item: "docker", Current: "Stopped", Desired: "Running", Action: "start service"},
+	}}
+)
+
+func taskPlan(task string, result *plan.Result, err error) *plan.TaskPlan {
+	return &plan.TaskPlan{Task: task, Feature: task + "-feature", Result: result, Error: err}
+}
+
+func TestNew(t *testing.T) {
+	secret.Register("s3cr3t-password")
+	tests := []struct {
+		name        string
+		unreachable bool
+		tasks       []*plan.TaskPlan
+		wantStatus  string
+		// wantTasks are the statuses of the tasks of the host.
+		wantTasks   []string
+		wantSummary drift.Summary
+	}{
+		{
+			name:        "in sync",
+			tasks:       []*plan.TaskPlan{taskPlan("ovs", inSync, nil), taskPlan("containers", &plan.Result{}, nil)},
+			wantStatus:  drift.StatusInSync,
+			wantTasks:   []string{drift.StatusInSync, drift.StatusInSync},
+			wantSummary: drift.Summary{Hosts: 1, InSync: 1},
+		},
+		{
+			name:        "no tasks",
+			wantStatus:  drift.StatusInSync,
+			wantSummary: drift.Summary{Hosts: 1, InSync: 1},
+		},
+		{
+			name:        "drifted",
+			tasks:       []*plan.TaskPlan{taskPlan("ovs", inSync, nil), taskPlan("containers", drifted, nil)},
+			wantStatus:  drift.StatusDrifted,
+			wantTasks:   []string{drift.StatusInSync, drift.StatusDrifted},
+			wantSummary: drift.Summary{Hosts: 1, Drifted: 1},
+		},
+		{
+			name:        "failed task takes precedence over drift",
+			tasks:       []*plan.TaskPlan{taskPlan("ovs", nil, errors.New("access denied")), taskPlan("containers", drifted, nil)},
+			wantStatus:  drift.StatusFailed,
+			wantTasks:   []string{drift.StatusFailed, drift.StatusDrifted},
+			wantSummary: drift.Summary{Hosts: 1, Failed: 1},
+		},
+		{
+			name:        "unreachable takes precedence over failed tasks",
+			unreachable: true,
+			tasks:       []*plan.TaskPlan{taskPlan("ovs", nil, errors.New("connection refused"))},
+			wantStatus:  drift.StatusUnreachable,
+			wantTasks:   []string{drift.StatusFailed},
+			wantSummary: drift.Summary{Hosts: 1, Unreachable: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts := []*config.Host{{HostConfig: &config.HostConfig{Host: "win-1"}, Unreachable: tt.unreachable}}
+			plans := []*plan.HostPlan{{Host: "win-1", Tasks: tt.tasks}}
+			r := drift.New(hosts, plans, time.Now())
+			if r.Summary != tt.wantSummary {
+				t.Errorf("Summary = %+v, want %+v", r.Summary, tt.wantSummary)
+			}
+			if r.HasDrift() != (tt.wantStatus == drift.StatusDrifted) {
+				t.Errorf("HasDrift() = %v with host status %s", r.HasDrift(), tt.wantStatus)
+			}
+			hostReport := r.Hosts[0]
+			if hostReport.Status != tt.wantStatus {
+				t.Errorf("host status %s, want %s", hostReport.Status, tt.wantStatus)
+			}
+			if len(hostReport.Tasks) != len(tt.wantTasks) {
+				t.Fatalf("got %d tasks, want %d", len(hostReport.Tasks), len(tt.wantTasks))
+			}
+			for i, taskReport := range hostReport.Tasks {
+				if taskReport.Status != tt.wantTasks[i] {
+					t.Errorf("task %s status %s, want %s", taskReport.Task, taskReport.Status, tt.wantTasks[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewItems(t *testing.T) {
+	hosts := []*config.Host{{HostConfig: &config.HostConfig{Host: "win-1"}}}
+	plans := []*plan.HostPlan{{Host: "win-1", Tasks: []*plan.TaskPlan{
+		taskPlan("containers", drifted, nil),
+		taskPlan("ovs", nil, errors.New("failed to log in with s3cr3t-password")),
+	}}}
+	secret.Register("s3cr3t-password")
+	r := drift.New(hosts, plans, time.Now())
+	items := r.Hosts[0].Tasks[0].Items
+	want := []drift.Item{
+		{Item: "Containers", Current: "Enabled", Desired: "Enabled"},
+		{Item: "docker", Current: "Stopped", Desired: "Running", Drifted: true, Action: "start service"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+	for i, item := range items {
+		if *item != want[i] {
+			t.Errorf("item %d = %+v, want %+v", i, *item, want[i])
+		}
+	}
+	failed := r.Hosts[0].Tasks[1]
+	if failed.Error != "failed to log in with ******" || len(failed.Items) != 0 {
+		t.Errorf("failed task has error %q and items %v", failed.Error, failed.Items)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	hosts := []*config.Host{
+		{HostConfig: &config.HostConfig{Host: "win-1"}},
+		{HostConfig: &config.HostConfig{Host: "win-2"}},
+		{HostConfig: &config.HostConfig{Host: "win-3"}},
+		{HostConfig: &config.HostConfig{Host: "win-4"}, Unreachable: true},
+	}
+	plans := []*plan.HostPlan{
+		{Host: "win-1", Tasks: []*plan.TaskPlan{taskPlan("ovs", inSync, nil)}},
+		{Host: "win-2", Tasks: []*plan.TaskPlan{taskPlan("ovs", inSync, nil), taskPlan("containers", drifted, nil)}},
+		{Host: "win-3", Tasks: []*plan.TaskPlan{taskPlan("ovs", nil, errors.New("access denied"))}},
+		{Host: "win-4", Tasks: []*plan.TaskPlan{taskPlan("ovs", nil, errors.New("connection refused"))}},
+	}
+	var buf bytes.Buffer
+	if err := drift.New(hosts, plans, time.Now()).WriteText(&buf); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	want := strings.Join([]string{
+		"Host win-1: in sync",
+		"Host win-2: drifted",
+		"  Task containers (containers-feature): drifted",
+		"    ~ docker: Stopped, desired Running",
+		"Host win-3: failed",
+		"  Task ovs (ovs-feature): failed",
+		"    ! access denied",
+		"Host win-4: unreachable",
+		"  Task ovs (ovs-feature): failed",
+		"    ! connection refused",
+		"1 of 4 hosts drifted, 1 failed, 1 unreachable",
+		"",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("WriteText wrote:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
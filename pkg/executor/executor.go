@@ -0,0 +1,110 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// transferChunkSize is the size of raw bytes sent in one PowerShell command
+	// when uploading a file. The base64 encoded chunk is wrapped in an encoded
+	// PowerShell command, so keep it well below the Windows command line limit.
+	transferChunkSize = 2048
+	// psPreamble is prepended to every PowerShell command by winrm.Powershell.
+	psPreamble = "$ProgressPreference = 'SilentlyContinue';"
+)
+
+// Executor runs commands on a remote Windows host. Implementations only return
+// an error when the command cannot be delivered or its result cannot be
+// collected, a command which runs but fails is reported by its exit code.
+// A command still running when ctx is done is terminated, and ctx.Err() is
+// returned.
+type Executor interface {
+	// RunPS runs a PowerShell script on the remote host.
+	RunPS(ctx context.Context, cmd string) (code int, stdout string, stderr string, err error)
+	// Run runs a command with the default shell of the remote host.
+	Run(ctx context.Context, cmd string) (code int, stdout string, stderr string, err error)
+	// Upload copies the content of src to dstPath on the remote host.
+	Upload(ctx context.Context, src io.Reader, dstPath string) error
+	// Download copies the remote file srcPath into dst.
+	Download(ctx context.Context, srcPath string, dst io.Writer) error
+	// Close releases the underlying connection.
+	Close() error
+}
+
+// QuotePS quotes a string as a PowerShell single quoted literal.
+func QuotePS(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// uploadWithPS uploads a file by appending base64 encoded chunks to dstPath
+// with PowerShell, it works on any transport which is able to run PowerShell.
+func uploadWithPS(ctx context.Context, e Executor, src io.Reader, dstPath string) error {
+	buf := make([]byte, transferChunkSize)
+	mode := "Create"
+	for {
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 || mode == "Create" {
+			cmd := fmt.Sprintf(`$bytes = [Convert]::FromBase64String('%s'); $file = [IO.File]::Open(%s, [IO.FileMode]::%s); try { $file.Write($bytes, 0, $bytes.Length) } finally { $file.Close() }`,
+				base64.StdEncoding.EncodeToString(buf[:n]), QuotePS(dstPath), mode)
+			code, _, stderr, err := e.RunPS(ctx, cmd)
+			if err != nil {
+				return fmt.Errorf("failed to upload file %s: %v", dstPath, err)
+			}
+			if code != 0 {
+				return fmt.Errorf("failed to upload file %s, exit code: %d, error: %s", dstPath, code, stderr)
+			}
+			mode = "Append"
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read upload content for %s: %v", dstPath, readErr)
+		}
+	}
+}
+
+// downloadWithPS downloads a file by reading it as a base64 string with
+// PowerShell.
+func downloadWithPS(ctx context.Context, e Executor, srcPath string, dst io.Writer) error {
+	cmd := fmt.Sprintf("[Convert]::ToBase64String([IO.File]::ReadAllBytes(%s))", QuotePS(srcPath))
+	code, stdout, stderr, err := e.RunPS(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to download file %s: %v", srcPath, err)
+	}
+	if code != 0 {
+		return fmt.Errorf("failed to download file %s, exit code: %d, error: %s", srcPath, code, stderr)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout))
+	if err != nil {
+		return fmt.Errorf("failed to decode content of file %s: %v", srcPath, err)
+	}
+	_, err = io.Copy(dst, bytes.NewReader(data))
+	return err
+}
+
+// DecodePS returns the PowerShell script of a command built by RunPS, i.e.
+// "powershell.exe -EncodedCommand <base64 UTF-16LE script>". It returns false
+// if cmd is not an encoded PowerShell command.
+func DecodePS(cmd string) (string, bool) {
+	fields := strings.Fields(cmd)
+	if len(fields) != 3 || !strings.EqualFold(fields[0], "powershell.exe") || !strings.EqualFold(fields[1], "-EncodedCommand") {
+		return "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil || len(data)%2 != 0 {
+		return "", false
+	}
+	runes := make([]uint16, 0, len(data)/2)
+	for i := 0; i < len(data); i += 2 {
+		runes = append(runes, uint16(data[i])|uint16(data[i+1])<<8)
+	}
+	script := string(utf16.Decode(runes))
+	return strings.TrimPrefix(script, psPreamble), true
+}
@@ -0,0 +1,8 @@
+package executor
+
+import "time"
+
+// Backoff returns the wait of policy before the retry following attempt.
+func Backoff(policy *RetryPolicy, attempt int) time.Duration {
+	return policy.backoff(attempt)
+}
@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// DialFunc connects an executor to a host.
+type DialFunc func() (Executor, error)
+
+// LazyExecutor connects on its first command, so hosts are only connected by
+// the worker which uses them. After a command fails with a transport error,
+// the connection is closed and the next command connects again, e.g. after the
+// host restarted.
+type LazyExecutor struct {
+	dial DialFunc
+
+	mu       sync.Mutex
+	executor Executor
+}
+
+func NewLazyExecutor(dial DialFunc) *LazyExecutor {
+	return &LazyExecutor{dial: dial}
+}
+
+// Connect returns the connected executor, it connects if there is none.
+func (e *LazyExecutor) Connect() (Executor, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.executor != nil {
+		return e.executor, nil
+	}
+	executor, err := e.dial()
+	if err != nil {
+		return nil, err
+	}
+	e.executor = executor
+	return executor, nil
+}
+
+// reset closes the connection of executor if it is still the current one, and
+// ctx is not done, i.e. the error is not caused by the command being
+// cancelled.
+func (e *LazyExecutor) reset(ctx context.Context, executor Executor, err error) {
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.executor == executor {
+		e.executor.Close()
+		e.executor = nil
+	}
+}
+
+func (e *LazyExecutor) RunPS(ctx context.Context, cmd string) (int, string, string, error) {
+	executor, err := e.Connect()
+	if err != nil {
+		return 0, "", "", err
+	}
+	code, stdout, stderr, err := executor.RunPS(ctx, cmd)
+	e.reset(ctx, executor, err)
+	return code, stdout, stderr, err
+}
+
+func (e *LazyExecutor) Run(ctx context.Context, cmd string) (int, string, string, error) {
+	executor, err := e.Connect()
+	if err != nil {
+		return 0, "", "", err
+	}
+	code, stdout, stderr, err := executor.Run(ctx, cmd)
+	e.reset(ctx, executor, err)
+	return code, stdout, stderr, err
+}
+
+// Upload runs the PowerShell commands of the upload through RunPS, so that the
+// connection is only reset on transport errors, not when a command exits with
+// a non-zero code.
+func (e *LazyExecutor) Upload(ctx context.Context, src io.Reader, dstPath string) error {
+	return uploadWithPS(ctx, e, src, dstPath)
+}
+
+// Download runs the PowerShell command of the download through RunPS, see
+// Upload.
+func (e *LazyExecutor) Download(ctx context.Context, srcPath string, dst io.Writer) error {
+	return downloadWithPS(ctx, e, srcPath, dst)
+}
+
+// Close closes the connection if there is one, the next command connects
+// again.
+func (e *LazyExecutor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.executor == nil {
+		return nil
+	}
+	err := e.executor.Close()
+	e.executor = nil
+	return err
+}
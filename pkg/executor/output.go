@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"unicode/utf8"
+)
+
+// DefaultOutputTailSize is the number of bytes of stdout and stderr an
+// OutputTail keeps by default.
+const DefaultOutputTailSize = 4096
+
+// OutputTail keeps the end of the output of the commands run by a
+// RetryExecutor with a context returned by WithOutputTail.
+type OutputTail struct {
+	mu     sync.Mutex
+	size   int
+	stdout []byte
+	stderr []byte
+}
+
+func NewOutputTail(size int) *OutputTail {
+	return &OutputTail{size: size}
+}
+
+type outputTailKey struct{}
+
+// WithOutputTail returns a context which records the output of commands into
+// tail, a nil tail stops recording.
+func WithOutputTail(ctx context.Context, tail *OutputTail) context.Context {
+	return context.WithValue(ctx, outputTailKey{}, tail)
+}
+
+// recordOutput records the output of a command into the OutputTail of ctx if
+// there is one.
+func recordOutput(ctx context.Context, stdout, stderr string) {
+	if tail, ok := ctx.Value(outputTailKey{}).(*OutputTail); ok && tail != nil {
+		tail.Write(stdout, stderr)
+	}
+}
+
+func (t *OutputTail) Write(stdout, stderr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stdout = t.trim(appendOutput(t.stdout, stdout))
+	t.stderr = t.trim(appendOutput(t.stderr, stderr))
+}
+
+// appendOutput appends the output of a command on a new line.
+func appendOutput(b []byte, output string) []byte {
+	if output == "" {
+		return b
+	}
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+	return append(b, output...)
+}
+
+// trim keeps the last size bytes of b, without splitting a UTF-8 character.
+func (t *OutputTail) trim(b []byte) []byte {
+	if len(b) <= t.size {
+		return b
+	}
+	b = b[len(b)-t.size:]
+	for len(b) > 0 && !utf8.RuneStart(b[0]) {
+		b = b[1:]
+	}
+	return append([]byte(nil), b...)
+}
+
+func (t *OutputTail) Stdout() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.stdout)
+}
+
+func (t *OutputTail) Stderr() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.stderr)
+}
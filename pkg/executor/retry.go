@@ -0,0 +1,282 @@
+package executor
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"k8s.io/klog"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBackoff     = 2 * time.Second
+	DefaultRetryMaxBackoff  = 30 * time.Second
+	DefaultRetryJitter      = 0.2
+)
+
+// RetryPolicy configures how commands failing with a transient transport
+// error are retried, see IsTransient. Commands which run and exit with a non-zero code are not retried
+// unless the code is listed in RetryExitCodes.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of a command including the first
+	// one, 1 disables retries.
+	MaxAttempts int `yaml:"maxAttempts,omitempty"`
+	// Backoff is the wait before the first retry, it is doubled for every
+	// further retry up to MaxBackoff.
+	Backoff    time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"maxBackoff,omitempty"`
+	// Jitter randomizes every wait by up to this fraction of it, e.g. 0.2 for
+	// +/-20%.
+	Jitter *float64 `yaml:"jitter,omitempty"`
+	// RetryExitCodes are the exit codes which are retried like transport
+	// errors, e.g. the code of a command known to fail transiently.
+	RetryExitCodes []int `yaml:"retryExitCodes,omitempty"`
+}
+
+// NoRetry disables retries, e.g. for commands which are not idempotent, such as
+// those applying features, or are expected to fail while a host restarts.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// WithDefaults returns the policy with the unset fields taken from defaults.
+func (p RetryPolicy) WithDefaults(defaults RetryPolicy) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+	if p.Backoff == 0 {
+		p.Backoff = defaults.Backoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = defaults.MaxBackoff
+	}
+	if p.Jitter == nil {
+		p.Jitter = defaults.Jitter
+	}
+	if p.RetryExitCodes == nil {
+		p.RetryExitCodes = defaults.RetryExitCodes
+	}
+	return p
+}
+
+// SetDefaults sets the unset fields to the Default* values.
+func (p *RetryPolicy) SetDefaults() {
+	jitter := DefaultRetryJitter
+	*p = p.WithDefaults(RetryPolicy{
+		MaxAttempts: DefaultRetryMaxAttempts,
+		Backoff:     DefaultRetryBackoff,
+		MaxBackoff:  DefaultRetryMaxBackoff,
+		Jitter:      &jitter,
+	})
+}
+
+// retryable returns whether the result of an attempt is retried, ctx errors
+// never are.
+func (p *RetryPolicy) retryable(ctx context.Context, code int, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return IsTransient(err)
+	}
+	for _, retryCode := range p.RetryExitCodes {
+		if code == retryCode {
+			return true
+		}
+	}
+	return false
+}
+
+// permanentErrorMessages are parts of the messages of errors which retrying
+// does not fix, e.g. rejected credentials or host keys. The SSH and WinRM
+// packages format the errors they get into new ones, so their types are lost.
+var permanentErrorMessages = []string{
+	"unable to authenticate",
+	"knownhosts:",
+	"known hosts file",
+	"x509:",
+	"tls:",
+	"certificate",
+	"http error 401",
+	"http error 403",
+}
+
+// transientErrorMessages are parts of the messages of errors caused by the
+// network or by a host which is restarting, see permanentErrorMessages.
+var transientErrorMessages = []string{
+	"EOF",
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"no route to host",
+	"network is unreachable",
+	"use of closed network connection",
+	"timeout",
+	"timed out",
+	"exited without exit status",
+	// The WinRM service is unavailable, e.g. while the host starts.
+	"http error 5",
+}
+
+// IsTransient returns whether err is a transport error which may not happen
+// again, i.e. a network error, a timeout or a connection closed with EOF.
+// Authentication, host key and TLS verification errors are not transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateErr x509.CertificateInvalidError
+	if errors.As(err, &keyErr) || errors.As(err, &revokedErr) || errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certificateErr) {
+		return false
+	}
+	message := err.Error()
+	for _, permanent := range permanentErrorMessages {
+		if strings.Contains(message, permanent) {
+			return false
+		}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, transient := range transientErrorMessages {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the retry following the given attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter != nil && *p.Jitter > 0 {
+		wait = time.Duration(float64(wait) * (1 + *p.Jitter*(2*rand.Float64()-1)))
+	}
+	return wait
+}
+
+// RetryStats counts the retries of the commands run with a context returned
+// by WithRetryStats.
+type RetryStats struct {
+	mu      sync.Mutex
+	retries int
+}
+
+func (s *RetryStats) Retries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retries
+}
+
+func (s *RetryStats) add() {
+	s.mu.Lock()
+	s.retries++
+	s.mu.Unlock()
+}
+
+type retryPolicyKey struct{}
+
+type retryStatsKey struct{}
+
+// WithRetryPolicy returns a context which overrides the policy of a
+// RetryExecutor, the unset fields of policy keep the executor's values.
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// WithRetryStats returns a context which counts the retries of a
+// RetryExecutor into stats.
+func WithRetryStats(ctx context.Context, stats *RetryStats) context.Context {
+	return context.WithValue(ctx, retryStatsKey{}, stats)
+}
+
+// RetryExecutor retries the commands of an Executor according to a
+// RetryPolicy, and records the output of every attempt into the OutputTail of
+// the context. Uploads are not retried as the appended chunks are not
+// idempotent.
+type RetryExecutor struct {
+	executor Executor
+	policy   RetryPolicy
+	// name identifies the executor in logs, e.g. the transport and host.
+	name string
+}
+
+func NewRetryExecutor(executor Executor, policy RetryPolicy, name string) *RetryExecutor {
+	return &RetryExecutor{executor: executor, policy: policy, name: name}
+}
+
+func (e *RetryExecutor) RunPS(ctx context.Context, cmd string) (int, string, string, error) {
+	return e.retry(ctx, cmd, e.executor.RunPS)
+}
+
+func (e *RetryExecutor) Run(ctx context.Context, cmd string) (int, string, string, error) {
+	return e.retry(ctx, cmd, e.executor.Run)
+}
+
+func (e *RetryExecutor) Upload(ctx context.Context, src io.Reader, dstPath string) error {
+	return e.executor.Upload(ctx, src, dstPath)
+}
+
+// Download is retried, the encoded content of the file is not recorded as
+// output.
+func (e *RetryExecutor) Download(ctx context.Context, srcPath string, dst io.Writer) error {
+	return downloadWithPS(WithOutputTail(ctx, nil), e, srcPath, dst)
+}
+
+func (e *RetryExecutor) Close() error {
+	return e.executor.Close()
+}
+
+func (e *RetryExecutor) retry(ctx context.Context, cmd string, run func(ctx context.Context, cmd string) (int, string, string, error)) (int, string, string, error) {
+	policy := e.policy
+	if override, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		policy = override.WithDefaults(e.policy)
+	}
+	stats, _ := ctx.Value(retryStatsKey{}).(*RetryStats)
+	for attempt := 1; ; attempt++ {
+		code, stdout, stderr, err := run(ctx, cmd)
+		recordOutput(ctx, stdout, stderr)
+		if attempt >= policy.MaxAttempts || !policy.retryable(ctx, code, err) {
+			return code, stdout, stderr, err
+		}
+		wait := policy.backoff(attempt)
+		if err != nil {
+			klog.Infof("Retry command on %s in %v, attempt %d of %d failed: %v", e.name, wait.Round(time.Millisecond), attempt, policy.MaxAttempts, err)
+		} else {
+			klog.Infof("Retry command on %s in %v, attempt %d of %d exited with code %d", e.name, wait.Round(time.Millisecond), attempt, policy.MaxAttempts, code)
+		}
+		if stats != nil {
+			stats.add()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return code, stdout, stderr, err
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package executor_test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "deadline exceeded" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "EOF", err: io.EOF, want: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, want: true},
+		{name: "formatted EOF", err: fmt.Errorf("cannot create SSH session: %v", io.EOF), want: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, want: true},
+		{name: "connection reset", err: &url.Error{Op: "Post", URL: "https://win-1:5986/wsman", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}, want: true},
+		{name: "timeout", err: &url.Error{Op: "Post", URL: "https://win-1:5986/wsman", Err: timeoutError{}}, want: true},
+		{name: "WinRM service unavailable", err: errors.New("http error 503: Service Unavailable"), want: true},
+		{name: "SSH exit status missing", err: errors.New("did not get an exit status for SSH command: wait: remote command exited without exit status or exit signal"), want: true},
+		{name: "SSH authentication", err: errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain"), want: false},
+		{name: "host key mismatch", err: &knownhosts.KeyError{Want: []knownhosts.KnownKey{{Filename: "known_hosts", Line: 1}}}, want: false},
+		{name: "formatted host key mismatch", err: errors.New("ssh: handshake failed: knownhosts: key mismatch"), want: false},
+		{name: "unknown host key", err: errors.New("ssh: handshake failed: host key SHA256:abc of win-1:22 is not in known hosts file known_hosts, add it or set trustOnFirstUse"), want: false},
+		{name: "revoked host key", err: &knownhosts.RevokedError{}, want: false},
+		{name: "unknown authority", err: &url.Error{Op: "Post", URL: "https://win-1:5986/wsman", Err: x509.UnknownAuthorityError{}}, want: false},
+		{name: "certificate hostname", err: &url.Error{Op: "Post", URL: "https://win-1:5986/wsman", Err: x509.HostnameError{Certificate: &x509.Certificate{}, Host: "win-1"}}, want: false},
+		{name: "pinned fingerprint", err: &url.Error{Op: "Post", URL: "https://win-1:5986/wsman", Err: errors.New("server certificate fingerprint 00 does not match the pinned fingerprint 01")}, want: false},
+		{name: "WinRM unauthorized", err: errors.New("http error 401: "), want: false},
+		{name: "unknown error", err: errors.New("cannot encode PowerShell command"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := executor.IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+type result struct {
+	code int
+	err  error
+}
+
+// scriptedExecutor returns the results in order, the last one is repeated.
+type scriptedExecutor struct {
+	executor.Executor
+	results []result
+	runs    int
+}
+
+func (e *scriptedExecutor) RunPS(ctx context.Context, cmd string) (int, string, string, error) {
+	r := e.results[len(e.results)-1]
+	if e.runs < len(e.results) {
+		r = e.results[e.runs]
+	}
+	e.runs++
+	return r.code, "", "", r.err
+}
+
+func TestRetryExecutor(t *testing.T) {
+	jitter := 0.0
+	policy := executor.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, Jitter: &jitter, RetryExitCodes: []int{5}}
+	transient := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	permanent := errors.New("ssh: handshake failed: ssh: unable to authenticate")
+	tests := []struct {
+		name    string
+		results []result
+		// override is the policy set in the context, if any.
+		override *executor.RetryPolicy
+		wantErr  error
+		wantCode int
+		wantRuns int
+	}{
+		{
+			name:     "success",
+			results:  []result{{}},
+			wantRuns: 1,
+		},
+		{
+			name:     "transient error",
+			results:  []result{{err: transient}, {err: io.EOF}, {}},
+			wantRuns: 3,
+		},
+		{
+			name:     "transient errors exhaust attempts",
+			results:  []result{{err: transient}},
+			wantErr:  transient,
+			wantRuns: 3,
+		},
+		{
+			name:     "permanent error",
+			results:  []result{{err: permanent}, {}},
+			wantErr:  permanent,
+			wantRuns: 1,
+		},
+		{
+			name:     "retried exit code",
+			results:  []result{{code: 5}, {}},
+			wantRuns: 2,
+		},
+		{
+			name:     "exit code",
+			results:  []result{{code: 1}, {}},
+			wantCode: 1,
+			wantRuns: 1,
+		},
+		{
+			name:     "no retry",
+			results:  []result{{err: transient}, {}},
+			override: &executor.NoRetry,
+			wantErr:  transient,
+			wantRuns: 1,
+		},
+		{
+			name:     "override keeps unset fields",
+			results:  []result{{code: 5}, {code: 5}, {code: 5}, {}},
+			override: &executor.RetryPolicy{MaxAttempts: 4},
+			wantRuns: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &scriptedExecutor{results: tt.results}
+			retryExecutor := executor.NewRetryExecutor(e, policy, "test")
+			stats := &executor.RetryStats{}
+			ctx := executor.WithRetryStats(context.Background(), stats)
+			if tt.override != nil {
+				ctx = executor.WithRetryPolicy(ctx, *tt.override)
+			}
+			code, _, _, err := retryExecutor.RunPS(ctx, "hostname")
+			if err != tt.wantErr {
+				t.Errorf("RunPS returned error %v, want %v", err, tt.wantErr)
+			}
+			if code != tt.wantCode {
+				t.Errorf("RunPS returned code %d, want %d", code, tt.wantCode)
+			}
+			if e.runs != tt.wantRuns {
+				t.Errorf("command ran %d times, want %d", e.runs, tt.wantRuns)
+			}
+			if retries := stats.Retries(); retries != tt.wantRuns-1 {
+				t.Errorf("%d retries, want %d", retries, tt.wantRuns-1)
+			}
+		})
+	}
+}
+
+func TestRetryExecutorCancel(t *testing.T) {
+	policy := executor.RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}
+	e := &scriptedExecutor{results: []result{{err: io.EOF}, {}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, _, err := executor.NewRetryExecutor(e, policy, "test").RunPS(ctx, "hostname"); err != io.EOF {
+		t.Errorf("RunPS returned error %v, want %v", err, io.EOF)
+	}
+	if e.runs != 1 {
+		t.Errorf("command ran %d times, want 1", e.runs)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	noJitter := 0.0
+	jitter := 0.5
+	tests := []struct {
+		name    string
+		policy  executor.RetryPolicy
+		attempt int
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{
+			name:    "first retry",
+			policy:  executor.RetryPolicy{Backoff: time.Second, MaxBackoff: time.Minute, Jitter: &noJitter},
+			attempt: 1,
+			wantMin: time.Second,
+			wantMax: time.Second,
+		},
+		{
+			name:    "doubled",
+			policy:  executor.RetryPolicy{Backoff: time.Second, MaxBackoff: time.Minute, Jitter: &noJitter},
+			attempt: 3,
+			wantMin: 4 * time.Second,
+			wantMax: 4 * time.Second,
+		},
+		{
+			name:    "capped",
+			policy:  executor.RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: &noJitter},
+			attempt: 10,
+			wantMin: 5 * time.Second,
+			wantMax: 5 * time.Second,
+		},
+		{
+			name:    "no cap",
+			policy:  executor.RetryPolicy{Backoff: time.Second},
+			attempt: 6,
+			wantMin: 32 * time.Second,
+			wantMax: 32 * time.Second,
+		},
+		{
+			name:    "jitter",
+			policy:  executor.RetryPolicy{Backoff: 2 * time.Second, MaxBackoff: time.Minute, Jitter: &jitter},
+			attempt: 1,
+			wantMin: time.Second,
+			wantMax: 3 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				if got := executor.Backoff(&tt.policy, tt.attempt); got < tt.wantMin || got > tt.wantMax {
+					t.Fatalf("backoff of attempt %d is %v, want between %v and %v", tt.attempt, got, tt.wantMin, tt.wantMax)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/masterzen/winrm"
+	"golang.org/x/crypto/ssh"
+	"io"
+)
+
+// SSHExecutor runs commands over SSH.
+type SSHExecutor struct {
+	client *ssh.Client
+}
+
+func NewSSHExecutor(client *ssh.Client) *SSHExecutor {
+	return &SSHExecutor{client: client}
+}
+
+// RunPS runs the script as an encoded command, so it works no matter the
+// default shell of the OpenSSH server is cmd.exe or powershell.exe.
+func (e *SSHExecutor) RunPS(ctx context.Context, cmd string) (int, string, string, error) {
+	encoded := winrm.Powershell(cmd)
+	if encoded == "" {
+		return 0, "", "", fmt.Errorf("cannot encode PowerShell command")
+	}
+	return e.Run(ctx, encoded)
+}
+
+// Run runs the command in a new session, the session is closed if ctx is done
+// before the command completes.
+func (e *SSHExecutor) Run(ctx context.Context, cmd string) (int, string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", "", err
+	}
+	session, err := e.client.NewSession()
+	if err != nil {
+		return 0, "", "", fmt.Errorf("cannot create SSH session: %v", err)
+	}
+	defer session.Close()
+
+	var stdoutB, stderrB bytes.Buffer
+	session.Stdout = &stdoutB
+	session.Stderr = &stderrB
+	if err := session.Start(cmd); err != nil {
+		return 0, "", "", fmt.Errorf("cannot start SSH command: %v", err)
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- session.Wait()
+	}()
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		// OpenSSH on Windows ignores signals, closing the session
+		// terminates the command.
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return 0, "", "", ctx.Err()
+	}
+	if err != nil {
+		switch e := err.(type) {
+		case *ssh.ExitMissingError:
+			return 0, "", "", fmt.Errorf("did not get an exit status for SSH command: %v", e)
+		case *ssh.ExitError:
+			// SSH operation successful, but command returned error code
+			return e.ExitStatus(), stdoutB.String(), stderrB.String(), nil
+		default:
+			return 0, "", "", fmt.Errorf("unknown error when executing SSH command: %v", err)
+		}
+	}
+	// command is successful
+	return 0, stdoutB.String(), stderrB.String(), nil
+}
+
+func (e *SSHExecutor) Upload(ctx context.Context, src io.Reader, dstPath string) error {
+	return uploadWithPS(ctx, e, src, dstPath)
+}
+
+func (e *SSHExecutor) Download(ctx context.Context, srcPath string, dst io.Writer) error {
+	return downloadWithPS(ctx, e, srcPath, dst)
+}
+
+func (e *SSHExecutor) Close() error {
+	return e.client.Close()
+}
@@ -0,0 +1,179 @@
+package executor_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features/installovs"
+	"github.com/ruicao93/antrea-windows-ci/pkg/testing/fakehost"
+	"github.com/ruicao93/antrea-windows-ci/pkg/testing/sshserver"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSSHServer(t *testing.T) (*sshserver.Server, *executor.SSHExecutor) {
+	s, err := sshserver.New("administrator", "password")
+	if err != nil {
+		t.Fatalf("Failed to start SSH server: %v", err)
+	}
+	e, err := s.Executor()
+	if err != nil {
+		s.Close()
+		t.Fatalf("Failed to connect to SSH server: %v", err)
+	}
+	t.Cleanup(func() {
+		e.Close()
+		s.Close()
+	})
+	return s, e
+}
+
+func TestSSHExecutorRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		ps         bool
+		cmd        string
+		code       int
+		stdout     string
+		stderr     string
+		wantErr    string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "success",
+			cmd:        "hostname",
+			stdout:     "win-1\r\n",
+			wantStdout: "win-1\r\n",
+		},
+		{
+			name:       "exit status",
+			cmd:        "exit 3",
+			code:       3,
+			stdout:     "partial output",
+			stderr:     "command failed",
+			wantCode:   3,
+			wantStdout: "partial output",
+			wantStderr: "command failed",
+		},
+		{
+			name:       "PowerShell exit status",
+			ps:         true,
+			cmd:        `Get-Service "ovs-vswitchd"`,
+			code:       1,
+			stderr:     "Cannot find any service with service name 'ovs-vswitchd'.",
+			wantCode:   1,
+			wantStderr: "Cannot find any service with service name 'ovs-vswitchd'.",
+		},
+		{
+			name:    "missing exit status",
+			cmd:     "Restart-Computer -Force",
+			code:    sshserver.ExitMissing,
+			wantErr: "did not get an exit status for SSH command",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, e := newSSHServer(t)
+			handle := s.Handle
+			if tt.ps {
+				handle = s.HandlePS
+			}
+			handle("^"+regexp.QuoteMeta(tt.cmd)+"$", func(_ []string, _ io.Reader, stdout, stderr io.Writer) int {
+				io.WriteString(stdout, tt.stdout)
+				io.WriteString(stderr, tt.stderr)
+				return tt.code
+			})
+			run := e.Run
+			if tt.ps {
+				run = e.RunPS
+			}
+			code, stdout, stderr, err := run(context.Background(), tt.cmd)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run returned error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if code != tt.wantCode || stdout != tt.wantStdout || stderr != tt.wantStderr {
+				t.Errorf("Run returned (%d, %q, %q), want (%d, %q, %q)", code, stdout, stderr, tt.wantCode, tt.wantStdout, tt.wantStderr)
+			}
+			if commands := s.Commands(); len(commands) != 1 || commands[0] != tt.cmd {
+				t.Errorf("server received commands %q, want [%q]", commands, tt.cmd)
+			}
+		})
+	}
+}
+
+func TestSSHExecutorCancel(t *testing.T) {
+	s, e := newSSHServer(t)
+	started := make(chan struct{})
+	closed := make(chan struct{})
+	s.Handle("^ovs-install$", func(_ []string, stdin io.Reader, _, _ io.Writer) int {
+		close(started)
+		// The read returns once the client closes the session.
+		io.Copy(ioutil.Discard, stdin)
+		close(closed)
+		return 0
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, _, _, err := e.Run(ctx, "ovs-install")
+	if err != context.Canceled {
+		t.Fatalf("Run returned error %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session is not closed after ctx is cancelled")
+	}
+	if _, _, _, err := e.Run(ctx, "ovs-install"); err != context.Canceled {
+		t.Errorf("Run with a cancelled ctx returned error %v, want %v", err, context.Canceled)
+	}
+	if commands := s.Commands(); len(commands) != 1 {
+		t.Errorf("server received commands %q, want 1", commands)
+	}
+}
+
+// TestSSHInstallOVS applies InstallOVS with Reconcile-OVS.ps1 run over SSH, and
+// the other commands run on the fake host directly as over WinRM.
+func TestSSHInstallOVS(t *testing.T) {
+	h := fakehost.New()
+	s, e := newSSHServer(t)
+	s.Backend = h
+	reconcileOVS := regexp.QuoteMeta(fmt.Sprintf("& '%s'", installovs.ReconcileOVSFilePath))
+	s.HandlePS("^"+reconcileOVS+`\s+-Operation install$`, func(_ []string, _ io.Reader, stdout, _ io.Writer) int {
+		h.SetService("ovsdb-server", "Running")
+		h.SetService("ovs-vswitchd", "Running")
+		io.WriteString(stdout, "OVS installed\r\n")
+		return 0
+	})
+	host := &config.Host{
+		HostConfig:  s.HostConfig(),
+		Executor:    h,
+		SSHExecutor: e,
+	}
+	if err := features.ApplyFeature(context.Background(), host, &config.Feature{Name: installovs.FeatureName}); err != nil {
+		t.Fatalf("ApplyFeature failed: %v", err)
+	}
+	if _, ok := h.File(installovs.ReconcileOVSFilePath); !ok {
+		t.Errorf("Reconcile-OVS.ps1 is not downloaded over SSH")
+	}
+	commands := s.Commands()
+	if len(commands) != 2 || !strings.HasPrefix(commands[0], "curl.exe -sLo ") || !regexp.MustCompile(reconcileOVS).MatchString(commands[1]) {
+		t.Errorf("server received commands %q, want the download and the run of Reconcile-OVS.ps1", commands)
+	}
+}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/masterzen/winrm"
+	"io"
+	"sync"
+)
+
+// WinRMExecutor runs commands over WinRM.
+type WinRMExecutor struct {
+	client *winrm.Client
+}
+
+func NewWinRMExecutor(client *winrm.Client) *WinRMExecutor {
+	return &WinRMExecutor{client: client}
+}
+
+func (e *WinRMExecutor) RunPS(ctx context.Context, cmd string) (int, string, string, error) {
+	encoded := winrm.Powershell(cmd)
+	if encoded == "" {
+		return 0, "", "", fmt.Errorf("cannot encode PowerShell command")
+	}
+	return e.Run(ctx, encoded)
+}
+
+// Run runs the command in a new shell like winrm.Client.RunWithString, the
+// command is terminated if ctx is done before it completes.
+func (e *WinRMExecutor) Run(ctx context.Context, cmd string) (int, string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", "", err
+	}
+	shell, err := e.client.CreateShell()
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer shell.Close()
+	command, err := shell.Execute(cmd)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	var stdoutErr, stderrErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, stdoutErr = io.Copy(&stdout, command.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		_, stderrErr = io.Copy(&stderr, command.Stderr)
+	}()
+	finished := make(chan struct{})
+	go func() {
+		command.Wait()
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		// Close signals the remote process to terminate. The output of a
+		// terminated command is not collected, so the copying goroutines
+		// only exit when the command output ends.
+		command.Close()
+		return 0, "", "", ctx.Err()
+	}
+	command.Close()
+	if stdoutErr != nil {
+		return 0, "", "", stdoutErr
+	}
+	if stderrErr != nil {
+		return 0, "", "", stderrErr
+	}
+	return command.ExitCode(), stdout.String(), stderr.String(), nil
+}
+
+func (e *WinRMExecutor) Upload(ctx context.Context, src io.Reader, dstPath string) error {
+	return uploadWithPS(ctx, e, src, dstPath)
+}
+
+func (e *WinRMExecutor) Download(ctx context.Context, srcPath string, dst io.Writer) error {
+	return downloadWithPS(ctx, e, srcPath, dst)
+}
+
+// Close is a no-op, WinRM creates a new shell for every command.
+func (e *WinRMExecutor) Close() error {
+	return nil
+}
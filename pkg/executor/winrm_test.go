@@ -0,0 +1,204 @@
+package executor_test
+
+import (
+	"context"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/testing/winrmserver"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newWinRMServer(t *testing.T) (*winrmserver.Server, *executor.WinRMExecutor) {
+	s := winrmserver.New("administrator", "password")
+	t.Cleanup(s.Close)
+	e, err := s.Executor()
+	if err != nil {
+		t.Fatalf("Failed to create WinRM executor: %v", err)
+	}
+	return s, e
+}
+
+func TestWinRMExecutorRunPS(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "success",
+			script: "$(Get-WindowsFeature -Name Containers -ErrorAction SilentlyContinue).InstallState",
+			stdout: "Installed\r\n",
+		},
+		{
+			name:   "exit code",
+			script: "Install-WindowsFeature -Name Hyper-V",
+			code:   1,
+			stdout: "partial output",
+			stderr: "Install-WindowsFeature : The request to add or remove features failed.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, e := newWinRMServer(t)
+			s.RespondPS(`^`+regexp.QuoteMeta(tt.script)+`$`, tt.code, tt.stdout, tt.stderr)
+			code, stdout, stderr, err := e.RunPS(context.Background(), tt.script)
+			if err != nil {
+				t.Fatalf("RunPS failed: %v", err)
+			}
+			if code != tt.code || stdout != tt.stdout || stderr != tt.stderr {
+				t.Errorf("RunPS returned (%d, %q, %q), want (%d, %q, %q)", code, stdout, stderr, tt.code, tt.stdout, tt.stderr)
+			}
+			if commands := s.Commands(); len(commands) != 1 || commands[0] != tt.script {
+				t.Errorf("server received commands %q, want [%q]", commands, tt.script)
+			}
+			if shells := s.OpenShells(); shells != 0 {
+				t.Errorf("%d shells are not deleted", shells)
+			}
+		})
+	}
+}
+
+func TestWinRMExecutorFailRequests(t *testing.T) {
+	s, e := newWinRMServer(t)
+	s.RespondPS(`^hostname$`, 0, "win-1\r\n", "")
+	s.FailRequests(1, http.StatusServiceUnavailable)
+	if _, _, _, err := e.RunPS(context.Background(), "hostname"); err == nil {
+		t.Fatalf("RunPS succeeded, want error of the failed request")
+	}
+	code, stdout, _, err := e.RunPS(context.Background(), "hostname")
+	if err != nil {
+		t.Fatalf("RunPS failed after the failed request: %v", err)
+	}
+	if code != 0 || stdout != "win-1\r\n" {
+		t.Errorf("RunPS returned (%d, %q), want (0, %q)", code, stdout, "win-1\r\n")
+	}
+	if commands := s.Commands(); len(commands) != 1 {
+		t.Errorf("server received commands %q, want 1", commands)
+	}
+}
+
+func TestRetryExecutorWinRM(t *testing.T) {
+	jitter := 0.0
+	policy := executor.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, Jitter: &jitter}
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		code     int
+		// override is the policy set in the context, if any.
+		override    *executor.RetryPolicy
+		wantErr     bool
+		wantCode    int
+		wantRetries int
+		wantRuns    int
+	}{
+		{
+			name:     "no failure",
+			wantRuns: 1,
+		},
+		{
+			name:        "transient failures",
+			failures:    2,
+			status:      http.StatusInternalServerError,
+			wantRetries: 2,
+			wantRuns:    1,
+		},
+		{
+			name:        "failures exhaust attempts",
+			failures:    3,
+			status:      http.StatusServiceUnavailable,
+			wantErr:     true,
+			wantRetries: 2,
+		},
+		{
+			name:     "authentication failure is not retried",
+			failures: 1,
+			status:   http.StatusUnauthorized,
+			wantErr:  true,
+		},
+		{
+			name:     "no retry",
+			failures: 1,
+			status:   http.StatusInternalServerError,
+			override: &executor.NoRetry,
+			wantErr:  true,
+		},
+		{
+			name:     "exit code is not retried",
+			code:     2,
+			wantCode: 2,
+			wantRuns: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, e := newWinRMServer(t)
+			s.RespondPS(`^hostname$`, tt.code, "win-1\r\n", "")
+			s.FailRequests(tt.failures, tt.status)
+			retryExecutor := executor.NewRetryExecutor(e, policy, "winrm://test")
+			stats := &executor.RetryStats{}
+			ctx := executor.WithRetryStats(context.Background(), stats)
+			if tt.override != nil {
+				ctx = executor.WithRetryPolicy(ctx, *tt.override)
+			}
+			code, _, _, err := retryExecutor.RunPS(ctx, "hostname")
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("RunPS returned error %v, want error: %v", err, tt.wantErr)
+			}
+			if code != tt.wantCode {
+				t.Errorf("RunPS returned code %d, want %d", code, tt.wantCode)
+			}
+			if retries := stats.Retries(); retries != tt.wantRetries {
+				t.Errorf("%d retries, want %d", retries, tt.wantRetries)
+			}
+			if runs := len(s.Commands()); runs != tt.wantRuns {
+				t.Errorf("command ran %d times, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestWinRMExecutorCancel(t *testing.T) {
+	s, e := newWinRMServer(t)
+	started := make(chan struct{})
+	terminated := make(chan struct{})
+	s.HandlePS(`^Restart-Service ovs-vswitchd$`, func(_ []string, stdin io.Reader, _, _ io.Writer) int {
+		close(started)
+		// The read returns once the command is terminated by a Signal.
+		io.Copy(ioutil.Discard, stdin)
+		close(terminated)
+		return 0
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, _, _, err := e.RunPS(ctx, "Restart-Service ovs-vswitchd")
+	if err != context.Canceled {
+		t.Fatalf("RunPS returned error %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-terminated:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("command is not terminated after ctx is cancelled")
+	}
+	signaled := false
+	for _, action := range s.Actions() {
+		if action == winrmserver.ActionSignal {
+			signaled = true
+		}
+	}
+	if !signaled {
+		t.Errorf("no Signal request is sent, actions: %v", s.Actions())
+	}
+	if shells := s.OpenShells(); shells != 0 {
+		t.Errorf("%d shells are not deleted", shells)
+	}
+}
@@ -1,46 +1,313 @@
 package features
 
 import (
+	"context"
 	"fmt"
 	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
 	"github.com/ruicao93/antrea-windows-ci/pkg/features/installovs"
 	"github.com/ruicao93/antrea-windows-ci/pkg/features/windowscontainer"
+	"github.com/ruicao93/antrea-windows-ci/pkg/plan"
+	"github.com/ruicao93/antrea-windows-ci/pkg/schema"
+	"github.com/ruicao93/antrea-windows-ci/pkg/util"
 	"k8s.io/klog"
+	"sort"
+	"strings"
+	"time"
 )
 
 const (
-	InternalFeatureWindowsContainer = "WindowsContainer"
-	InternalFeatureOVSInstall = "InstallOVS"
+	InternalFeatureWindowsContainer = windowscontainer.FeatureName
+	InternalFeatureOVSInstall       = installovs.FeatureName
+
+	// rollbackTimeout limits a rollback which runs after the task timed out or
+	// the run was interrupted.
+	rollbackTimeout = 5 * time.Minute
 )
 
-var FeaturesMap map[string]func(*config.Host, *config.Feature) error
+// Feature is a feature which can be applied to hosts. The parameters of a task
+// are decoded and validated against Schema before any phase is called. Every
+// feature is driven through the same phases by ApplyFeature:
+//  1. Detect reads the current state of the host and reports the changes
+//     Apply would make, it must not mutate the host.
+//  2. Apply makes the changes if Detect reported any, and returns whether the
+//     host must be restarted for the changes to take effect.
+//  3. Verify checks the host is in the desired state, after the restart if
+//     Apply required one. It is called even if Apply is skipped.
+//
+// Apply must not restart the host, ApplyHost coalesces the restarts required by
+// the tasks of a host.
+//
+// If Apply or Verify after Apply fails, Rollback is called if the feature
+// implements Rollbacker.
+//
+// The commands of Apply and Rollback are not retried as they mutate the host
+// and may not be idempotent, only the commands of Detect and Verify are.
+type Feature interface {
+	Name() string
+	Schema() *schema.Schema
+	Detect(ctx context.Context, host *config.Host, params schema.Values) (*plan.Result, error)
+	Apply(ctx context.Context, host *config.Host, params schema.Values) (rebootRequired bool, err error)
+	Verify(ctx context.Context, host *config.Host, params schema.Values) error
+}
+
+// Rollbacker is implemented by features which can undo a failed Apply.
+// detected is the result of Detect before Apply, i.e. the state of the host
+// Rollback restores.
+type Rollbacker interface {
+	Rollback(ctx context.Context, host *config.Host, params schema.Values, detected *plan.Result) error
+}
+
+// DriftDetector is implemented by features which check more of the host than
+// Detect for drift, e.g. that a service is still running. Drift is detected by
+// Detect for the other features.
+type DriftDetector interface {
+	DetectDrift(ctx context.Context, host *config.Host, params schema.Values) (*plan.Result, error)
+}
+
+var registry = map[string]Feature{}
+
+// Register registers a feature by its name, it panics if the name is already
+// registered.
+func Register(feature Feature) {
+	if _, ok := registry[feature.Name()]; ok {
+		panic(fmt.Sprintf("feature %s is already registered", feature.Name()))
+	}
+	registry[feature.Name()] = feature
+}
+
+// Get returns the registered feature of name.
+func Get(name string) (Feature, bool) {
+	feature, ok := registry[name]
+	return feature, ok
+}
+
+// Names returns the sorted names of all registered features.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
 func init() {
-	FeaturesMap = make(map[string]func(*config.Host, *config.Feature) error)
-	FeaturesMap[InternalFeatureWindowsContainer] = windowscontainer.ApplyFeature
-	FeaturesMap[InternalFeatureOVSInstall] = installovs.ApplyFeature
+	Register(&windowscontainer.Feature{})
+	Register(&installovs.Feature{})
 }
 
-func ApplyFeature(host *config.Host, feature *config.Feature) error {
-	klog.Infof("Start feature %s for host: %s", feature.Name, host.HostConfig.Host)
-	if applyFunc, ok :=FeaturesMap[feature.Name]; !ok {
-		return fmt.Errorf("unsupported feature: %s", feature.Name)
-	} else {
-		return applyFunc(host, feature)
+// Schemas returns the parameter schemas of all registered features.
+func Schemas() map[string]*schema.Schema {
+	schemas := make(map[string]*schema.Schema, len(registry))
+	for name, feature := range registry {
+		schemas[name] = feature.Schema()
+	}
+	return schemas
+}
+
+// ValidateConfig validates the merged config files against the registered
+// features with the variable overrides, see config.Validate.
+func ValidateConfig(doc *config.Document, overrides map[string]string) error {
+	return config.Validate(doc, Schemas(), overrides)
+}
+
+// Doc returns the documentation of all registered features and their
+// parameters.
+func Doc() string {
+	names := Names()
+	docs := make([]string, 0, len(names))
+	for _, name := range names {
+		docs = append(docs, registry[name].Schema().Doc(name))
+	}
+	return strings.Join(docs, "\n")
+}
+
+func decodeFeature(feature *config.Feature) (Feature, schema.Values, error) {
+	f, ok := Get(feature.Name)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported feature: %s", feature.Name)
+	}
+	params, err := f.Schema().Decode(feature.Args, feature.KeyValues)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid parameters of feature %s: %v", feature.Name, err)
+	}
+	return f, params, nil
+}
+
+// runPhase runs a phase of a feature on a host with consistent logging, timing
+// and error wrapping.
+func runPhase(host *config.Host, f Feature, phase string, fn func() error) error {
+	klog.Infof("Start %s of feature %s for host: %s", phase, f.Name(), host.HostConfig.Host)
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		klog.Infof("Failed %s of feature %s for host %s after %v", phase, f.Name(), host.HostConfig.Host, elapsed)
+		return fmt.Errorf("%s of feature %s failed: %v", phase, f.Name(), err)
+	}
+	klog.Infof("Complete %s of feature %s for host %s in %v", phase, f.Name(), host.HostConfig.Host, elapsed)
+	return nil
+}
+
+// rollback calls Rollback of the feature if it implements Rollbacker, and
+// returns err with the rollback error if any. If ctx is already done, the
+// rollback still runs within rollbackTimeout so the host is not left half
+// applied.
+func rollback(ctx context.Context, host *config.Host, f Feature, params schema.Values, detected *plan.Result, err error) error {
+	rollbacker, ok := f.(Rollbacker)
+	if !ok {
+		return err
+	}
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), rollbackTimeout)
+		defer cancel()
+	}
+	ctx = executor.WithRetryPolicy(ctx, executor.NoRetry)
+	if rollbackErr := runPhase(host, f, "rollback", func() error {
+		return rollbacker.Rollback(ctx, host, params, detected)
+	}); rollbackErr != nil {
+		return fmt.Errorf("%v; %v", err, rollbackErr)
+	}
+	return err
+}
+
+// featureRun is a feature being applied to a host, Verify may be deferred
+// until the host is restarted.
+type featureRun struct {
+	host    *config.Host
+	feature Feature
+	params  schema.Values
+	// detected is the result of Detect before Apply.
+	detected *plan.Result
+	// applied is whether Apply was called, so a failed Verify is rolled back.
+	applied bool
+	// rebootRequired is whether Apply requires a restart before Verify, as
+	// reported by the feature or by the pending-reboot registry keys.
+	rebootRequired bool
+}
+
+func newFeatureRun(host *config.Host, feature *config.Feature) (*featureRun, error) {
+	f, params, err := decodeFeature(feature)
+	if err != nil {
+		return nil, err
+	}
+	return &featureRun{host: host, feature: f, params: params}, nil
+}
+
+// apply runs Detect and, if there are changes, Apply.
+func (r *featureRun) apply(ctx context.Context) error {
+	host, f := r.host, r.feature
+	if err := runPhase(host, f, "detect", func() error {
+		var err error
+		r.detected, err = f.Detect(ctx, host, r.params)
+		return err
+	}); err != nil {
+		return err
+	}
+	if !r.detected.HasChanges() {
+		klog.Infof("Skip apply of feature %s for host %s, no changes detected", f.Name(), host.HostConfig.Host)
+		return nil
+	}
+	if err := runPhase(host, f, "apply", func() error {
+		var err error
+		r.rebootRequired, err = f.Apply(executor.WithRetryPolicy(ctx, executor.NoRetry), host, r.params)
+		return err
+	}); err != nil {
+		return rollback(ctx, host, f, r.params, r.detected, err)
 	}
+	r.applied = true
+	if !r.rebootRequired {
+		key, err := util.RebootPending(ctx, host.Executor)
+		if err != nil {
+			return fmt.Errorf("failed to check pending reboot after feature %s: %v", f.Name(), err)
+		}
+		if key != "" {
+			klog.Infof("Registry key %s reports a pending reboot after feature %s for host %s", key, f.Name(), host.HostConfig.Host)
+			r.rebootRequired = true
+		}
+	}
+	return nil
 }
 
-func ApplyHost(host *config.Host) error {
-	klog.Infof("Start tasks for host: %s", host.HostConfig.Host)
-	if !host.HostConfig.DryRun {
-		for _, task := range host.Tasks {
-			if err := ApplyFeature(host, &task.Feature); err != nil {
-				return fmt.Errorf("failed to apply task %s, feature: %s for host %s: %v", task.Name, task.Feature.Name, host.HostConfig.Host, err)
-			}
+// verify runs Verify, and Rollback if it fails after Apply.
+func (r *featureRun) verify(ctx context.Context) error {
+	if err := runPhase(r.host, r.feature, "verify", func() error {
+		return r.feature.Verify(ctx, r.host, r.params)
+	}); err != nil {
+		if r.applied {
+			return rollback(ctx, r.host, r.feature, r.params, r.detected, err)
 		}
-	} else {
-		klog.Infof("Skip tasks for host: %s", host.HostConfig.Host)
+		return err
 	}
-	klog.Infof("Complete tasks for host: %s", host.HostConfig.Host)
 	return nil
-}
\ No newline at end of file
+}
+
+// ApplyFeature applies a single feature to a host, the host is restarted
+// before Verify if required. Use ApplyHost to coalesce the restarts of several
+// tasks.
+func ApplyFeature(ctx context.Context, host *config.Host, feature *config.Feature) error {
+	klog.Infof("Start feature %s for host: %s", feature.Name, host.HostConfig.Host)
+	run, err := newFeatureRun(host, feature)
+	if err != nil {
+		return err
+	}
+	if err := run.apply(ctx); err != nil {
+		return err
+	}
+	if run.rebootRequired {
+		if err := util.RestartComputer(ctx, host, true); err != nil {
+			return fmt.Errorf("failed to restart computer %s: %v", host.HostConfig.Host, err)
+		}
+	}
+	return run.verify(ctx)
+}
+
+func PlanFeature(ctx context.Context, host *config.Host, feature *config.Feature) (*plan.Result, error) {
+	f, params, err := decodeFeature(feature)
+	if err != nil {
+		return nil, err
+	}
+	return f.Detect(ctx, host, params)
+}
+
+// DetectFeatureDrift reports how the host differs from the desired state of the
+// feature, with DetectDrift if the feature implements DriftDetector.
+func DetectFeatureDrift(ctx context.Context, host *config.Host, feature *config.Feature) (*plan.Result, error) {
+	f, params, err := decodeFeature(feature)
+	if err != nil {
+		return nil, err
+	}
+	if driftDetector, ok := f.(DriftDetector); ok {
+		return driftDetector.DetectDrift(ctx, host, params)
+	}
+	return f.Detect(ctx, host, params)
+}
+
+// PlanHost plans all tasks of the host. Tasks are planned against the current
+// state of the host, so a task depending on the changes of a previous task may
+// report changes which the previous task would already make.
+func PlanHost(ctx context.Context, host *config.Host) *plan.HostPlan {
+	return planHost(ctx, host, PlanFeature)
+}
+
+// DriftHost detects the drift of the host from all its tasks, the changes of
+// the returned plan are the drifted items.
+func DriftHost(ctx context.Context, host *config.Host) *plan.HostPlan {
+	return planHost(ctx, host, DetectFeatureDrift)
+}
+
+func planHost(ctx context.Context, host *config.Host, planFeature func(ctx context.Context, host *config.Host, feature *config.Feature) (*plan.Result, error)) *plan.HostPlan {
+	hostPlan := &plan.HostPlan{Host: host.HostConfig.Host}
+	for _, task := range host.Tasks {
+		result, err := planFeature(taskContext(ctx, task, nil), host, &task.Feature)
+		hostPlan.Tasks = append(hostPlan.Tasks, &plan.TaskPlan{
+			Task:    task.Name,
+			Feature: task.Feature.Name,
+			Result:  result,
+			Error:   err,
+		})
+	}
+	return hostPlan
+}
@@ -1,101 +1,60 @@
 package installovs
 
 import (
+	"context"
 	"fmt"
-	"github.com/masterzen/winrm"
 	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/plan"
+	"github.com/ruicao93/antrea-windows-ci/pkg/schema"
 	"github.com/ruicao93/antrea-windows-ci/pkg/util"
+	"k8s.io/klog"
 	"path"
+	"regexp"
 	"strings"
 )
 
 const (
-	BaseDir                  = `C:/antrea-windows-ci/ovs-install`
-	OVSInstallationFileUrl   = "https://raw.githubusercontent.com/ruicao93/antrea/nsx_ovs_install_disableHV/hack/windows/Install-OVS.ps1"
-	OVSUninstallationFileUrl = "https://raw.githubusercontent.com/ruicao93/antrea/nsx_ovs_install_disableHV/hack/windows/Uninstall-OVS.ps1"
-	GetNSXOVSFileUrl         = "https://raw.githubusercontent.com/ruicao93/antrea/nsx_ovs_get/hack/windows/Get-NSXOVS.ps1"
-	ReconcileOVSFileUrl      = "https://raw.githubusercontent.com/ruicao93/antrea-windows-ci/main/scripts/Reconcile-OVS.ps1"
+	FeatureName = "InstallOVS"
+
+	BaseDir             = `C:/antrea-windows-ci/ovs-install`
+	ReconcileOVSFileUrl = "https://raw.githubusercontent.com/ruicao93/antrea-windows-ci/main/scripts/Reconcile-OVS.ps1"
 
-	OVSDir            = `c:/openvswitch`
 	OVSDriverProvider = `The Linux Foundation (R)`
 )
 
 var (
-	OVSInstallationFilePath   = path.Join(BaseDir, "Install-OVS.ps1")
-	OVSUninstallationFilePath = path.Join(BaseDir, "Uninstall-OVS.ps1")
-	ReconcileOVSFilePath      = path.Join(BaseDir, "Reconcile-OVS.ps1")
-	GetNSXOVSFilePath         = path.Join(BaseDir, "Get-NSXOVS.ps1")
-	NSXOVSFilePath            = path.Join(BaseDir, "nsx-ovs.zip")
+	ReconcileOVSFilePath = path.Join(BaseDir, "Reconcile-OVS.ps1")
 )
 
-func GetOVSVersion(host *config.Host) (string, error) {
-	//return util.CallPSCommand(host.Client,"ovs-vsctl.exe show")
-	return "", nil
-}
-
-func OVSInstalled(host *config.Host) (bool, error) {
-	return util.ServiceExists(host.Client, "ovs-vswitchd")
-}
+var ovsVersionPattern = regexp.MustCompile(`\(Open vSwitch\) (\S+)`)
 
-func VersionCheck(host *config.Host, expectedVersion string) (bool, error) {
-	curVersion, err := GetOVSVersion(host)
+func GetOVSVersion(ctx context.Context, host *config.Host) (string, error) {
+	out, err := util.CallPSCommand(ctx, host.Executor, "ovs-vsctl.exe --version")
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	return strings.Contains(curVersion, expectedVersion), nil
+	if match := ovsVersionPattern.FindStringSubmatch(out); match != nil {
+		return match[1], nil
+	}
+	return strings.TrimSpace(out), nil
 }
 
-func GetNSXOVS(client *winrm.Client) error {
-	cmd := fmt.Sprintf("%s -OutPutFile %s", GetNSXOVSFilePath, NSXOVSFilePath)
-	if err := util.InvokePSCommand(client, cmd); err != nil {
-		return nil
-	}
-	return util.PathExists(client, NSXOVSFilePath)
+func OVSInstalled(ctx context.Context, host *config.Host) (bool, error) {
+	return util.ServiceExists(ctx, host.Executor, "ovs-vswitchd")
 }
 
-func installOVSInternal(host *config.Host, nsxOVS bool) error {
-	client := host.Client
-	// RM dir
-	if err := util.RemoveDir(client, OVSDir); err != nil {
-		return err
-	}
-	drivers, err := getOVSDriverNames(client)
+func VersionCheck(ctx context.Context, host *config.Host, expectedVersion string) (bool, error) {
+	curVersion, err := GetOVSVersion(ctx, host)
 	if err != nil {
-		return err
-	}
-	if len(drivers) > 0 {
-		return fmt.Errorf("found existed OVS drivers: %v", drivers)
-	}
-	// Download script
-	if err := util.CreateDir(client, BaseDir); err != nil {
-		return err
-	}
-	if err := util.DownloadFile(host.SSHClient, OVSInstallationFileUrl, OVSInstallationFilePath, true); err != nil {
-		return err
-	}
-	if nsxOVS {
-		if err := util.DownloadFile(host.SSHClient, GetNSXOVSFileUrl, GetNSXOVSFilePath, true); err != nil {
-			return err
-		}
-		if err := GetNSXOVS(client); err != nil {
-			return err
-		}
-	}
-	cmd := OVSInstallationFilePath
-	if nsxOVS {
-		cmd = fmt.Sprintf("%s -LocalFile %s", cmd, NSXOVSFilePath)
+		return false, err
 	}
-	return util.InvokePSCommand(client, cmd)
-}
-
-func deleteDriver(client *winrm.Client, driverName string) error {
-	cmd := fmt.Sprintf("pnputil.exe /delete-driver %s", driverName)
-	return util.InvokePSCommand(client, cmd)
+	return strings.Contains(curVersion, expectedVersion), nil
 }
 
-func getOVSDriverNames(client *winrm.Client) ([]string, error) {
+func getOVSDriverNames(ctx context.Context, client executor.Executor) ([]string, error) {
 	var drivers []string
-	out, err := util.CallPSCommand(client, "pnputil.exe -e")
+	out, err := util.CallPSCommand(ctx, client, "pnputil.exe -e")
 	if err != nil {
 		return drivers, err
 	}
@@ -109,48 +68,32 @@ func getOVSDriverNames(client *winrm.Client) ([]string, error) {
 	return drivers, err
 }
 
-func uninstallOVSInternal(host *config.Host) error {
-	client := host.Client
-	// Download script
-	if err := util.CreateDir(client, BaseDir); err != nil {
-		return err
-	}
-	if err := util.DownloadFile(host.SSHClient, OVSUninstallationFileUrl, OVSUninstallationFilePath, true); err != nil {
-		return err
-	}
-	// Call Script
-	if err := util.InvokePSCommand(client, OVSUninstallationFilePath); err != nil {
-		return err
-	}
-	if ovsInstalled, err := OVSInstalled(host); err != nil || ovsInstalled {
-		return fmt.Errorf("OVS sill exists after uninstall")
-	}
-	drivers, err := getOVSDriverNames(client)
-	if err != nil {
+// runReconcileOVS downloads Reconcile-OVS.ps1 to $BaseDir and runs it with
+// args.
+func runReconcileOVS(ctx context.Context, host *config.Host, args string) error {
+	if err := downloadReconcileOVS(ctx, host); err != nil {
 		return err
 	}
-	if len(drivers) == 0 {
-		return fmt.Errorf("cannot find driver OVS name")
-	}
-	for _, driver := range drivers {
-		if err := deleteDriver(client, driver); err != nil {
-			return err
-		}
-	}
-	return util.RemoveDir(client, OVSDir)
+	return invokeReconcileOVS(ctx, host, args)
 }
 
-func InstallOVS(host *config.Host, expectedVersion string, nsxOVS bool) error {
-	// 1. Download script to $BaseDir
-	client := host.Client
-	sshClient := host.SSHClient
-	_ = util.RemoveDir(client, BaseDir)
-	if err := util.CreateDir(client, BaseDir); err != nil {
-		return err
-	}
-	if err := util.DownloadFile(sshClient, ReconcileOVSFileUrl, ReconcileOVSFilePath, false); err != nil {
+// downloadReconcileOVS downloads Reconcile-OVS.ps1 to $BaseDir, it does not
+// change OVS.
+func downloadReconcileOVS(ctx context.Context, host *config.Host) error {
+	client := host.Executor
+	_ = util.RemoveDir(ctx, client, BaseDir)
+	if err := util.CreateDir(ctx, client, BaseDir); err != nil {
 		return err
 	}
+	return util.DownloadFile(ctx, host.SSHExecutor, ReconcileOVSFileUrl, ReconcileOVSFilePath, false)
+}
+
+func invokeReconcileOVS(ctx context.Context, host *config.Host, args string) error {
+	cmd := fmt.Sprintf("& '%s' %s", ReconcileOVSFilePath, args)
+	return util.InvokePSCommand(ctx, host.SSHExecutor, cmd)
+}
+
+func installArgs(expectedVersion string, nsxOVS bool) string {
 	args := " -Operation install"
 	if nsxOVS {
 		args += " -OVSType nsx"
@@ -158,116 +101,89 @@ func InstallOVS(host *config.Host, expectedVersion string, nsxOVS bool) error {
 	if expectedVersion != "" {
 		args += fmt.Sprintf(" -ExpectedVersion %s", expectedVersion)
 	}
-	cmd := fmt.Sprintf("powershell.exe '%s %s'", ReconcileOVSFilePath, args)
-	return util.InvokeSSHCommand(sshClient, cmd)
+	return args
 }
 
-func InstallOVSDeprecated(host *config.Host, expectedVersion string, nsxOVS bool) error {
-	client := host.Client
-	ovsInstalled, err := OVSInstalled(host)
-	if err != nil {
-		return err
-	}
-	if ovsInstalled {
-		if expectedVersion == "" {
-			return nil
-		}
-	}
+func InstallOVS(ctx context.Context, host *config.Host, expectedVersion string, nsxOVS bool) error {
+	return runReconcileOVS(ctx, host, installArgs(expectedVersion, nsxOVS))
+}
 
-	versionCheck, err := VersionCheck(host, expectedVersion)
-	if err != nil {
-		return err
-	}
-	if versionCheck {
-		return nil
-	}
+func UninstallOVS(ctx context.Context, host *config.Host) error {
+	return runReconcileOVS(ctx, host, " -Operation uninstall")
+}
 
-	// Remove existing OVS
-	if ovsInstalled {
-		if err := uninstallOVSInternal(host); err != nil {
-			return err
-		}
+// Feature installs OVS with Reconcile-OVS.ps1.
+type Feature struct{}
 
-		// Restart Computer
-		if err := util.RestartComputer(host, true); err != nil {
-			return err
-		}
-	} else {
-		drivers, err := getOVSDriverNames(client)
-		if err != nil {
-			return err
-		}
-		if len(drivers) > 0 {
-			for _, driver := range drivers {
-				if err := deleteDriver(client, driver); err != nil {
-					return err
-				}
-			}
-		}
-	}
+func (f *Feature) Name() string {
+	return FeatureName
+}
 
-	// Install new OVS
-	if err := installOVSInternal(host, nsxOVS); err != nil {
-		return err
-	}
-	// Restart computer
-	if err := util.RestartComputer(host, true); err != nil {
-		return err
+func (f *Feature) Schema() *schema.Schema {
+	return Schema
+}
+
+func (f *Feature) Apply(ctx context.Context, host *config.Host, params schema.Values) (bool, error) {
+	nsxOVS := params.String(KeyOVSType) == ValueOVSTypeNSX
+	if err := InstallOVS(ctx, host, params.String(KeyOVSVersion), nsxOVS); err != nil {
+		return false, fmt.Errorf("failed to install OVS on host %s: %v", host.HostConfig.Host, err)
 	}
-	return nil
+	return false, nil
 }
 
-func PostInstallOVSDeprecated(host *config.Host, expectedVersion string) error {
-	ovsInstalled, err := OVSInstalled(host)
+func (f *Feature) Verify(ctx context.Context, host *config.Host, params schema.Values) error {
+	installed, err := OVSInstalled(ctx, host)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to check OVS service on host %s: %v", host.HostConfig.Host, err)
 	}
-	if !ovsInstalled {
-		return fmt.Errorf("ovs not found")
+	if !installed {
+		return fmt.Errorf("OVS not found on host %s", host.HostConfig.Host)
 	}
-	versionCheck, err := VersionCheck(host, expectedVersion)
+	expectedVersion := params.String(KeyOVSVersion)
+	if expectedVersion == "" {
+		return nil
+	}
+	versionCheck, err := VersionCheck(ctx, host, expectedVersion)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to get OVS version on host %s: %v", host.HostConfig.Host, err)
 	}
 	if !versionCheck {
-		return fmt.Errorf("unexpected OVS version")
+		return fmt.Errorf("unexpected OVS version on host %s, expected %s", host.HostConfig.Host, expectedVersion)
 	}
 	return nil
 }
 
-func PostUnInstallOVSDeprecated(host *config.Host, expectedVersion string) error {
-	ovsInstalled, err := OVSInstalled(host)
-	if err != nil {
-		return err
-	}
-	if ovsInstalled {
-		return fmt.Errorf("ovs found")
+// Rollback restores the OVS which was installed before Apply, so that a failed
+// installation does not leave a half installed OVS on the host. If OVS was not
+// installed before Apply, it is uninstalled. An OVS which Apply failed to
+// replace is left in place if its version is unchanged, and is installed again
+// otherwise.
+func (f *Feature) Rollback(ctx context.Context, host *config.Host, params schema.Values, detected *plan.Result) error {
+	previousVersion, ok := detectedVersion(detected)
+	if !ok {
+		if err := UninstallOVS(ctx, host); err != nil {
+			return fmt.Errorf("failed to uninstall OVS on host %s: %v", host.HostConfig.Host, err)
+		}
+		return nil
 	}
-	drivers, err := getOVSDriverNames(host.Client)
+	installed, err := OVSInstalled(ctx, host)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to check OVS service on host %s: %v", host.HostConfig.Host, err)
 	}
-	if len(drivers) > 0 {
-		return fmt.Errorf("found OVS drivers: %v", drivers)
-	}
-	return nil
-}
-
-func ApplyFeature(host *config.Host, feature *config.Feature) error {
-	expectedVersion := feature.GetValue(KeyOVSVersion)
-	nsxOVS := false
-	ovsType := feature.GetValue(KeyOVSType)
-	if ovsType == ValueOVSTypeNSX {
-		nsxOVS = true
+	if installed {
+		version, err := GetOVSVersion(ctx, host)
+		if err != nil {
+			return fmt.Errorf("failed to get OVS version on host %s: %v", host.HostConfig.Host, err)
+		}
+		if version == previousVersion {
+			klog.Infof("Skip restoring OVS on host %s, OVS %s is still installed", host.HostConfig.Host, version)
+			return nil
+		}
 	}
-	if err := InstallOVS(host, expectedVersion, nsxOVS); err != nil {
-		return fmt.Errorf("failed to install OVS on host %s: %v", host.HostConfig.Host, err)
+	klog.Infof("Restoring OVS %s on host %s", previousVersion, host.HostConfig.Host)
+	nsxOVS := params.String(KeyOVSType) == ValueOVSTypeNSX
+	if err := InstallOVS(ctx, host, previousVersion, nsxOVS); err != nil {
+		return fmt.Errorf("failed to restore OVS %s on host %s: %v", previousVersion, host.HostConfig.Host, err)
 	}
-	//if err := PostInstallOVS(host, expectedVersion); err != nil {
-	//	return fmt.Errorf("failed to check OVS after installation on host %s: %v", host.HostConfig.Host, err)
-	//}
-	//if err := util.RestartComputer(host, true); err != nil {
-	//	return err
-	//}
 	return nil
 }
@@ -0,0 +1,456 @@
+package installovs_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features/installovs"
+	"github.com/ruicao93/antrea-windows-ci/pkg/testing/fakehost"
+	"github.com/ruicao93/antrea-windows-ci/pkg/util"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	ovsDriverName = "oem7.inf"
+	// defaultOVSVersion is the version Reconcile-OVS.ps1 installs if no
+	// version is expected.
+	defaultOVSVersion = "2.14.0"
+)
+
+var reconcileOVSPattern = regexp.QuoteMeta(fmt.Sprintf("& '%s'", installovs.ReconcileOVSFilePath))
+
+// ovsHost is a fake host which simulates Reconcile-OVS.ps1 and ovs-vsctl.exe.
+type ovsHost struct {
+	*fakehost.FakeHost
+
+	mu sync.Mutex
+	// version is the version of the installed OVS, "" if OVS is not
+	// installed.
+	version string
+	// installVersion overrides the version the next install operation of
+	// Reconcile-OVS.ps1 installs, e.g. to install another version than the
+	// expected one.
+	installVersion string
+	// failInstall makes the install operation fail without changing OVS.
+	failInstall bool
+	// installDelay and versionDelay slow down the install operation and
+	// ovs-vsctl.exe --version.
+	installDelay time.Duration
+	versionDelay time.Duration
+}
+
+func newOVSHost(version string) *ovsHost {
+	h := &ovsHost{FakeHost: fakehost.New()}
+	if version != "" {
+		h.install(version)
+	}
+	h.Handle(`^ovs-vsctl\.exe --version$`, func(_ *fakehost.FakeHost, _ []string) (int, string, string) {
+		time.Sleep(h.versionDelay)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.version == "" {
+			return 1, "", "ovs-vsctl.exe : The term 'ovs-vsctl.exe' is not recognized"
+		}
+		return 0, fmt.Sprintf("ovs-vsctl (Open vSwitch) %s\r\nDB Schema 8.2.0\r\n", h.version), ""
+	})
+	h.Handle(`^`+reconcileOVSPattern+`\s+-Operation install(?: -OVSType (\S+))?(?: -ExpectedVersion (\S+))?$`, func(_ *fakehost.FakeHost, match []string) (int, string, string) {
+		time.Sleep(h.installDelay)
+		h.mu.Lock()
+		fail, version := h.failInstall, h.installVersion
+		if !fail {
+			h.installVersion = ""
+		}
+		h.mu.Unlock()
+		if fail {
+			return 1, "", "Failed to install OVS"
+		}
+		if version == "" {
+			version = defaultOVSVersion
+			if match[2] != "" {
+				version = match[2]
+			}
+		}
+		h.uninstall()
+		h.install(version)
+		return 0, "OVS installed\r\n", ""
+	})
+	h.Handle(`^`+reconcileOVSPattern+`\s+-Operation uninstall$`, func(_ *fakehost.FakeHost, _ []string) (int, string, string) {
+		h.uninstall()
+		return 0, "", ""
+	})
+	return h
+}
+
+func (h *ovsHost) install(version string) {
+	h.mu.Lock()
+	h.version = version
+	h.mu.Unlock()
+	h.SetService("ovsdb-server", "Running")
+	h.SetService("ovs-vswitchd", "Running")
+	h.AddDriver(fakehost.Driver{PublishedName: ovsDriverName, Provider: installovs.OVSDriverProvider, Class: "Net"})
+}
+
+// uninstall removes OVS, commands are not running concurrently when it is
+// called, so the fields of the fake host are modified directly.
+func (h *ovsHost) uninstall() {
+	h.mu.Lock()
+	h.version = ""
+	h.mu.Unlock()
+	delete(h.Services, "ovsdb-server")
+	delete(h.Services, "ovs-vswitchd")
+	var drivers []fakehost.Driver
+	for _, driver := range h.Drivers {
+		if driver.Provider != installovs.OVSDriverProvider {
+			drivers = append(drivers, driver)
+		}
+	}
+	h.Drivers = drivers
+}
+
+func (h *ovsHost) installedVersion() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.version
+}
+
+func ovsFeature(version string) *config.Feature {
+	feature := &config.Feature{Name: installovs.FeatureName, KeyValues: map[string]string{}}
+	if version != "" {
+		feature.KeyValues[installovs.KeyOVSVersion] = version
+	}
+	return feature
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// installed is the version of the OVS installed before the feature
+		// is applied.
+		installed      string
+		expected       string
+		installVersion string
+		failDownload   bool
+		failInstall    bool
+		wantUninstall  bool
+		// wantRestore is whether the installed OVS is installed again.
+		wantRestore bool
+		wantVersion string
+	}{
+		{
+			name:         "download fails before the installed OVS is changed",
+			installed:    "2.13.1",
+			expected:     "2.14.0",
+			failDownload: true,
+			wantVersion:  "2.13.1",
+		},
+		{
+			name:           "installed OVS fails verify",
+			expected:       "2.14.0",
+			installVersion: "2.13.1",
+			wantUninstall:  true,
+		},
+		{
+			name:        "replacing the installed OVS fails",
+			installed:   "2.13.1",
+			expected:    "2.14.0",
+			failInstall: true,
+			wantVersion: "2.13.1",
+		},
+		{
+			name:           "replaced OVS fails verify",
+			installed:      "2.13.1",
+			expected:       "2.14.0",
+			installVersion: "2.14.1",
+			wantRestore:    true,
+			wantVersion:    "2.13.1",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newOVSHost(tt.installed)
+			h.failInstall = tt.failInstall
+			h.installVersion = tt.installVersion
+			if tt.failDownload {
+				// Only the first download fails, so a rollback would be able
+				// to uninstall OVS.
+				var downloads int32
+				h.Handle(`^curl\.exe `, func(_ *fakehost.FakeHost, _ []string) (int, string, string) {
+					if atomic.AddInt32(&downloads, 1) > 1 {
+						return 0, "", ""
+					}
+					return 6, "", "curl: (6) Could not resolve host"
+				})
+			}
+			host := h.Host(fmt.Sprintf("rollback-%d", i))
+			if err := features.ApplyFeature(context.Background(), host, ovsFeature(tt.expected)); err == nil {
+				t.Fatalf("ApplyFeature succeeded, want error")
+			}
+			if got := h.Ran(reconcileOVSPattern + `\s+-Operation uninstall`); got != tt.wantUninstall {
+				t.Errorf("uninstall ran: %v, want %v", got, tt.wantUninstall)
+			}
+			if got := h.Ran(reconcileOVSPattern + `\s+-Operation install -ExpectedVersion ` + regexp.QuoteMeta(tt.installed) + `$`); got != tt.wantRestore {
+				t.Errorf("restore ran: %v, want %v", got, tt.wantRestore)
+			}
+			if got := h.installedVersion(); got != tt.wantVersion {
+				t.Errorf("installed version %q, want %q", got, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed string
+		expected  string
+		// stopped is a service which is stopped.
+		stopped string
+		// wantActions maps the drifted items to their actions.
+		wantActions map[string]string
+	}{
+		{
+			name:        "installed without expected version",
+			installed:   "2.14.0",
+			wantActions: map[string]string{},
+		},
+		{
+			name:        "installed with expected version",
+			installed:   "2.14.0",
+			expected:    "2.14.0",
+			wantActions: map[string]string{},
+		},
+		{
+			name:        "installed with another version",
+			installed:   "2.13.1",
+			expected:    "2.14.0",
+			wantActions: map[string]string{"OVS": "reinstall upstream OVS 2.14.0"},
+		},
+		{
+			name:        "service stopped",
+			installed:   "2.14.0",
+			stopped:     "ovs-vswitchd",
+			wantActions: map[string]string{"service ovs-vswitchd": "start"},
+		},
+		{
+			name:        "not installed",
+			wantActions: map[string]string{"OVS": "install upstream OVS"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newOVSHost(tt.installed)
+			if tt.stopped != "" {
+				h.SetService(tt.stopped, "Stopped")
+			}
+			result, err := features.DetectFeatureDrift(context.Background(), h.Host("drift"), ovsFeature(tt.expected))
+			if err != nil {
+				t.Fatalf("DetectFeatureDrift failed: %v", err)
+			}
+			actions := map[string]string{}
+			for _, change := range result.Changes {
+				if change.Action != "" {
+					actions[change.Item] = change.Action
+				}
+			}
+			if !reflect.DeepEqual(actions, tt.wantActions) {
+				t.Errorf("drifted items %v, want %v", actions, tt.wantActions)
+			}
+			if got, want := result.HasChanges(), len(tt.wantActions) > 0; got != want {
+				t.Errorf("HasChanges() = %v, want %v", got, want)
+			}
+			if h.Ran(reconcileOVSPattern) {
+				t.Errorf("DetectFeatureDrift ran Reconcile-OVS.ps1")
+			}
+		})
+	}
+}
+
+func TestApplyHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed string
+		expected  string
+		ovsType   string
+		// afterContainers makes the task depend on a WindowsContainer task,
+		// which requires a restart.
+		afterContainers bool
+		failInstall     bool
+		wantErr         string
+		wantVersion     string
+		wantReboots     int
+		wantRan         []string
+		wantNotRan      []string
+	}{
+		{
+			name:        "install",
+			expected:    "2.14.0",
+			wantVersion: "2.14.0",
+			wantRan:     []string{reconcileOVSPattern + `\s+-Operation install -ExpectedVersion 2\.14\.0$`},
+		},
+		{
+			name:        "install NSX OVS",
+			ovsType:     installovs.ValueOVSTypeNSX,
+			wantVersion: defaultOVSVersion,
+			wantRan:     []string{reconcileOVSPattern + `\s+-Operation install -OVSType nsx$`},
+		},
+		{
+			name:        "already installed",
+			installed:   "2.14.0",
+			expected:    "2.14.0",
+			wantVersion: "2.14.0",
+			wantNotRan:  []string{reconcileOVSPattern},
+		},
+		{
+			name:        "replace another version",
+			installed:   "2.13.1",
+			expected:    "2.14.0",
+			wantVersion: "2.14.0",
+			wantRan:     []string{reconcileOVSPattern + `\s+-Operation install -ExpectedVersion 2\.14\.0$`},
+			wantNotRan:  []string{reconcileOVSPattern + `\s+-Operation uninstall$`},
+		},
+		{
+			name:            "install after the restart of a dependency",
+			expected:        "2.14.0",
+			afterContainers: true,
+			wantVersion:     "2.14.0",
+			wantReboots:     1,
+		},
+		{
+			name:        "install fails",
+			expected:    "2.14.0",
+			failInstall: true,
+			wantErr:     "failed to install OVS on host ovs-1",
+			wantRan:     []string{reconcileOVSPattern + `\s+-Operation uninstall$`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newOVSHost(tt.installed)
+			h.failInstall = tt.failInstall
+			host := h.Host("ovs-1")
+			host.HostConfig.Reboot = config.RebootConfig{
+				PollInterval: time.Millisecond,
+				DownTimeout:  200 * time.Millisecond,
+				UpTimeout:    200 * time.Millisecond,
+			}
+			ovsTask := &config.Task{Name: "ovs", Feature: *ovsFeature(tt.expected)}
+			if tt.ovsType != "" {
+				ovsTask.Feature.KeyValues[installovs.KeyOVSType] = tt.ovsType
+			}
+			if tt.afterContainers {
+				host.Tasks = append(host.Tasks, &config.Task{Name: "containers", Feature: config.Feature{Name: features.InternalFeatureWindowsContainer}})
+				ovsTask.DependsOn = []string{"containers"}
+			}
+			host.Tasks = append(host.Tasks, ovsTask)
+			// Connect the SSH session before the tasks as the preflight does,
+			// so a restart breaks it.
+			if err := util.InvokePSCommand(context.Background(), host.SSHExecutor, "ls"); err != nil {
+				t.Fatalf("Failed to connect the SSH session: %v", err)
+			}
+
+			err := features.ApplyHost(context.Background(), host)
+			result := host.TaskResults[len(host.TaskResults)-1]
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ApplyHost failed: %v", err)
+				}
+				if result.Status != config.TaskSucceeded {
+					t.Errorf("task status %s, want %s", result.Status, config.TaskSucceeded)
+				}
+			} else {
+				if err == nil {
+					t.Fatalf("ApplyHost succeeded, want error %q", tt.wantErr)
+				}
+				if result.Status != config.TaskFailed {
+					t.Errorf("task status %s, want %s", result.Status, config.TaskFailed)
+				}
+				if result.Error == nil || !strings.Contains(result.Error.Error(), tt.wantErr) {
+					t.Errorf("task error %v, want %q", result.Error, tt.wantErr)
+				}
+			}
+			if got := h.installedVersion(); got != tt.wantVersion {
+				t.Errorf("installed version %q, want %q", got, tt.wantVersion)
+			}
+			if got := h.RebootCount(); got != tt.wantReboots {
+				t.Errorf("host restarted %d times, want %d", got, tt.wantReboots)
+			}
+			if tt.afterContainers {
+				// OVS is installed once the host restarted for the
+				// dependency.
+				restart, install := -1, -1
+				for i, cmd := range h.Transcript() {
+					if restart < 0 && strings.HasPrefix(cmd, "Restart-Computer") {
+						restart = i
+					}
+					if install < 0 && regexp.MustCompile(reconcileOVSPattern).MatchString(cmd) {
+						install = i
+					}
+				}
+				if install < 0 || restart < 0 || install < restart {
+					t.Errorf("OVS installed at command %d, want after the restart at command %d", install, restart)
+				}
+			}
+			for _, pattern := range tt.wantRan {
+				if !h.Ran(pattern) {
+					t.Errorf("command %s did not run", pattern)
+				}
+			}
+			for _, pattern := range tt.wantNotRan {
+				if h.Ran(pattern) {
+					t.Errorf("command %s ran", pattern)
+				}
+			}
+		})
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		installDelay time.Duration
+		versionDelay time.Duration
+		wantErr      string
+	}{
+		{
+			name:         "apply and verify within the timeout",
+			installDelay: 20 * time.Millisecond,
+			versionDelay: 20 * time.Millisecond,
+		},
+		{
+			name:         "apply and verify together exceed the timeout",
+			installDelay: 250 * time.Millisecond,
+			versionDelay: 250 * time.Millisecond,
+			wantErr:      "task ovs timed out after 400ms",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newOVSHost("")
+			h.installDelay = tt.installDelay
+			h.versionDelay = tt.versionDelay
+			host := h.Host("ovs-1")
+			host.Tasks = []*config.Task{{Name: "ovs", Feature: *ovsFeature("2.14.0"), Timeout: 400 * time.Millisecond}}
+			err := features.ApplyHost(context.Background(), host)
+			result := host.TaskResults[0]
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ApplyHost failed: %v", err)
+				}
+				return
+			}
+			if result.Status != config.TaskFailed {
+				t.Errorf("task status %s, want %s", result.Status, config.TaskFailed)
+			}
+			if result.Error == nil || !strings.Contains(result.Error.Error(), tt.wantErr) {
+				t.Errorf("task error %v, want %q", result.Error, tt.wantErr)
+			}
+		})
+	}
+}
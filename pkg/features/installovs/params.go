@@ -1,9 +1,29 @@
 package installovs
 
+import "github.com/ruicao93/antrea-windows-ci/pkg/schema"
+
 const (
 	KeyOVSType = "ovsType"
 	ValueOVSTypeNSX = "nsx"
 	ValueOVSTypeUpstrean = "upstream"
 
 	KeyOVSVersion = "ovsVersion"
-)
\ No newline at end of file
+)
+
+var Schema = &schema.Schema{
+	Description: "Install OVS with Reconcile-OVS.ps1, an existing OVS of a different type or version is replaced.",
+	Params: []schema.Param{
+		{
+			Name:        KeyOVSType,
+			Type:        schema.TypeString,
+			Default:     ValueOVSTypeUpstrean,
+			Allowed:     []string{ValueOVSTypeNSX, ValueOVSTypeUpstrean},
+			Description: "Type of the OVS package.",
+		},
+		{
+			Name:        KeyOVSVersion,
+			Type:        schema.TypeVersion,
+			Description: "Expected OVS version, OVS is reinstalled unless the installed version contains it.",
+		},
+	},
+}
@@ -0,0 +1,132 @@
+package installovs
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/plan"
+	"github.com/ruicao93/antrea-windows-ci/pkg/schema"
+	"github.com/ruicao93/antrea-windows-ci/pkg/util"
+	"strings"
+)
+
+// ovsServices must be running once OVS is installed.
+var ovsServices = []string{"ovsdb-server", "ovs-vswitchd"}
+
+const (
+	ovsItem = "OVS"
+	// installedPrefix prefixes the version of an installed OVS in the current
+	// state of the OVS item.
+	installedPrefix = "installed "
+)
+
+// Detect reports the changes Apply would make to the host, it follows the
+// decisions of Reconcile-OVS.ps1 but only reads the OVS state. The type of an
+// installed OVS cannot be detected, so only its version is compared.
+func (f *Feature) Detect(ctx context.Context, host *config.Host, params schema.Values) (*plan.Result, error) {
+	expectedVersion := params.String(KeyOVSVersion)
+	desired := desiredOVS(params)
+	result := &plan.Result{}
+
+	installed, err := OVSInstalled(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check OVS service: %v", err)
+	}
+	if installed {
+		version, err := GetOVSVersion(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get OVS version: %v", err)
+		}
+		change := plan.Change{Item: ovsItem, Current: installedPrefix + version, Desired: desired}
+		if expectedVersion == "" || !strings.Contains(version, expectedVersion) {
+			change.Action = fmt.Sprintf("reinstall %s", desired)
+		}
+		result.Add(change, false)
+		return result, nil
+	}
+
+	result.Add(plan.Change{Item: ovsItem, Current: "not installed", Desired: desired, Action: fmt.Sprintf("install %s", desired)}, false)
+	drivers, err := getOVSDriverNames(ctx, host.Executor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OVS drivers: %v", err)
+	}
+	if len(drivers) > 0 {
+		result.Add(plan.Change{Item: "OVS driver", Current: strings.Join(drivers, ", "), Desired: "reinstalled", Action: "delete"}, false)
+	}
+	return result, nil
+}
+
+// detectedVersion returns the version of the OVS installed when detected was
+// made by Detect, ok is false if OVS was not installed.
+func detectedVersion(detected *plan.Result) (version string, ok bool) {
+	if detected == nil {
+		return "", false
+	}
+	for _, change := range detected.Changes {
+		if change.Item == ovsItem && strings.HasPrefix(change.Current, installedPrefix) {
+			return strings.TrimPrefix(change.Current, installedPrefix), true
+		}
+	}
+	return "", false
+}
+
+// desiredOVS returns the OVS expected by params, e.g. "upstream OVS 2.14.0".
+func desiredOVS(params schema.Values) string {
+	desired := params.String(KeyOVSType) + " OVS"
+	if expectedVersion := params.String(KeyOVSVersion); expectedVersion != "" {
+		desired = fmt.Sprintf("%s %s", desired, expectedVersion)
+	}
+	return desired
+}
+
+// DetectDrift reports the changes of Detect if OVS is not installed. Once OVS
+// is installed, it reports whether its version is the expected one if any,
+// whether the OVS services are running and whether the OVS driver is
+// installed, which Apply does not repair. Unlike Detect, an installed OVS is in
+// sync if no version is expected.
+func (f *Feature) DetectDrift(ctx context.Context, host *config.Host, params schema.Values) (*plan.Result, error) {
+	installed, err := OVSInstalled(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check OVS service: %v", err)
+	}
+	if !installed {
+		return f.Detect(ctx, host, params)
+	}
+	version, err := GetOVSVersion(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OVS version: %v", err)
+	}
+	desired := desiredOVS(params)
+	result := &plan.Result{}
+	ovsChange := plan.Change{Item: ovsItem, Current: installedPrefix + version, Desired: desired}
+	if expectedVersion := params.String(KeyOVSVersion); expectedVersion != "" && !strings.Contains(version, expectedVersion) {
+		ovsChange.Action = fmt.Sprintf("reinstall %s", desired)
+	}
+	result.Add(ovsChange, false)
+	for _, service := range ovsServices {
+		status, err := util.GetServiceStatus(ctx, host.Executor, service)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get status of service %s: %v", service, err)
+		}
+		change := plan.Change{Item: fmt.Sprintf("service %s", service), Current: "running", Desired: "running"}
+		if status != "Running" {
+			change.Current = strings.ToLower(status)
+			if status == "" {
+				change.Current = "not found"
+			}
+			change.Action = "start"
+		}
+		result.Add(change, false)
+	}
+	drivers, err := getOVSDriverNames(ctx, host.Executor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OVS drivers: %v", err)
+	}
+	change := plan.Change{Item: "OVS driver", Current: strings.Join(drivers, ", "), Desired: "installed"}
+	if len(drivers) == 0 {
+		change.Current = "not found"
+		change.Action = "reinstall OVS"
+	}
+	result.Add(change, false)
+	return result, nil
+}
@@ -0,0 +1,382 @@
+package features
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/dag"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"github.com/ruicao93/antrea-windows-ci/pkg/state"
+	"github.com/ruicao93/antrea-windows-ci/pkg/util"
+	"k8s.io/klog"
+	"strings"
+	"time"
+)
+
+// sortTasks orders the tasks of a host by their dependencies, tasks without
+// dependencies between them keep the config order.
+func sortTasks(tasks []*config.Task) ([]*config.Task, error) {
+	graph := dag.New()
+	taskMap := make(map[string]*config.Task, len(tasks))
+	for _, task := range tasks {
+		graph.AddNode(task.Name)
+		taskMap[task.Name] = task
+	}
+	for _, task := range tasks {
+		for _, dependency := range task.DependsOn {
+			if _, ok := taskMap[dependency]; !ok {
+				return nil, fmt.Errorf("task %s depends on task %s which is not assigned to the host", task.Name, dependency)
+			}
+			graph.AddEdge(task.Name, dependency)
+		}
+	}
+	names, err := graph.Sort()
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]*config.Task, 0, len(names))
+	for _, name := range names {
+		sorted = append(sorted, taskMap[name])
+	}
+	return sorted, nil
+}
+
+type taskDone struct {
+	task    *config.Task
+	result  *config.TaskResult
+	run     *featureRun
+	retries *executor.RetryStats
+	output  *executor.OutputTail
+}
+
+// hostScheduler runs the tasks of a host.
+type hostScheduler struct {
+	ctx     context.Context
+	host    *config.Host
+	tasks   []*config.Task
+	results map[string]*config.TaskResult
+	// awaitingReboot are the tasks applied since the last restart which
+	// require one, they are verified after the restart.
+	awaitingReboot []*taskDone
+}
+
+// ApplyHost runs the tasks of a host once their dependencies succeeded, and
+// skips the tasks whose dependencies failed or were skipped. Parallel tasks run
+// together, other tasks run alone.
+//
+// Tasks requiring a restart are verified after the host is restarted, which
+// happens once for all of them when a task depends on one of them, after a
+// task with RebootBarrier, or when no other task can run.
+//
+// Tasks are not started once ctx is done, they are skipped as interrupted.
+//
+// If the host has a State, the result of every task is recorded in it, and a
+// task which succeeded with the same parameters in the resumed run is not
+// applied again unless one of its dependencies is.
+//
+// The results are recorded in host.TaskResults, the returned error summarizes
+// the failed and skipped tasks.
+func ApplyHost(ctx context.Context, host *config.Host) error {
+	klog.Infof("Start tasks for host: %s", host.HostConfig.Host)
+	host.StartTime = time.Now()
+	defer func() {
+		host.EndTime = time.Now()
+	}()
+	if host.HostConfig.DryRun {
+		hostPlan := PlanHost(ctx, host)
+		klog.Infof("Skip tasks for dry run host %s, plan:\n%s", host.HostConfig.Host, hostPlan)
+		if err := hostPlan.Err(); err != nil {
+			return err
+		}
+		klog.Infof("Complete tasks for host: %s", host.HostConfig.Host)
+		return nil
+	}
+	tasks, err := sortTasks(host.Tasks)
+	if err != nil {
+		return fmt.Errorf("failed to order tasks for host %s: %v", host.HostConfig.Host, err)
+	}
+	s := &hostScheduler{ctx: ctx, host: host, tasks: tasks, results: make(map[string]*config.TaskResult, len(tasks))}
+	host.TaskResults = make([]*config.TaskResult, 0, len(host.Tasks))
+	for _, task := range host.Tasks {
+		result := &config.TaskResult{Task: task.Name, Status: config.TaskPending}
+		s.results[task.Name] = result
+		host.TaskResults = append(host.TaskResults, result)
+	}
+	s.run()
+
+	var messages []string
+	for _, result := range host.TaskResults {
+		if result.Status == config.TaskFailed || result.Status == config.TaskSkipped {
+			messages = append(messages, fmt.Sprintf("task %s %s: %v", result.Task, result.Status, result.Error))
+		}
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	klog.Infof("Complete tasks for host: %s", host.HostConfig.Host)
+	return nil
+}
+
+func (s *hostScheduler) run() {
+	done := make(chan *taskDone)
+	running := 0
+	exclusive := false
+	rebootNow := false
+	next := 0
+	for next < len(s.tasks) || running > 0 || len(s.awaitingReboot) > 0 {
+		// Start the tasks in order until one cannot start yet, so that tasks
+		// which run alone are not starved by later parallel tasks.
+		for ; next < len(s.tasks) && !rebootNow; next++ {
+			task := s.tasks[next]
+			if err := s.ctx.Err(); err != nil {
+				result := s.results[task.Name]
+				result.Status = config.TaskSkipped
+				result.Error = fmt.Errorf("skipped because the run was interrupted: %v", err)
+				s.saveState(task)
+				continue
+			}
+			if failed := failedDependency(task, s.results); failed != "" {
+				result := s.results[task.Name]
+				result.Status = config.TaskSkipped
+				result.Error = fmt.Errorf("skipped because %s failed", failed)
+				klog.Infof("Skip task %s for host %s: %v", task.Name, s.host.HostConfig.Host, result.Error)
+				s.saveState(task)
+				continue
+			}
+			if !dependenciesSucceeded(task, s.results) {
+				break
+			}
+			if s.resume(task) {
+				continue
+			}
+			if exclusive || (!task.Parallel && running > 0) {
+				break
+			}
+			running++
+			exclusive = !task.Parallel
+			go func(task *config.Task) {
+				done <- applyTask(s.ctx, s.host, task)
+			}(task)
+		}
+		if running == 0 {
+			// Nothing can run until the pending restart, e.g. the next task
+			// depends on a task awaiting it.
+			if len(s.awaitingReboot) == 0 {
+				break
+			}
+			s.reboot()
+			rebootNow = false
+			continue
+		}
+		taskDone := <-done
+		running--
+		exclusive = false
+		*s.results[taskDone.task.Name] = *taskDone.result
+		s.saveState(taskDone.task)
+		if taskDone.result.Status == config.TaskRebootPending {
+			s.awaitingReboot = append(s.awaitingReboot, taskDone)
+		}
+		if taskDone.task.RebootBarrier && len(s.awaitingReboot) > 0 {
+			klog.Infof("Reboot barrier after task %s for host %s", taskDone.task.Name, s.host.HostConfig.Host)
+			rebootNow = true
+		}
+	}
+}
+
+// reboot restarts the host once for all tasks awaiting a restart, and verifies
+// them after it.
+func (s *hostScheduler) reboot() {
+	var names []string
+	for _, taskDone := range s.awaitingReboot {
+		names = append(names, taskDone.task.Name)
+	}
+	klog.Infof("Restart host %s for tasks: %s", s.host.HostConfig.Host, strings.Join(names, ", "))
+	s.host.Reboots++
+	rebootErr := util.RestartComputer(s.ctx, s.host, true)
+	for _, taskDone := range s.awaitingReboot {
+		result := s.results[taskDone.task.Name]
+		start := time.Now()
+		if rebootErr != nil {
+			result.Status = config.TaskFailed
+			result.Error = fmt.Errorf("failed to restart computer %s: %v", s.host.HostConfig.Host, rebootErr)
+		} else if err := verifyAfterReboot(s.ctx, taskDone, result); err != nil {
+			result.Status = config.TaskFailed
+			result.Error = err
+		} else {
+			result.Status = config.TaskSucceeded
+		}
+		result.Duration += time.Since(start)
+		result.EndTime = time.Now()
+		result.Reboots++
+		taskDone.record(result)
+		s.saveState(taskDone.task)
+	}
+	s.awaitingReboot = nil
+}
+
+// resume marks task as succeeded without applying it if it succeeded with the
+// same parameters in the resumed run and all its dependencies were resumed.
+func (s *hostScheduler) resume(task *config.Task) bool {
+	if s.host.State == nil || !s.host.State.Succeeded(s.host.HostConfig.Host, task.Name, task.Feature.Fingerprint()) {
+		return false
+	}
+	for _, dependency := range task.DependsOn {
+		if !s.results[dependency].Resumed {
+			return false
+		}
+	}
+	result := s.results[task.Name]
+	result.Status = config.TaskSucceeded
+	result.Resumed = true
+	klog.Infof("Skip task %s for host %s, it succeeded with the same parameters in run %s", task.Name, s.host.HostConfig.Host, s.host.State.RunID)
+	return true
+}
+
+// saveState records the result of task in the state of the run, if any. A
+// failure to save the state is logged and does not fail the task.
+func (s *hostScheduler) saveState(task *config.Task) {
+	if s.host.State == nil {
+		return
+	}
+	result := s.results[task.Name]
+	taskState := &state.TaskState{
+		Status:      string(result.Status),
+		Fingerprint: task.Feature.Fingerprint(),
+		StartTime:   optionalTime(result.StartTime),
+		EndTime:     optionalTime(result.EndTime),
+	}
+	if result.Error != nil {
+		taskState.Error = secret.Redact(result.Error.Error())
+	}
+	if err := s.host.State.Record(s.host.HostConfig.Host, task.Name, taskState); err != nil {
+		klog.Errorf("Failed to record task %s for host %s: %v", task.Name, s.host.HostConfig.Host, err)
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// failedDependency returns the name of a failed or skipped dependency of task.
+func failedDependency(task *config.Task, results map[string]*config.TaskResult) string {
+	for _, dependency := range task.DependsOn {
+		status := results[dependency].Status
+		if status == config.TaskFailed || status == config.TaskSkipped {
+			return dependency
+		}
+	}
+	return ""
+}
+
+func dependenciesSucceeded(task *config.Task, results map[string]*config.TaskResult) bool {
+	for _, dependency := range task.DependsOn {
+		if results[dependency].Status != config.TaskSucceeded {
+			return false
+		}
+	}
+	return true
+}
+
+// applyTask applies the feature of a task, and verifies it unless it requires
+// a restart.
+func applyTask(ctx context.Context, host *config.Host, task *config.Task) *taskDone {
+	klog.Infof("Start task %s, feature %s for host: %s", task.Name, task.Feature.Name, host.HostConfig.Host)
+	done := &taskDone{
+		task:    task,
+		result:  &config.TaskResult{Task: task.Name, StartTime: time.Now()},
+		retries: &executor.RetryStats{},
+		output:  executor.NewOutputTail(executor.DefaultOutputTailSize),
+	}
+	ctx = taskContext(ctx, task, done)
+	// Apply and verify share one deadline from the start of the task.
+	deadline := newTaskDeadline(ctx, task, task.Timeout)
+	defer deadline.cancel()
+	defer func() {
+		done.result.EndTime = time.Now()
+		done.result.Duration = done.result.EndTime.Sub(done.result.StartTime)
+		done.record(done.result)
+	}()
+	run, err := newFeatureRun(host, &task.Feature)
+	if err == nil {
+		err = deadline.run(run.apply)
+	}
+	if err != nil {
+		done.result.Status = config.TaskFailed
+		done.result.Error = err
+		return done
+	}
+	done.run = run
+	if run.rebootRequired {
+		klog.Infof("Task %s for host %s is verified after the host is restarted", task.Name, host.HostConfig.Host)
+		done.result.Status = config.TaskRebootPending
+		return done
+	}
+	if err := deadline.run(run.verify); err != nil {
+		done.result.Status = config.TaskFailed
+		done.result.Error = err
+		return done
+	}
+	done.result.Status = config.TaskSucceeded
+	return done
+}
+
+// taskContext returns ctx with the retry policy of the task, which records
+// the retries and output of the commands into done if it is not nil.
+func taskContext(ctx context.Context, task *config.Task, done *taskDone) context.Context {
+	if task.Retry != nil {
+		ctx = executor.WithRetryPolicy(ctx, *task.Retry)
+	}
+	if done != nil {
+		ctx = executor.WithRetryStats(ctx, done.retries)
+		ctx = executor.WithOutputTail(ctx, done.output)
+	}
+	return ctx
+}
+
+// record copies the retries and output recorded so far into result.
+func (done *taskDone) record(result *config.TaskResult) {
+	result.Retries = done.retries.Retries()
+	result.Stdout = done.output.Stdout()
+	result.Stderr = done.output.Stderr()
+}
+
+// verifyAfterReboot verifies a task after the restart it required, within the
+// timeout of the task left by its apply, as the restart is not counted.
+func verifyAfterReboot(ctx context.Context, taskDone *taskDone, result *config.TaskResult) error {
+	deadline := newTaskDeadline(taskContext(ctx, taskDone.task, taskDone), taskDone.task, taskDone.task.Timeout-result.Duration)
+	defer deadline.cancel()
+	return deadline.run(taskDone.run.verify)
+}
+
+// taskDeadline limits the phases of a task by the timeout of the task, the
+// phases share the deadline.
+type taskDeadline struct {
+	task   *config.Task
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newTaskDeadline returns the deadline of task in remaining from now, there is
+// none if the task has no timeout.
+func newTaskDeadline(ctx context.Context, task *config.Task, remaining time.Duration) *taskDeadline {
+	d := &taskDeadline{task: task, parent: ctx, ctx: ctx, cancel: func() {}}
+	if task.Timeout > 0 {
+		d.ctx, d.cancel = context.WithTimeout(ctx, remaining)
+	}
+	return d
+}
+
+// run calls fn with the context limited by the deadline, and tells a task
+// timeout apart from the run being interrupted.
+func (d *taskDeadline) run(fn func(ctx context.Context) error) error {
+	err := fn(d.ctx)
+	if err != nil && d.ctx.Err() == context.DeadlineExceeded && d.parent.Err() == nil {
+		return fmt.Errorf("task %s timed out after %v: %v", d.task.Name, d.task.Timeout, err)
+	}
+	return err
+}
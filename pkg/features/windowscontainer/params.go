@@ -1,6 +1,33 @@
 package windowscontainer
 
+import (
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/schema"
+)
+
 const (
 	ParamSkipCPUCheck = "SkipCPUCheck"
 	ParamDisableHyperV = "DisableHyperV"
-)
\ No newline at end of file
+)
+
+var Schema = &schema.Schema{
+	Description: "Install the Windows feature Containers and install or disable Hyper-V, the host is restarted if required.",
+	Params: []schema.Param{
+		{
+			Name:        ParamSkipCPUCheck,
+			Type:        schema.TypeBool,
+			Description: "Enable Hyper-V with dism without checking the CPU virtualization support, e.g. on nested VMs.",
+		},
+		{
+			Name:        ParamDisableHyperV,
+			Type:        schema.TypeBool,
+			Description: "Remove Hyper-V and disable its optional features instead of installing it.",
+		},
+	},
+	Check: func(values schema.Values) error {
+		if values.Bool(ParamSkipCPUCheck) && values.Bool(ParamDisableHyperV) {
+			return fmt.Errorf("%s and %s cannot be used together", ParamSkipCPUCheck, ParamDisableHyperV)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,147 @@
+package windowscontainer
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/plan"
+	"github.com/ruicao93/antrea-windows-ci/pkg/schema"
+	"strings"
+	"unicode"
+)
+
+// describeState turns a feature state into lower case words, e.g.
+// "InstallPending" into "install pending".
+func describeState(state string) string {
+	state = strings.TrimSpace(state)
+	if state == "" {
+		return "not found"
+	}
+	if state == "Available" {
+		return "not installed"
+	}
+	var words strings.Builder
+	for i, r := range state {
+		if unicode.IsUpper(r) && i > 0 {
+			words.WriteRune(' ')
+		}
+		words.WriteRune(unicode.ToLower(r))
+	}
+	return words.String()
+}
+
+func planWindowsFeature(ctx context.Context, client executor.Executor, featureName string) (plan.Change, bool, error) {
+	state, err := GetWindowsFeatureInstallState(ctx, client, featureName)
+	if err != nil {
+		return plan.Change{}, false, fmt.Errorf("failed to check Windows feature %s installation state: %v", featureName, err)
+	}
+	installed := strings.HasPrefix(state, windowsFeatureStateInstalled)
+	return plan.Change{Item: featureName, Current: describeState(state), Desired: "installed"}, installed, nil
+}
+
+func planOptionalFeature(ctx context.Context, client executor.Executor, featureName string) (plan.Change, bool, error) {
+	state, err := GetWindowsOptionalFeatureState(ctx, client, featureName)
+	if err != nil {
+		return plan.Change{}, false, fmt.Errorf("failed to check WindowsOptionalfeature %s enable state: %v", featureName, err)
+	}
+	enabled := strings.HasPrefix(state, optionalFeatureStateEnabled)
+	return plan.Change{Item: featureName, Current: describeState(state), Desired: "enabled"}, enabled, nil
+}
+
+// Detect reports the changes Apply would make to the host, it only reads the
+// feature states.
+func (f *Feature) Detect(ctx context.Context, host *config.Host, params schema.Values) (*plan.Result, error) {
+	client := host.Executor
+	result := &plan.Result{}
+
+	change, installed, err := planWindowsFeature(ctx, client, windowsFeatureContainers)
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		change.Action = "install"
+	}
+	result.Add(change, true)
+
+	if params.Bool(ParamSkipCPUCheck) {
+		err = planInstallHyperVWithoutCPUCheck(ctx, client, result)
+	} else if params.Bool(ParamDisableHyperV) {
+		err = planDisableHyperV(ctx, client, result)
+	} else {
+		err = planInstallHyperV(ctx, client, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func planInstallHyperV(ctx context.Context, client executor.Executor, result *plan.Result) error {
+	change, installed, err := planWindowsFeature(ctx, client, windowsFeatureHyperV)
+	if err != nil {
+		return err
+	}
+	if installed {
+		result.Add(change, true)
+		return nil
+	}
+	_, enabled, err := planOptionalFeature(ctx, client, optionalFeatureHyperV)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		return fmt.Errorf("WindowsOptionalfeature %s already enabled, Windows feature %s cannot be installed", optionalFeatureHyperV, windowsFeatureHyperV)
+	}
+	change.Action = "install"
+	result.Add(change, true)
+	return nil
+}
+
+func planInstallHyperVWithoutCPUCheck(ctx context.Context, client executor.Executor, result *plan.Result) error {
+	change, installed, err := planWindowsFeature(ctx, client, windowsFeatureHyperV)
+	if err != nil {
+		return err
+	}
+	if installed {
+		result.Add(change, true)
+		return nil
+	}
+	change, enabled, err := planOptionalFeature(ctx, client, optionalFeatureHyperV)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		change.Action = "enable without CPU check"
+	}
+	result.Add(change, true)
+	return nil
+}
+
+func planDisableHyperV(ctx context.Context, client executor.Executor, result *plan.Result) error {
+	change, installed, err := planWindowsFeature(ctx, client, windowsFeatureHyperV)
+	if err != nil {
+		return err
+	}
+	change.Desired = "not installed"
+	if installed {
+		// The optional features are disabled together with the Windows
+		// feature, so DisableHyperV stops here.
+		change.Action = "remove"
+		result.Add(change, true)
+		return nil
+	}
+	result.Add(change, true)
+	for _, featureName := range []string{optionalFeatureHypervisor, optionalFeatureHyperV} {
+		change, enabled, err := planOptionalFeature(ctx, client, featureName)
+		if err != nil {
+			return err
+		}
+		change.Desired = "disabled"
+		if enabled {
+			change.Action = "disable"
+		}
+		result.Add(change, true)
+	}
+	return nil
+}
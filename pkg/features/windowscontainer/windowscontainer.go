@@ -1,15 +1,19 @@
 package windowscontainer
 
 import (
+	"context"
 	"fmt"
-	"github.com/masterzen/winrm"
 	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/schema"
 	"github.com/ruicao93/antrea-windows-ci/pkg/util"
 	"k8s.io/klog"
 	"strings"
 )
 
 const (
+	FeatureName = "WindowsContainer"
+
 	windowsFeatureHyperV      = "Hyper-V"
 	windowsFeatureContainers      = "Containers"
 	optionalFeatureHyperV     = "Microsoft-Hyper-V"
@@ -19,38 +23,38 @@ const (
 	optionalFeatureStateEnabled  = "Enabled"
 )
 
-func GetWindowsOptionalFeatureState(client *winrm.Client, featureName string) (string, error) {
+func GetWindowsOptionalFeatureState(ctx context.Context, client executor.Executor, featureName string) (string, error) {
 	cmd := fmt.Sprintf("$(Get-WindowsOptionalFeature -Online -FeatureName %s -ErrorAction SilentlyContinue).State", featureName)
-	return util.CallPSCommand(client, cmd)
+	return util.CallPSCommand(ctx, client, cmd)
 }
 
-func EnableOptionalFeatureHyperV(client *winrm.Client) (string, error) {
+func EnableOptionalFeatureHyperV(ctx context.Context, client executor.Executor) (string, error) {
 	cmd := fmt.Sprintf("dism /online /enable-feature /featurename:%s /all /NoRestart", optionalFeatureHyperV)
-	return util.CallPSCommand(client, cmd)
+	return util.CallPSCommand(ctx, client, cmd)
 }
 
-func DisableOptionalFeature(client *winrm.Client, featureName string) (string, error) {
+func DisableOptionalFeature(ctx context.Context, client executor.Executor, featureName string) (string, error) {
 	cmd := fmt.Sprintf("dism /online /disable-feature /featurename:%s /NoRestart", featureName)
-	return util.CallPSCommand(client, cmd)
+	return util.CallPSCommand(ctx, client, cmd)
 }
 
-func GetWindowsFeatureInstallState(client *winrm.Client, featureName string) (string, error) {
+func GetWindowsFeatureInstallState(ctx context.Context, client executor.Executor, featureName string) (string, error) {
 	cmd := fmt.Sprintf("$(Get-WindowsFeature -Name %s -ErrorAction SilentlyContinue).InstallState", featureName)
-	return util.CallPSCommand(client, cmd)
+	return util.CallPSCommand(ctx, client, cmd)
 }
 
-func InstallWindowsFeature(client *winrm.Client, featureName string) (string, error) {
+func InstallWindowsFeature(ctx context.Context, client executor.Executor, featureName string) (string, error) {
 	cmd := fmt.Sprintf("Install-WindowsFeature -Name %s", featureName)
-	return util.CallPSCommand(client, cmd)
+	return util.CallPSCommand(ctx, client, cmd)
 }
 
-func RemoveWindowsFeature(client *winrm.Client, featureName string) (string, error) {
+func RemoveWindowsFeature(ctx context.Context, client executor.Executor, featureName string) (string, error) {
 	cmd := fmt.Sprintf("Remove-WindowsFeature -Name %s", featureName)
-	return util.CallPSCommand(client, cmd)
+	return util.CallPSCommand(ctx, client, cmd)
 }
 
-func WindowsFeatureInstalled(client *winrm.Client, featureName string) (bool, error) {
-	state, err := GetWindowsFeatureInstallState(client, featureName)
+func WindowsFeatureInstalled(ctx context.Context, client executor.Executor, featureName string) (bool, error) {
+	state, err := GetWindowsFeatureInstallState(ctx, client, featureName)
 	if err != nil {
 		return false, err
 	}
@@ -61,8 +65,8 @@ func WindowsFeatureInstalled(client *winrm.Client, featureName string) (bool, er
 	}
 }
 
-func WindowsOptionalFeatureEnabled(client *winrm.Client, featureName string) (bool, error) {
-	state, err := GetWindowsOptionalFeatureState(client, featureName)
+func WindowsOptionalFeatureEnabled(ctx context.Context, client executor.Executor, featureName string) (bool, error) {
+	state, err := GetWindowsOptionalFeatureState(ctx, client, featureName)
 	if err != nil {
 		return false, err
 	}
@@ -73,11 +77,11 @@ func WindowsOptionalFeatureEnabled(client *winrm.Client, featureName string) (bo
 	}
 }
 
-func InstallHyperV(host *config.Host) (bool, error) {
+func InstallHyperV(ctx context.Context, host *config.Host) (bool, error) {
 	klog.Infof("Working on install Hyper-V on host: %s", host.HostConfig.Host)
-	client := host.Client
+	client := host.Executor
 	// 1. Check Hyper-V Windows feature installation state
-	installed, err := WindowsFeatureInstalled(client, windowsFeatureHyperV)
+	installed, err := WindowsFeatureInstalled(ctx, client, windowsFeatureHyperV)
 	if err != nil {
 		return false, fmt.Errorf("failed to check Windows feature %s installation state on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host,err)
 	}
@@ -86,7 +90,7 @@ func InstallHyperV(host *config.Host) (bool, error) {
 		return false, nil
 	}
 
-	enabled, err := WindowsOptionalFeatureEnabled(client, optionalFeatureHyperV)
+	enabled, err := WindowsOptionalFeatureEnabled(ctx, client, optionalFeatureHyperV)
 	if err != nil {
 		return false, fmt.Errorf("failed to check WindowsOptionalfeature %s enable state on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host, err)
 	}
@@ -95,7 +99,7 @@ func InstallHyperV(host *config.Host) (bool, error) {
 	}
 
 	// 2. Install Hyper-V
-	_, err = InstallWindowsFeature(client, windowsFeatureHyperV)
+	_, err = InstallWindowsFeature(ctx, client, windowsFeatureHyperV)
 	if err != nil {
 		return false, fmt.Errorf("failed to install Windows feature %s on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host, err)
 	}
@@ -103,18 +107,18 @@ func InstallHyperV(host *config.Host) (bool, error) {
 	return true, nil
 }
 
-func DisableHyperV(host *config.Host) (bool, error) {
+func DisableHyperV(ctx context.Context, host *config.Host) (bool, error) {
 	klog.Info("Working on disable Hyper-V")
-	client := host.Client
+	client := host.Executor
 	// 1. Check Hyper-V Windows feature installation state
-	installed, err := WindowsFeatureInstalled(client, windowsFeatureHyperV)
+	installed, err := WindowsFeatureInstalled(ctx, client, windowsFeatureHyperV)
 	if err != nil {
 		return false, fmt.Errorf("failed to check Windows feature %s installation state on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host,err)
 	}
 	if !installed {
 		klog.Infof("Windows feature %s not installed on host %s", windowsFeatureHyperV, host.HostConfig.Host)
 	} else {
-		_, err = RemoveWindowsFeature(client, windowsFeatureHyperV)
+		_, err = RemoveWindowsFeature(ctx, client, windowsFeatureHyperV)
 		if err != nil {
 			return false, fmt.Errorf("failed to remove Windows feature %s on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host, err)
 		}
@@ -122,28 +126,28 @@ func DisableHyperV(host *config.Host) (bool, error) {
 	}
 
 	requireBoot := false
-	enabled, err := WindowsOptionalFeatureEnabled(client, optionalFeatureHypervisor)
+	enabled, err := WindowsOptionalFeatureEnabled(ctx, client, optionalFeatureHypervisor)
 	if err != nil {
 		return false, fmt.Errorf("failed to check WindowsOptionalfeature %s enable state on host %s: %v", optionalFeatureHypervisor, host.HostConfig.Host, err)
 	}
 	if !enabled {
 		klog.Infof("WindowsOptionalfeature %s not enabled on host %s", optionalFeatureHypervisor, host.HostConfig.Host)
 	} else {
-		_, err = DisableOptionalFeature(client, optionalFeatureHypervisor)
+		_, err = DisableOptionalFeature(ctx, client, optionalFeatureHypervisor)
 		if err != nil {
 			return false, fmt.Errorf("failed to remove Windows feature %s on host %s: %v", optionalFeatureHypervisor, host.HostConfig.Host, err)
 		}
 		requireBoot = true
 	}
 
-	enabled, err = WindowsOptionalFeatureEnabled(client, optionalFeatureHyperV)
+	enabled, err = WindowsOptionalFeatureEnabled(ctx, client, optionalFeatureHyperV)
 	if err != nil {
 		return false, fmt.Errorf("failed to check WindowsOptionalfeature %s enable state on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host, err)
 	}
 	if !enabled {
 		klog.Infof("WindowsOptionalfeature %s not enabled on host %s", windowsFeatureHyperV, host.HostConfig.Host)
 	} else {
-		_, err = DisableOptionalFeature(client, optionalFeatureHyperV)
+		_, err = DisableOptionalFeature(ctx, client, optionalFeatureHyperV)
 		if err != nil {
 			return false, fmt.Errorf("failed to remove Windows feature %s on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host, err)
 		}
@@ -153,20 +157,20 @@ func DisableHyperV(host *config.Host) (bool, error) {
 	return requireBoot, nil
 }
 
-func InstallHyperVWithoutCPUCheck(host *config.Host) (bool, error) {
+func InstallHyperVWithoutCPUCheck(ctx context.Context, host *config.Host) (bool, error) {
 	klog.Infof("Working on install Hyper-V without CPU check on host: %s", host.HostConfig.Host)
-	client := host.Client
+	client := host.Executor
 	// 1. Check Hyper-V Windows feature installation state
-	installed, err := WindowsFeatureInstalled(client, windowsFeatureHyperV)
+	installed, err := WindowsFeatureInstalled(ctx, client, windowsFeatureHyperV)
 	if err != nil {
 		return false, fmt.Errorf("failed to check Windows feature %s installation state on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host, err)
 	}
 	if installed {
-		klog.Infof("Windows feature %s already installed on host", windowsFeatureHyperV, host.HostConfig.Host)
+		klog.Infof("Windows feature %s already installed on host %s", windowsFeatureHyperV, host.HostConfig.Host)
 		return false, nil
 	}
 
-	enabled, err := WindowsOptionalFeatureEnabled(client, optionalFeatureHyperV)
+	enabled, err := WindowsOptionalFeatureEnabled(ctx, client, optionalFeatureHyperV)
 	if err != nil {
 		return false, fmt.Errorf("failed to check WindowsOptionalfeature %s enable state on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host, err)
 	}
@@ -176,7 +180,7 @@ func InstallHyperVWithoutCPUCheck(host *config.Host) (bool, error) {
 	}
 
 	// 2. Install features
-	_, err = EnableOptionalFeatureHyperV(client)
+	_, err = EnableOptionalFeatureHyperV(ctx, client)
 	if err != nil {
 		return false, fmt.Errorf("failed to install Windows feature %s on host %s: %v", optionalFeatureHyperV, host.HostConfig.Host, err)
 	}
@@ -184,9 +188,9 @@ func InstallHyperVWithoutCPUCheck(host *config.Host) (bool, error) {
 	return true, nil
 }
 
-func AssertWindowsFeatureInstalledState(host *config.Host, featureName string, expectedState bool) error {
-	client := host.Client
-	installed, err := WindowsFeatureInstalled(client, featureName)
+func AssertWindowsFeatureInstalledState(ctx context.Context, host *config.Host, featureName string, expectedState bool) error {
+	client := host.Executor
+	installed, err := WindowsFeatureInstalled(ctx, client, featureName)
 	if err != nil {
 		return fmt.Errorf("failed to check Windows feature %s installation state on host %s: %v", featureName, host.HostConfig.Host, err)
 	}
@@ -196,50 +200,50 @@ func AssertWindowsFeatureInstalledState(host *config.Host, featureName string, e
 	return nil
 }
 
-func AssertWindowsOptionalFeatureState(host *config.Host, featureName string, expectedState bool) error {
-	client := host.Client
-	enabled, err := WindowsOptionalFeatureEnabled(client, optionalFeatureHyperV)
+func AssertWindowsOptionalFeatureState(ctx context.Context, host *config.Host, featureName string, expectedState bool) error {
+	client := host.Executor
+	enabled, err := WindowsOptionalFeatureEnabled(ctx, client, featureName)
 	if err != nil {
-		return fmt.Errorf("failed to check WindowsOptionalfeature %s enable state on host %s: %v", windowsFeatureHyperV, host.HostConfig.Host, err)
+		return fmt.Errorf("failed to check WindowsOptionalfeature %s enable state on host %s: %v", featureName, host.HostConfig.Host, err)
 	}
 	if enabled != expectedState {
-		return fmt.Errorf("WindowsOptionalfeature %s state is not as expected on host %s", windowsFeatureHyperV, host.HostConfig.Host)
+		return fmt.Errorf("WindowsOptionalfeature %s state is not as expected on host %s", featureName, host.HostConfig.Host)
 	}
 	return nil
 }
 
-func PostInstallHyperV(host *config.Host) error {
-	return AssertWindowsFeatureInstalledState(host, windowsFeatureHyperV, true)
+func PostInstallHyperV(ctx context.Context, host *config.Host) error {
+	return AssertWindowsFeatureInstalledState(ctx, host, windowsFeatureHyperV, true)
 }
 
-func PostInstallHyperVWithoutCPUCheck(host *config.Host) error {
-	return AssertWindowsOptionalFeatureState(host, windowsFeatureHyperV, true)
+func PostInstallHyperVWithoutCPUCheck(ctx context.Context, host *config.Host) error {
+	return AssertWindowsOptionalFeatureState(ctx, host, optionalFeatureHyperV, true)
 }
 
-func PostInstallContainers(host *config.Host) error {
-	return AssertWindowsFeatureInstalledState(host, windowsFeatureContainers, true)
+func PostInstallContainers(ctx context.Context, host *config.Host) error {
+	return AssertWindowsFeatureInstalledState(ctx, host, windowsFeatureContainers, true)
 }
 
-func PostDisableHyperV(host *config.Host) error {
-	if err := AssertWindowsFeatureInstalledState(host, windowsFeatureHyperV, false); err != nil {
+func PostDisableHyperV(ctx context.Context, host *config.Host) error {
+	if err := AssertWindowsFeatureInstalledState(ctx, host, windowsFeatureHyperV, false); err != nil {
 		return err
 	}
 
-	if err := AssertWindowsOptionalFeatureState(host, optionalFeatureHypervisor, false); err != nil {
+	if err := AssertWindowsOptionalFeatureState(ctx, host, optionalFeatureHypervisor, false); err != nil {
 		return err
 	}
 
-	if err := AssertWindowsOptionalFeatureState(host, optionalFeatureHyperV, false); err != nil {
+	if err := AssertWindowsOptionalFeatureState(ctx, host, optionalFeatureHyperV, false); err != nil {
 		return err
 	}
 	return nil
 }
 
-func InstallContainers(host *config.Host) (bool, error){
+func InstallContainers(ctx context.Context, host *config.Host) (bool, error){
 	klog.Infof("Working on install Containers on host: %s", host.HostConfig.Host)
-	client := host.Client
+	client := host.Executor
 	// 1. Check Windows feature Containers installation state
-	installed, err := WindowsFeatureInstalled(client, windowsFeatureContainers)
+	installed, err := WindowsFeatureInstalled(ctx, client, windowsFeatureContainers)
 	if err != nil {
 		return false, fmt.Errorf("failed to check Windows feature %s installation state on host %s: %v", windowsFeatureContainers, host.HostConfig.Host,err)
 	}
@@ -249,56 +253,52 @@ func InstallContainers(host *config.Host) (bool, error){
 	}
 
 	// 2. Install containers
-	_, err = InstallWindowsFeature(client, windowsFeatureContainers)
+	_, err = InstallWindowsFeature(ctx, client, windowsFeatureContainers)
 	if err != nil {
 		return false, fmt.Errorf("failed to install Windows feature %s on host %s: %v", windowsFeatureContainers, host.HostConfig.Host, err)
 	}
 	return true, nil
 }
 
-func ApplyFeature(host *config.Host, feature *config.Feature) error {
-	requireBoot := false
-	if boot, err := InstallContainers(host); err != nil {
-		return err
-	} else {
-		requireBoot = boot
-	}
+// Feature installs the Windows feature Containers and installs or disables
+// Hyper-V.
+type Feature struct{}
 
-	installHyperVFunc := InstallHyperV
-	postInstallHypervFunc := PostInstallHyperV
-
-	for _, arg := range feature.Args {
-		if arg == ParamSkipCPUCheck {
-			installHyperVFunc = InstallHyperVWithoutCPUCheck
-			postInstallHypervFunc = PostInstallHyperVWithoutCPUCheck
-			break
-		} else if arg == ParamDisableHyperV {
-			installHyperVFunc = DisableHyperV
-			postInstallHypervFunc = PostDisableHyperV
-			break
-		}
-	}
+func (f *Feature) Name() string {
+	return FeatureName
+}
 
-	if boot, err := installHyperVFunc(host); err != nil {
-		return err
-	} else {
-		requireBoot = requireBoot || boot
-	}
+func (f *Feature) Schema() *schema.Schema {
+	return Schema
+}
 
-	if !requireBoot {
-		return nil
+func (f *Feature) Apply(ctx context.Context, host *config.Host, params schema.Values) (bool, error) {
+	requireBoot, err := InstallContainers(ctx, host)
+	if err != nil {
+		return false, err
 	}
 
-
-	if err := util.RestartComputer(host, true); err != nil {
-		return fmt.Errorf("failed to restart computer %s: %v", host.HostConfig.Host, err)
+	installHyperVFunc := InstallHyperV
+	if params.Bool(ParamSkipCPUCheck) {
+		installHyperVFunc = InstallHyperVWithoutCPUCheck
+	} else if params.Bool(ParamDisableHyperV) {
+		installHyperVFunc = DisableHyperV
 	}
+	boot, err := installHyperVFunc(ctx, host)
+	if err != nil {
+		return false, err
+	}
+	return requireBoot || boot, nil
+}
 
-	if err := PostInstallContainers(host); err != nil {
+func (f *Feature) Verify(ctx context.Context, host *config.Host, params schema.Values) error {
+	if err := PostInstallContainers(ctx, host); err != nil {
 		return err
 	}
-	if err := postInstallHypervFunc(host); err != nil {
-		return err
+	if params.Bool(ParamSkipCPUCheck) {
+		return PostInstallHyperVWithoutCPUCheck(ctx, host)
+	} else if params.Bool(ParamDisableHyperV) {
+		return PostDisableHyperV(ctx, host)
 	}
-	return nil
+	return PostInstallHyperV(ctx, host)
 }
@@ -0,0 +1,273 @@
+package windowscontainer_test
+
+import (
+	"context"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features"
+	"github.com/ruicao93/antrea-windows-ci/pkg/features/windowscontainer"
+	"github.com/ruicao93/antrea-windows-ci/pkg/testing/fakehost"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newHost(h *fakehost.FakeHost, args ...string) *config.Host {
+	host := h.Host("win-1")
+	host.HostConfig.Reboot = config.RebootConfig{
+		PollInterval: time.Millisecond,
+		DownTimeout:  200 * time.Millisecond,
+		UpTimeout:    200 * time.Millisecond,
+	}
+	host.Tasks = []*config.Task{{
+		Name:    "containers",
+		Feature: config.Feature{Name: windowscontainer.FeatureName, Args: args},
+	}}
+	return host
+}
+
+func TestApplyHost(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		setup func(h *fakehost.FakeHost)
+		// wantErr is a substring of the task error, "" if the task succeeds.
+		wantErr              string
+		wantReboots          int
+		wantWindowsFeatures  map[string]string
+		wantOptionalFeatures map[string]string
+		wantRan              []string
+		wantNotRan           []string
+	}{
+		{
+			name:        "install",
+			wantReboots: 1,
+			wantWindowsFeatures: map[string]string{
+				"Containers": fakehost.StateInstalled,
+				"Hyper-V":    fakehost.StateInstalled,
+			},
+			wantOptionalFeatures: map[string]string{
+				"Microsoft-Hyper-V":        fakehost.StateEnabled,
+				"Microsoft-Hyper-V-Online": fakehost.StateEnabled,
+			},
+			wantRan: []string{
+				`^Install-WindowsFeature -Name Containers$`,
+				`^Install-WindowsFeature -Name Hyper-V$`,
+			},
+		},
+		{
+			name: "already installed",
+			setup: func(h *fakehost.FakeHost) {
+				h.SetWindowsFeature("Containers", fakehost.StateInstalled)
+				h.SetWindowsFeature("Hyper-V", fakehost.StateInstalled)
+			},
+			wantWindowsFeatures: map[string]string{
+				"Containers": fakehost.StateInstalled,
+				"Hyper-V":    fakehost.StateInstalled,
+			},
+			wantNotRan: []string{`^Install-WindowsFeature `, `^Restart-Computer `},
+		},
+		{
+			name: "disable installed Hyper-V",
+			args: []string{windowscontainer.ParamDisableHyperV},
+			setup: func(h *fakehost.FakeHost) {
+				h.SetWindowsFeature("Containers", fakehost.StateInstalled)
+				h.SetWindowsFeature("Hyper-V", fakehost.StateInstalled)
+			},
+			wantReboots: 1,
+			wantWindowsFeatures: map[string]string{
+				"Containers": fakehost.StateInstalled,
+				"Hyper-V":    fakehost.StateAvailable,
+			},
+			wantOptionalFeatures: map[string]string{
+				"Microsoft-Hyper-V":        fakehost.StateDisabled,
+				"Microsoft-Hyper-V-Online": fakehost.StateDisabled,
+			},
+			wantRan:    []string{`^Remove-WindowsFeature -Name Hyper-V$`},
+			wantNotRan: []string{`^Install-WindowsFeature `},
+		},
+		{
+			name: "disable Hyper-V enabled with dism",
+			args: []string{windowscontainer.ParamDisableHyperV},
+			setup: func(h *fakehost.FakeHost) {
+				h.SetWindowsFeature("Containers", fakehost.StateInstalled)
+				h.SetOptionalFeature("Microsoft-Hyper-V", fakehost.StateEnabled)
+				h.SetOptionalFeature("Microsoft-Hyper-V-Online", fakehost.StateEnabled)
+			},
+			wantReboots: 1,
+			wantOptionalFeatures: map[string]string{
+				"Microsoft-Hyper-V":        fakehost.StateDisabled,
+				"Microsoft-Hyper-V-Online": fakehost.StateDisabled,
+			},
+			wantRan: []string{
+				`^dism /online /disable-feature /featurename:Microsoft-Hyper-V-Online /NoRestart$`,
+				`^dism /online /disable-feature /featurename:Microsoft-Hyper-V /NoRestart$`,
+			},
+			wantNotRan: []string{`^Remove-WindowsFeature `},
+		},
+		{
+			name:        "install Hyper-V without CPU check",
+			args:        []string{windowscontainer.ParamSkipCPUCheck},
+			wantReboots: 1,
+			wantWindowsFeatures: map[string]string{
+				"Containers": fakehost.StateInstalled,
+				"Hyper-V":    fakehost.StateAvailable,
+			},
+			wantOptionalFeatures: map[string]string{
+				"Microsoft-Hyper-V": fakehost.StateEnabled,
+			},
+			wantRan:    []string{`^dism /online /enable-feature /featurename:Microsoft-Hyper-V /all /NoRestart$`},
+			wantNotRan: []string{`^Install-WindowsFeature -Name Hyper-V$`},
+		},
+		{
+			name: "host down for a while after the restart",
+			setup: func(h *fakehost.FakeHost) {
+				h.DownCommands = 5
+			},
+			wantReboots: 1,
+			wantWindowsFeatures: map[string]string{
+				"Containers": fakehost.StateInstalled,
+				"Hyper-V":    fakehost.StateInstalled,
+			},
+		},
+		{
+			name: "host not back after the restart",
+			setup: func(h *fakehost.FakeHost) {
+				h.OnReboot(func() {
+					h.Unreachable = true
+				})
+			},
+			wantErr:     "failed to restart computer win-1",
+			wantReboots: 1,
+		},
+		{
+			name: "install fails",
+			setup: func(h *fakehost.FakeHost) {
+				h.Handle(`^Install-WindowsFeature -Name Hyper-V$`, func(_ *fakehost.FakeHost, _ []string) (int, string, string) {
+					return 1, "", "Install-WindowsFeature : The request to add or remove features failed."
+				})
+			},
+			wantErr:    "failed to install Windows feature Hyper-V on host win-1",
+			wantNotRan: []string{`^Restart-Computer `},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := fakehost.New()
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+			host := newHost(h, tt.args...)
+			err := features.ApplyHost(context.Background(), host)
+			result := host.TaskResults[0]
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ApplyHost failed: %v", err)
+				}
+				if result.Status != config.TaskSucceeded {
+					t.Errorf("task status %s, want %s", result.Status, config.TaskSucceeded)
+				}
+			} else {
+				if err == nil {
+					t.Fatalf("ApplyHost succeeded, want error %q", tt.wantErr)
+				}
+				if result.Status != config.TaskFailed {
+					t.Errorf("task status %s, want %s", result.Status, config.TaskFailed)
+				}
+				if result.Error == nil || !strings.Contains(result.Error.Error(), tt.wantErr) {
+					t.Errorf("task error %v, want %q", result.Error, tt.wantErr)
+				}
+			}
+			if got := h.RebootCount(); got != tt.wantReboots {
+				t.Errorf("host restarted %d times, want %d", got, tt.wantReboots)
+			}
+			if result.Reboots != tt.wantReboots {
+				t.Errorf("task waited for %d restarts, want %d", result.Reboots, tt.wantReboots)
+			}
+			for name, want := range tt.wantWindowsFeatures {
+				if got := h.WindowsFeature(name); got != want {
+					t.Errorf("Windows feature %s is %s, want %s", name, got, want)
+				}
+			}
+			for name, want := range tt.wantOptionalFeatures {
+				if got := h.OptionalFeature(name); got != want {
+					t.Errorf("optional feature %s is %s, want %s", name, got, want)
+				}
+			}
+			for _, pattern := range tt.wantRan {
+				if !h.Ran(pattern) {
+					t.Errorf("command %s did not run", pattern)
+				}
+			}
+			for _, pattern := range tt.wantNotRan {
+				if h.Ran(pattern) {
+					t.Errorf("command %s ran", pattern)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyHostHungAfterRestart(t *testing.T) {
+	tests := []struct {
+		name string
+		// hang is the pattern of the command which hangs after the restart,
+		// output is its output before.
+		hang    string
+		output  func(h *fakehost.FakeHost) string
+		probes  []string
+		wantErr string
+	}{
+		{
+			name: "boot time",
+			hang: `^\(Get-CimInstance -ClassName Win32_OperatingSystem\)\.LastBootUpTime`,
+			output: func(h *fakehost.FakeHost) string {
+				return h.BootTime.Format(time.RFC3339Nano) + "\r\n"
+			},
+			wantErr: "timeout to wait host win-1 up with a new boot time",
+		},
+		{
+			name: "probe",
+			hang: `^\$\(Get-Service "docker" -ErrorAction SilentlyContinue\)\.Status$`,
+			output: func(h *fakehost.FakeHost) string {
+				return "Running\r\n"
+			},
+			probes:  []string{config.ProbeServicePrefix + "docker"},
+			wantErr: "timeout to wait probe service:docker of host win-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			defer close(release)
+			var restarted int32
+			h := fakehost.New()
+			output := tt.output(h)
+			h.Handle(tt.hang, func(_ *fakehost.FakeHost, _ []string) (int, string, string) {
+				if atomic.LoadInt32(&restarted) == 0 {
+					return 0, output, ""
+				}
+				<-release
+				return 1, "", "released"
+			})
+			h.OnReboot(func() {
+				atomic.StoreInt32(&restarted, 1)
+			})
+			host := newHost(h)
+			host.HostConfig.Reboot.Probes = tt.probes
+			done := make(chan error, 1)
+			go func() {
+				done <- features.ApplyHost(context.Background(), host)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("ApplyHost hangs on a command after the restart")
+			}
+			result := host.TaskResults[0]
+			if result.Error == nil || !strings.Contains(result.Error.Error(), tt.wantErr) {
+				t.Errorf("task error %v, want %q", result.Error, tt.wantErr)
+			}
+		})
+	}
+}
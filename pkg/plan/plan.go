@@ -0,0 +1,111 @@
+// Package plan describes the changes features would make to a host, without
+// mutating it.
+package plan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Change is a difference between the current and the desired state of an item
+// on a host, e.g. a Windows feature. Action is empty if the item is already in
+// the desired state.
+type Change struct {
+	Item    string
+	Current string
+	Desired string
+	Action  string
+}
+
+func (c Change) String() string {
+	if c.Action == "" {
+		return fmt.Sprintf("= %s: %s", c.Item, c.Current)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Item, c.Current, c.Action)
+}
+
+// Result is the plan of a feature on a host.
+type Result struct {
+	Changes        []Change
+	RebootRequired bool
+}
+
+// Add appends a change, and marks the result as requiring a reboot if the
+// change is not a no-op and reboot is true.
+func (r *Result) Add(change Change, reboot bool) {
+	r.Changes = append(r.Changes, change)
+	if change.Action != "" && reboot {
+		r.RebootRequired = true
+	}
+}
+
+// HasChanges returns whether the feature would change the host.
+func (r *Result) HasChanges() bool {
+	for _, change := range r.Changes {
+		if change.Action != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// TaskPlan is the plan of a task on a host, Error is set if the plan cannot
+// be made.
+type TaskPlan struct {
+	Task    string
+	Feature string
+	Result  *Result
+	Error   error
+}
+
+// HostPlan is the plan of all tasks on a host.
+type HostPlan struct {
+	Host  string
+	Tasks []*TaskPlan
+}
+
+// Err returns an error if the plan of any task cannot be made.
+func (p *HostPlan) Err() error {
+	var messages []string
+	for _, task := range p.Tasks {
+		if task.Error != nil {
+			messages = append(messages, fmt.Sprintf("task %s: %v", task.Task, task.Error))
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to plan host %s: %s", p.Host, strings.Join(messages, "; "))
+}
+
+// HasChanges returns whether any task would change the host.
+func (p *HostPlan) HasChanges() bool {
+	for _, task := range p.Tasks {
+		if task.Result != nil && task.Result.HasChanges() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *HostPlan) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Host %s:\n", p.Host)
+	if len(p.Tasks) == 0 {
+		out.WriteString("  no tasks\n")
+	}
+	for _, task := range p.Tasks {
+		fmt.Fprintf(&out, "  Task %s (%s):\n", task.Task, task.Feature)
+		if task.Error != nil {
+			fmt.Fprintf(&out, "    ! failed to plan: %v\n", task.Error)
+			continue
+		}
+		for _, change := range task.Result.Changes {
+			fmt.Fprintf(&out, "    %s\n", change)
+		}
+		if task.Result.RebootRequired {
+			out.WriteString("    ! reboot required\n")
+		}
+	}
+	return out.String()
+}
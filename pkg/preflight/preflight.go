@@ -0,0 +1,126 @@
+// Package preflight checks that hosts are reachable over WinRM and SSH with
+// their credentials before any change is made to them.
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"github.com/ruicao93/antrea-windows-ci/pkg/util"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialTimeout limits the TCP connection checks.
+const dialTimeout = 5 * time.Second
+
+const getPSVersionCmd = "$PSVersionTable.PSVersion.ToString()"
+
+// TransportResult is the result of the checks of a transport, Error is the
+// first failed check.
+type TransportResult struct {
+	Reachable     bool
+	Authenticated bool
+	Error         error
+}
+
+func (r *TransportResult) String() string {
+	switch {
+	case !r.Reachable:
+		return "unreachable"
+	case !r.Authenticated:
+		return "auth failed"
+	case r.Error != nil:
+		return "error"
+	default:
+		return "ok"
+	}
+}
+
+type HostResult struct {
+	Host              string
+	WinRM             TransportResult
+	SSH               TransportResult
+	PowerShellVersion string
+}
+
+// OK returns whether all checks of the host passed.
+func (r *HostResult) OK() bool {
+	return r.WinRM.Error == nil && r.SSH.Error == nil
+}
+
+// Err returns the errors of the failed checks, or nil if all checks passed.
+func (r *HostResult) Err() error {
+	var messages []string
+	if r.WinRM.Error != nil {
+		messages = append(messages, fmt.Sprintf("winrm: %v", r.WinRM.Error))
+	}
+	if r.SSH.Error != nil {
+		messages = append(messages, fmt.Sprintf("ssh: %v", r.SSH.Error))
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return fmt.Errorf("host %s failed preflight, %s", r.Host, strings.Join(messages, "; "))
+}
+
+// CheckHost checks the TCP connection and the credentials of WinRM and SSH,
+// and gets the PowerShell version over WinRM. The executors of the host stay
+// connected for the tasks. Commands are not retried, so an unreachable host
+// is reported quickly.
+func CheckHost(ctx context.Context, host *config.Host) *HostResult {
+	ctx = executor.WithRetryPolicy(ctx, executor.NoRetry)
+	result := &HostResult{Host: host.HostConfig.Host}
+	result.WinRM = checkTransport(ctx, host.HostConfig.WinRMAddr(), func() error {
+		version, err := util.CallPSCommand(ctx, host.Executor, getPSVersionCmd)
+		result.PowerShellVersion = strings.TrimSpace(version)
+		return err
+	})
+	if result.WinRM.Error != nil {
+		result.PowerShellVersion = ""
+	}
+	result.SSH = checkTransport(ctx, host.HostConfig.SSHAddr(), func() error {
+		return util.InvokeCommand(ctx, host.SSHExecutor, "hostname")
+	})
+	return result
+}
+
+// Check checks all hosts in parallel, the results are in the order of hosts.
+func Check(ctx context.Context, hosts []*config.Host) []*HostResult {
+	results := make([]*HostResult, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host *config.Host) {
+			defer wg.Done()
+			results[i] = CheckHost(ctx, host)
+		}(i, host)
+	}
+	wg.Wait()
+	return results
+}
+
+// checkTransport dials addr to tell an unreachable host apart from invalid
+// credentials, then runs a command with run.
+func checkTransport(ctx context.Context, addr string, run func() error) TransportResult {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return TransportResult{Error: err}
+	}
+	conn.Close()
+	if err := run(); err != nil {
+		return TransportResult{Reachable: true, Authenticated: !isAuthError(err), Error: err}
+	}
+	return TransportResult{Reachable: true, Authenticated: true}
+}
+
+// isAuthError returns whether err is a rejection of the credentials, WinRM
+// responds with HTTP 401 and SSH fails the handshake.
+func isAuthError(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "401") || strings.Contains(message, "unable to authenticate")
+}
@@ -0,0 +1,109 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"io"
+	"time"
+)
+
+// junitName is the name of the JUnit test suites of a run.
+const junitName = "antrea-windows-ci"
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// WriteJUnit writes the report as JUnit XML, every host is a test suite and
+// every task of a host is a test case. Failed tasks are failures, skipped
+// tasks are skipped, and tasks which did not complete are errors.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suites := junitTestSuites{Name: junitName, Time: junitTime(r.Duration)}
+	for _, hostReport := range r.Hosts {
+		suite := junitTestSuite{Name: hostReport.Host, Time: junitTime(hostReport.Duration)}
+		if hostReport.StartTime != nil {
+			suite.Timestamp = hostReport.StartTime.UTC().Format(time.RFC3339)
+		}
+		for _, taskReport := range hostReport.Tasks {
+			testCase := junitTestCase{
+				Name:      taskReport.Task,
+				ClassName: hostReport.Host,
+				Time:      junitTime(taskReport.Duration),
+				SystemOut: taskReport.Stdout,
+				SystemErr: taskReport.Stderr,
+			}
+			message := &junitMessage{Message: taskReport.Error, Text: taskReport.Error}
+			switch config.TaskStatus(taskReport.Status) {
+			case config.TaskSucceeded:
+			case config.TaskFailed:
+				testCase.Failure = message
+				suite.Failures++
+			case config.TaskSkipped:
+				testCase.Skipped = &junitMessage{Message: taskReport.Error}
+				suite.Skipped++
+			default:
+				if message.Message == "" {
+					message.Message = fmt.Sprintf("task did not complete, status: %s", taskReport.Status)
+				}
+				testCase.Error = message
+				suite.Errors++
+			}
+			suite.Tests++
+			suite.Cases = append(suite.Cases, testCase)
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
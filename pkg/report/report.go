@@ -0,0 +1,198 @@
+// Package report writes the results of a run as JSON or JUnit XML reports for
+// CI systems.
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	HostSucceeded   = "succeeded"
+	HostFailed      = "failed"
+	HostInterrupted = "interrupted"
+	HostSkipped     = "skipped"
+	HostUnreachable = "unreachable"
+)
+
+type Report struct {
+	StartTime time.Time     `json:"startTime"`
+	EndTime   time.Time     `json:"endTime"`
+	Duration  float64       `json:"durationSeconds"`
+	Summary   Summary       `json:"summary"`
+	Hosts     []*HostReport `json:"hosts"`
+}
+
+// Summary counts the hosts by status.
+type Summary struct {
+	Hosts       int `json:"hosts"`
+	Succeeded   int `json:"succeeded"`
+	Failed      int `json:"failed"`
+	Interrupted int `json:"interrupted"`
+	Skipped     int `json:"skipped"`
+	Unreachable int `json:"unreachable"`
+}
+
+type HostReport struct {
+	Host      string        `json:"host"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	StartTime *time.Time    `json:"startTime,omitempty"`
+	EndTime   *time.Time    `json:"endTime,omitempty"`
+	Duration  float64       `json:"durationSeconds"`
+	Reboots   int           `json:"reboots"`
+	Tasks     []*TaskReport `json:"tasks"`
+}
+
+type TaskReport struct {
+	Task      string     `json:"task"`
+	Feature   string     `json:"feature"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	StartTime *time.Time `json:"startTime,omitempty"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+	Duration  float64    `json:"durationSeconds"`
+	Retries   int        `json:"retries"`
+	Reboots   int        `json:"reboots"`
+	Stdout    string     `json:"stdout,omitempty"`
+	Stderr    string     `json:"stderr,omitempty"`
+	// Resumed is set if the task succeeded in the resumed run and was not
+	// applied again.
+	Resumed bool `json:"resumed,omitempty"`
+}
+
+// New builds the report of a run from the hosts after they are applied. The
+// tasks of a host which failed or was skipped before its tasks started are
+// reported with the status and error of the host.
+func New(hosts []*config.Host, startTime, endTime time.Time) *Report {
+	r := &Report{
+		StartTime: startTime,
+		EndTime:   endTime,
+		Duration:  endTime.Sub(startTime).Seconds(),
+		Summary:   Summary{Hosts: len(hosts)},
+	}
+	for _, host := range hosts {
+		hostReport := newHostReport(host)
+		switch hostReport.Status {
+		case HostSucceeded:
+			r.Summary.Succeeded++
+		case HostInterrupted:
+			r.Summary.Interrupted++
+		case HostSkipped:
+			r.Summary.Skipped++
+		case HostUnreachable:
+			r.Summary.Unreachable++
+		default:
+			r.Summary.Failed++
+		}
+		r.Hosts = append(r.Hosts, hostReport)
+	}
+	return r
+}
+
+func newHostReport(host *config.Host) *HostReport {
+	hostReport := &HostReport{
+		Host:      host.HostConfig.Host,
+		Status:    hostStatus(host),
+		Error:     errorString(host.Error),
+		StartTime: timePtr(host.StartTime),
+		EndTime:   timePtr(host.EndTime),
+		Reboots:   host.Reboots,
+		Tasks:     []*TaskReport{},
+	}
+	if !host.StartTime.IsZero() && !host.EndTime.IsZero() {
+		hostReport.Duration = host.EndTime.Sub(host.StartTime).Seconds()
+	}
+	for i, task := range host.Tasks {
+		taskReport := &TaskReport{Task: task.Name, Feature: task.Feature.Name}
+		if i < len(host.TaskResults) {
+			result := host.TaskResults[i]
+			taskReport.Status = string(result.Status)
+			taskReport.Error = errorString(result.Error)
+			taskReport.StartTime = timePtr(result.StartTime)
+			taskReport.EndTime = timePtr(result.EndTime)
+			taskReport.Duration = result.Duration.Seconds()
+			taskReport.Retries = result.Retries
+			taskReport.Reboots = result.Reboots
+			taskReport.Resumed = result.Resumed
+			taskReport.Stdout = secret.Redact(result.Stdout)
+			taskReport.Stderr = secret.Redact(result.Stderr)
+		} else if host.Skipped {
+			taskReport.Status = string(config.TaskSkipped)
+			taskReport.Error = hostReport.Error
+		} else if !host.Success {
+			taskReport.Status = string(config.TaskFailed)
+			taskReport.Error = hostReport.Error
+		} else {
+			taskReport.Status = string(config.TaskSucceeded)
+		}
+		hostReport.Tasks = append(hostReport.Tasks, taskReport)
+	}
+	return hostReport
+}
+
+func hostStatus(host *config.Host) string {
+	switch {
+	case host.Success:
+		return HostSucceeded
+	case host.Interrupted:
+		return HostInterrupted
+	case host.Skipped:
+		return HostSkipped
+	case host.Unreachable:
+		return HostUnreachable
+	default:
+		return HostFailed
+	}
+}
+
+// errorString returns the message of err with the secrets redacted.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return secret.Redact(err.Error())
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteFile writes the report to path, as JUnit XML if path ends with ".xml"
+// and as JSON otherwise.
+func (r *Report) WriteFile(path string) error {
+	var buf bytes.Buffer
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".xml") {
+		err = r.WriteJUnit(&buf)
+	} else {
+		err = r.WriteJSON(&buf)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode report %s: %v", path, err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write report %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package report_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/report"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var startTime = time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return startTime.Add(time.Duration(seconds) * time.Second)
+}
+
+func newTasks(names ...string) []*config.Task {
+	var tasks []*config.Task
+	for _, name := range names {
+		tasks = append(tasks, &config.Task{Name: name, Feature: config.Feature{Name: name + "-feature"}})
+	}
+	return tasks
+}
+
+// newHosts returns a run with a host of every status: a succeeded host with a
+// resumed task, a host whose second task failed and whose last task did not
+// start, an interrupted host, a host skipped by the rollout and an unreachable
+// host.
+func newHosts() []*config.Host {
+	secret.Register("s3cr3t-password")
+	return []*config.Host{
+		{
+			HostConfig: &config.HostConfig{Host: "win-1"},
+			Tasks:      newTasks("ovs", "containers"),
+			Success:    true,
+			StartTime:  at(0),
+			EndTime:    at(90),
+			Reboots:    1,
+			TaskResults: []*config.TaskResult{
+				{Task: "ovs", Status: config.TaskSucceeded, StartTime: at(0), EndTime: at(30), Duration: 30 * time.Second, Retries: 2, Stdout: "installed OVS with password s3cr3t-password"},
+				{Task: "containers", Status: config.TaskSucceeded, StartTime: at(30), EndTime: at(90), Duration: 1500 * time.Millisecond, Reboots: 1, Resumed: true},
+			},
+		},
+		{
+			HostConfig: &config.HostConfig{Host: "win-2"},
+			Tasks:      newTasks("ovs", "containers", "antrea"),
+			Error:      errors.New("task containers failed: exit code 1"),
+			StartTime:  at(0),
+			EndTime:    at(45),
+			TaskResults: []*config.TaskResult{
+				{Task: "ovs", Status: config.TaskSucceeded, StartTime: at(0), EndTime: at(20), Duration: 20 * time.Second},
+				{Task: "containers", Status: config.TaskFailed, Error: errors.New("exit code 1 <stderr>"), StartTime: at(20), EndTime: at(45), Duration: 25 * time.Second, Stderr: "Install-WindowsFeature: access denied"},
+			},
+		},
+		{
+			HostConfig:  &config.HostConfig{Host: "win-3"},
+			Tasks:       newTasks("ovs"),
+			Interrupted: true,
+			Error:       errors.New("context canceled"),
+			StartTime:   at(0),
+			EndTime:     at(10),
+			TaskResults: []*config.TaskResult{
+				{Task: "ovs", Status: config.TaskPending, StartTime: at(0)},
+			},
+		},
+		{
+			HostConfig: &config.HostConfig{Host: "win-4"},
+			Tasks:      newTasks("ovs"),
+			Skipped:    true,
+			Error:      errors.New("rollout aborted after 1 failed hosts"),
+		},
+		{
+			HostConfig:  &config.HostConfig{Host: "win-5"},
+			Tasks:       newTasks("ovs"),
+			Unreachable: true,
+			Error:       errors.New("dial tcp 10.0.0.5:5985: connection refused"),
+		},
+	}
+}
+
+// checkGolden compares got with the golden file testdata/name, the golden file
+// is written instead with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, run the test with -update to update it:\n%s", path, got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	r := report.New(newHosts(), startTime, at(120))
+	wantSummary := report.Summary{Hosts: 5, Succeeded: 1, Failed: 1, Interrupted: 1, Skipped: 1, Unreachable: 1}
+	if r.Summary != wantSummary {
+		t.Errorf("Summary = %+v, want %+v", r.Summary, wantSummary)
+	}
+	if r.Duration != 120 {
+		t.Errorf("Duration = %v, want 120", r.Duration)
+	}
+	tests := []struct {
+		host       string
+		wantStatus string
+		// wantTasks are the statuses of the tasks of the host.
+		wantTasks []string
+	}{
+		{host: "win-1", wantStatus: report.HostSucceeded, wantTasks: []string{"succeeded", "succeeded"}},
+		{host: "win-2", wantStatus: report.HostFailed, wantTasks: []string{"succeeded", "failed", "failed"}},
+		{host: "win-3", wantStatus: report.HostInterrupted, wantTasks: []string{"pending"}},
+		{host: "win-4", wantStatus: report.HostSkipped, wantTasks: []string{"skipped"}},
+		{host: "win-5", wantStatus: report.HostUnreachable, wantTasks: []string{"failed"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			hostReport := r.Hosts[i]
+			if hostReport.Host != tt.host || hostReport.Status != tt.wantStatus {
+				t.Errorf("host %s status %s, want %s status %s", hostReport.Host, hostReport.Status, tt.host, tt.wantStatus)
+			}
+			var statuses []string
+			for _, taskReport := range hostReport.Tasks {
+				statuses = append(statuses, taskReport.Status)
+			}
+			if len(statuses) != len(tt.wantTasks) {
+				t.Fatalf("task statuses %v, want %v", statuses, tt.wantTasks)
+			}
+			for j := range statuses {
+				if statuses[j] != tt.wantTasks[j] {
+					t.Errorf("task statuses %v, want %v", statuses, tt.wantTasks)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := report.New(newHosts(), startTime, at(120))
+	tests := []struct {
+		name   string
+		golden string
+		write  func(r *report.Report, buf *bytes.Buffer) error
+	}{
+		{
+			name:   "JSON",
+			golden: "report.json",
+			write: func(r *report.Report, buf *bytes.Buffer) error {
+				return r.WriteJSON(buf)
+			},
+		},
+		{
+			name:   "JUnit",
+			golden: "report.xml",
+			write: func(r *report.Report, buf *bytes.Buffer) error {
+				return r.WriteJUnit(buf)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.write(r, &buf); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if bytes.Contains(buf.Bytes(), []byte("s3cr3t-password")) {
+				t.Errorf("report contains a secret:\n%s", buf.String())
+			}
+			checkGolden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	r := report.New(newHosts(), startTime, at(120))
+	dir := t.TempDir()
+	tests := []struct {
+		file   string
+		golden string
+	}{
+		{file: "report.json", golden: "report.json"},
+		{file: "report.XML", golden: "report.xml"},
+		{file: "report", golden: "report.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := r.WriteFile(path); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read report: %v", err)
+			}
+			want, err := ioutil.ReadFile(filepath.Join("testdata", tt.golden))
+			if err != nil {
+				t.Fatalf("Failed to read golden file: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s is not written as %s:\n%s", tt.file, tt.golden, got)
+			}
+		})
+	}
+	if err := r.WriteFile(filepath.Join(dir, "missing", "report.json")); err == nil {
+		t.Errorf("WriteFile to a missing directory succeeded")
+	}
+}
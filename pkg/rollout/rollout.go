@@ -0,0 +1,133 @@
+// Package rollout applies hosts in batches with a bounded number of hosts in
+// progress, so a fleet is not restarted all at once.
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"k8s.io/klog"
+	"sync"
+	"time"
+)
+
+// ApplyFunc applies a host and sets host.Success.
+type ApplyFunc func(ctx context.Context, host *config.Host)
+
+type rollout struct {
+	config *config.RolloutConfig
+	hosts  []*config.Host
+	apply  ApplyFunc
+
+	mu     sync.Mutex
+	failed int
+}
+
+// Run applies the hosts in batches of rolloutConfig.BatchSize in config order,
+// with at most rolloutConfig.MaxParallel hosts in progress. Once more hosts
+// failed than the config tolerates, the hosts which are not started yet are
+// marked as skipped instead of applied. Once ctx is done, the batches which are
+// not started yet are marked as interrupted, including during a pause.
+func Run(ctx context.Context, hosts []*config.Host, rolloutConfig *config.RolloutConfig, apply ApplyFunc) {
+	r := &rollout{config: rolloutConfig, hosts: hosts, apply: apply}
+	batches := r.batches()
+	for i, batch := range batches {
+		if i > 0 && rolloutConfig.Pause > 0 && !r.aborted() {
+			klog.Infof("Pause %v before batch %d of %d", rolloutConfig.Pause, i+1, len(batches))
+			select {
+			case <-time.After(rolloutConfig.Pause):
+			case <-ctx.Done():
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			for _, remaining := range batches[i:] {
+				for _, host := range remaining {
+					r.interrupt(host, err)
+				}
+			}
+			return
+		}
+		if len(batches) > 1 {
+			klog.Infof("******** Start batch %d of %d: %d hosts ********", i+1, len(batches), len(batch))
+		}
+		r.runBatch(ctx, batch)
+	}
+}
+
+func (r *rollout) batches() [][]*config.Host {
+	size := r.config.BatchSize
+	if size <= 0 || size > len(r.hosts) {
+		size = len(r.hosts)
+	}
+	var batches [][]*config.Host
+	for start := 0; start < len(r.hosts); start += size {
+		end := start + size
+		if end > len(r.hosts) {
+			end = len(r.hosts)
+		}
+		batches = append(batches, r.hosts[start:end])
+	}
+	return batches
+}
+
+func (r *rollout) runBatch(ctx context.Context, batch []*config.Host) {
+	maxParallel := r.config.MaxParallel
+	if maxParallel <= 0 || maxParallel > len(batch) {
+		maxParallel = len(batch)
+	}
+	slots := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for _, host := range batch {
+		slots <- struct{}{}
+		if r.aborted() {
+			<-slots
+			r.skip(host)
+			continue
+		}
+		wg.Add(1)
+		go func(host *config.Host) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			r.apply(ctx, host)
+			if !host.Success {
+				r.mu.Lock()
+				r.failed++
+				r.mu.Unlock()
+			}
+		}(host)
+	}
+	wg.Wait()
+}
+
+// aborted returns whether more hosts failed than the config tolerates.
+func (r *rollout) aborted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.config.MaxFailures != nil && r.failed > *r.config.MaxFailures {
+		return true
+	}
+	if r.config.MaxFailurePercentage != nil && r.failed*100 > *r.config.MaxFailurePercentage*len(r.hosts) {
+		return true
+	}
+	return false
+}
+
+func (r *rollout) skip(host *config.Host) {
+	r.mu.Lock()
+	failed := r.failed
+	r.mu.Unlock()
+	klog.Infof("Skip host %s, %d of %d hosts failed", host.HostConfig.Host, failed, len(r.hosts))
+	host.Success = false
+	host.Skipped = true
+	host.Error = fmt.Errorf("skipped host %s because the rollout was aborted after %d of %d hosts failed", host.HostConfig.Host, failed, len(r.hosts))
+}
+
+// interrupt marks a host which is not started because the run was interrupted.
+func (r *rollout) interrupt(host *config.Host, err error) {
+	klog.Infof("Skip host %s, the run was interrupted", host.HostConfig.Host)
+	host.Success = false
+	host.Interrupted = true
+	host.Error = fmt.Errorf("host %s was not started because the run was interrupted: %v", host.HostConfig.Host, err)
+}
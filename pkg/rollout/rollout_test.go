@@ -0,0 +1,236 @@
+package rollout_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/rollout"
+	"github.com/ruicao93/antrea-windows-ci/pkg/testing/fakehost"
+	"github.com/ruicao93/antrea-windows-ci/pkg/util"
+	"sync"
+	"testing"
+	"time"
+)
+
+// applyRecorder applies hosts by running a command on their fake host, and
+// records the order and concurrency of the hosts.
+type applyRecorder struct {
+	mu         sync.Mutex
+	inProgress int
+	// maxInProgress is the most hosts in progress at the same time.
+	maxInProgress int
+	completed     int
+	// completedAtStart maps the hosts to the number of hosts completed when
+	// they started.
+	completedAtStart map[string]int
+}
+
+func (r *applyRecorder) apply(ctx context.Context, host *config.Host) {
+	r.mu.Lock()
+	r.completedAtStart[host.HostConfig.Host] = r.completed
+	r.inProgress++
+	if r.inProgress > r.maxInProgress {
+		r.maxInProgress = r.inProgress
+	}
+	r.mu.Unlock()
+	// Keep the host in progress so the other hosts of its batch start.
+	time.Sleep(10 * time.Millisecond)
+	err := util.InvokePSCommand(ctx, host.Executor, "ls")
+	host.Success = err == nil
+	host.Error = err
+	r.mu.Lock()
+	r.inProgress--
+	r.completed++
+	r.mu.Unlock()
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		hosts  int
+		config config.RolloutConfig
+		// failed are the indexes of the hosts which fail.
+		failed []int
+		// cancel cancels the run before it starts.
+		cancel            bool
+		wantMaxInProgress int
+		// wantBatches are the sizes of the batches, the hosts of a batch
+		// start after all hosts of the previous batches completed.
+		wantBatches     []int
+		wantSkipped     []int
+		wantInterrupted []int
+	}{
+		{
+			name:              "single batch",
+			hosts:             4,
+			wantMaxInProgress: 4,
+			wantBatches:       []int{4},
+		},
+		{
+			name:              "batches",
+			hosts:             5,
+			config:            config.RolloutConfig{BatchSize: 2},
+			wantMaxInProgress: 2,
+			wantBatches:       []int{2, 2, 1},
+		},
+		{
+			name:              "batch size larger than the hosts",
+			hosts:             3,
+			config:            config.RolloutConfig{BatchSize: 10},
+			wantMaxInProgress: 3,
+			wantBatches:       []int{3},
+		},
+		{
+			name:              "max parallel",
+			hosts:             5,
+			config:            config.RolloutConfig{MaxParallel: 2},
+			wantMaxInProgress: 2,
+			wantBatches:       []int{5},
+		},
+		{
+			name:              "max parallel within batches",
+			hosts:             6,
+			config:            config.RolloutConfig{BatchSize: 3, MaxParallel: 2},
+			wantMaxInProgress: 2,
+			wantBatches:       []int{3, 3},
+		},
+		{
+			name:              "failures tolerated",
+			hosts:             4,
+			config:            config.RolloutConfig{MaxParallel: 1, MaxFailures: intPtr(1)},
+			failed:            []int{0},
+			wantMaxInProgress: 1,
+			wantBatches:       []int{4},
+		},
+		{
+			name:              "abort on max failures",
+			hosts:             4,
+			config:            config.RolloutConfig{MaxParallel: 1, MaxFailures: intPtr(0)},
+			failed:            []int{1},
+			wantMaxInProgress: 1,
+			wantBatches:       []int{2},
+			wantSkipped:       []int{2, 3},
+		},
+		{
+			name:              "abort skips the next batches",
+			hosts:             4,
+			config:            config.RolloutConfig{BatchSize: 2, MaxFailures: intPtr(0)},
+			failed:            []int{0},
+			wantMaxInProgress: 2,
+			wantBatches:       []int{2},
+			wantSkipped:       []int{2, 3},
+		},
+		{
+			name:              "abort on max failure percentage",
+			hosts:             4,
+			config:            config.RolloutConfig{MaxParallel: 1, MaxFailurePercentage: intPtr(25)},
+			failed:            []int{0, 1},
+			wantMaxInProgress: 1,
+			wantBatches:       []int{2},
+			wantSkipped:       []int{2, 3},
+		},
+		{
+			name:            "interrupted",
+			hosts:           3,
+			config:          config.RolloutConfig{BatchSize: 1},
+			cancel:          true,
+			wantInterrupted: []int{0, 1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hosts []*config.Host
+			for i := 0; i < tt.hosts; i++ {
+				h := fakehost.New()
+				hosts = append(hosts, h.Host(fmt.Sprintf("win-%d", i)))
+			}
+			for _, i := range tt.failed {
+				hosts[i].Executor.(*fakehost.FakeHost).Unreachable = true
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			recorder := &applyRecorder{completedAtStart: map[string]int{}}
+			rollout.Run(ctx, hosts, &tt.config, recorder.apply)
+
+			if recorder.maxInProgress != tt.wantMaxInProgress {
+				t.Errorf("%d hosts in progress at most, want %d", recorder.maxInProgress, tt.wantMaxInProgress)
+			}
+			i, batchStart := 0, 0
+			for _, size := range tt.wantBatches {
+				for end := i + size; i < end; i++ {
+					name := hosts[i].HostConfig.Host
+					completed, ok := recorder.completedAtStart[name]
+					if !ok {
+						t.Errorf("host %s was not applied", name)
+					} else if completed < batchStart {
+						t.Errorf("host %s started after %d hosts completed, want at least %d", name, completed, batchStart)
+					}
+				}
+				batchStart += size
+			}
+			for ; i < len(hosts); i++ {
+				if _, ok := recorder.completedAtStart[hosts[i].HostConfig.Host]; ok {
+					t.Errorf("host %s was applied", hosts[i].HostConfig.Host)
+				}
+			}
+			for i, host := range hosts {
+				if got, want := host.Skipped, contains(tt.wantSkipped, i); got != want {
+					t.Errorf("host %s skipped: %v, want %v", host.HostConfig.Host, got, want)
+				}
+				if got, want := host.Interrupted, contains(tt.wantInterrupted, i); got != want {
+					t.Errorf("host %s interrupted: %v, want %v", host.HostConfig.Host, got, want)
+				}
+				if got, want := host.Success, !contains(tt.failed, i) && !contains(tt.wantSkipped, i) && !contains(tt.wantInterrupted, i); got != want {
+					t.Errorf("host %s succeeded: %v, want %v", host.HostConfig.Host, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPauseInterrupted(t *testing.T) {
+	var hosts []*config.Host
+	for i := 0; i < 2; i++ {
+		hosts = append(hosts, fakehost.New().Host(fmt.Sprintf("win-%d", i)))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rolloutConfig := &config.RolloutConfig{BatchSize: 1, Pause: time.Hour}
+	recorder := &applyRecorder{completedAtStart: map[string]int{}}
+	done := make(chan struct{})
+	go func() {
+		rollout.Run(ctx, hosts, rolloutConfig, func(ctx context.Context, host *config.Host) {
+			recorder.apply(ctx, host)
+			// Cancel the run during the pause after the first batch.
+			cancel()
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Run did not return after the run was interrupted during the pause")
+	}
+	if !hosts[0].Success {
+		t.Errorf("host %s failed: %v", hosts[0].HostConfig.Host, hosts[0].Error)
+	}
+	if !hosts[1].Interrupted {
+		t.Errorf("host %s is not interrupted", hosts[1].HostConfig.Host)
+	}
+}
+
+func contains(indexes []int, i int) bool {
+	for _, index := range indexes {
+		if index == i {
+			return true
+		}
+	}
+	return false
+}
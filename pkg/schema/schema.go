@@ -0,0 +1,251 @@
+// Package schema describes the typed parameters of features and decodes the
+// free-form arguments and key values of a task into them.
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Type string
+
+const (
+	// TypeBool parameters are set by an argument, e.g. "args: [SkipCPUCheck]",
+	// or by a "true"/"false" key value.
+	TypeBool   Type = "bool"
+	TypeString Type = "string"
+	TypeInt    Type = "int"
+	// TypeVersion parameters are dotted numeric versions, e.g. "2.13.1.36081".
+	TypeVersion Type = "version"
+)
+
+var versionPattern = regexp.MustCompile(`^\d+(\.\d+)*$`)
+
+// Param describes a parameter of a feature.
+type Param struct {
+	Name string
+	Type Type
+	// Default is the value used if the parameter is not set, it is parsed
+	// according to Type.
+	Default string
+	// Allowed are the allowed values, any value is allowed if it is empty.
+	Allowed     []string
+	Required    bool
+	Description string
+}
+
+// Schema describes a feature and its parameters.
+type Schema struct {
+	Description string
+	Params      []Param
+	// Check validates the decoded values as a whole, e.g. mutually exclusive
+	// parameters.
+	Check func(values Values) error
+}
+
+// Values are the decoded parameters of a task, keyed by parameter name.
+type Values map[string]interface{}
+
+func (values Values) Bool(name string) bool {
+	value, _ := values[name].(bool)
+	return value
+}
+
+func (values Values) String(name string) string {
+	value, _ := values[name].(string)
+	return value
+}
+
+func (values Values) Int(name string) int {
+	value, _ := values[name].(int)
+	return value
+}
+
+// Error is an invalid argument or key value. Arg is the index of the argument,
+// or -1 if the error is about the key value Key, or about the feature as a
+// whole if Key is empty too.
+type Error struct {
+	Arg     int
+	Key     string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errors are all errors found when decoding the parameters of a task.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (s *Schema) Param(name string) *Param {
+	for i := range s.Params {
+		if s.Params[i].Name == name {
+			return &s.Params[i]
+		}
+	}
+	return nil
+}
+
+func (s *Schema) names(t Type) []string {
+	var names []string
+	for _, param := range s.Params {
+		if t == "" || param.Type == t {
+			names = append(names, param.Name)
+		}
+	}
+	return names
+}
+
+// Decode validates the arguments and key values of a task and decodes them
+// into typed values, unset parameters get their default values. The returned
+// error is an Errors if any argument or key value is invalid.
+func (s *Schema) Decode(args []string, keyValues map[string]string) (Values, error) {
+	values := Values{}
+	var errs Errors
+	for i, arg := range args {
+		param := s.Param(arg)
+		if param == nil || param.Type != TypeBool {
+			errs = append(errs, &Error{Arg: i, Message: fmt.Sprintf("unsupported argument %q, supported arguments: %v", arg, s.names(TypeBool))})
+			continue
+		}
+		values[param.Name] = true
+	}
+	keys := make([]string, 0, len(keyValues))
+	for key := range keyValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		param := s.Param(key)
+		if param == nil {
+			errs = append(errs, &Error{Arg: -1, Key: key, Message: fmt.Sprintf("unsupported key %q, supported keys: %v", key, s.names(""))})
+			continue
+		}
+		if _, ok := values[key]; ok {
+			errs = append(errs, &Error{Arg: -1, Key: key, Message: fmt.Sprintf("parameter %s is set by both an argument and a key value", key)})
+			continue
+		}
+		value, err := param.parse(keyValues[key])
+		if err != nil {
+			errs = append(errs, &Error{Arg: -1, Key: key, Message: err.Error()})
+			continue
+		}
+		values[key] = value
+	}
+	for i := range s.Params {
+		param := &s.Params[i]
+		if _, ok := values[param.Name]; ok {
+			continue
+		}
+		if param.Required {
+			errs = append(errs, &Error{Arg: -1, Message: fmt.Sprintf("parameter %s is required", param.Name)})
+			continue
+		}
+		value, err := param.parse(param.Default)
+		if err != nil {
+			errs = append(errs, &Error{Arg: -1, Message: fmt.Sprintf("invalid default value of parameter %s: %v", param.Name, err)})
+			continue
+		}
+		values[param.Name] = value
+	}
+	if len(errs) == 0 && s.Check != nil {
+		if err := s.Check(values); err != nil {
+			errs = append(errs, &Error{Arg: -1, Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return values, nil
+}
+
+// parse parses a raw value according to the parameter type. An empty raw value
+// is parsed into the zero value of the type.
+func (p *Param) parse(raw string) (interface{}, error) {
+	var value interface{}
+	switch p.Type {
+	case TypeBool:
+		if raw == "" {
+			return false, nil
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool value %q of parameter %s", raw, p.Name)
+		}
+		value = b
+	case TypeInt:
+		if raw == "" {
+			return 0, nil
+		}
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int value %q of parameter %s", raw, p.Name)
+		}
+		value = i
+	case TypeVersion:
+		if raw != "" && !versionPattern.MatchString(raw) {
+			return nil, fmt.Errorf("invalid version %q of parameter %s, expected a dotted numeric version, e.g. 2.14.0", raw, p.Name)
+		}
+		value = raw
+	default:
+		value = raw
+	}
+	if raw != "" && len(p.Allowed) > 0 && !containsString(p.Allowed, raw) {
+		return nil, fmt.Errorf("unsupported value %q of parameter %s, supported values: %v", raw, p.Name, p.Allowed)
+	}
+	return value, nil
+}
+
+// Doc returns the help text of a feature and its parameters.
+func (s *Schema) Doc(name string) string {
+	var doc strings.Builder
+	fmt.Fprintf(&doc, "%s\n", name)
+	if s.Description != "" {
+		fmt.Fprintf(&doc, "    %s\n", s.Description)
+	}
+	if len(s.Params) == 0 {
+		return doc.String()
+	}
+	doc.WriteString("\n    Parameters:\n")
+	for _, param := range s.Params {
+		var attrs []string
+		if param.Type == TypeBool {
+			attrs = append(attrs, "arg")
+		}
+		attrs = append(attrs, string(param.Type))
+		if param.Required {
+			attrs = append(attrs, "required")
+		}
+		if param.Default != "" {
+			attrs = append(attrs, fmt.Sprintf("default: %s", param.Default))
+		}
+		if len(param.Allowed) > 0 {
+			attrs = append(attrs, fmt.Sprintf("one of: %s", strings.Join(param.Allowed, ", ")))
+		}
+		fmt.Fprintf(&doc, "      %s (%s)\n", param.Name, strings.Join(attrs, "; "))
+		if param.Description != "" {
+			fmt.Fprintf(&doc, "          %s\n", param.Description)
+		}
+	}
+	return doc.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+// Package secret resolves the credentials referenced by the config file, and
+// redacts the resolved values from logs and reports.
+package secret
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the secrets in logs and reports.
+const Redacted = "******"
+
+// The prefixes of the secret references, e.g. "env:WINRM_PASSWORD",
+// "file:/run/secrets/winrm" or "vault:winrm-admin".
+const (
+	PrefixEnv   = "env:"
+	PrefixFile  = "file:"
+	PrefixVault = "vault:"
+)
+
+// DefaultVaultPasswordFrom references the password of the vault if the config
+// does not.
+const DefaultVaultPasswordFrom = PrefixEnv + "CI_VAULT_PASSWORD"
+
+var (
+	secretsLock sync.RWMutex
+	secrets     = map[string]bool{}
+	replacer    = strings.NewReplacer()
+)
+
+// Register adds a value which is redacted by Redact.
+func Register(value string) {
+	if value == "" {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	if secrets[value] {
+		return
+	}
+	secrets[value] = true
+	values := make([]string, 0, len(secrets))
+	for value := range secrets {
+		values = append(values, value)
+	}
+	// The replacer tries the values in order, so a secret containing another
+	// one is replaced as a whole.
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	oldnew := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		oldnew = append(oldnew, value, Redacted)
+	}
+	replacer = strings.NewReplacer(oldnew...)
+}
+
+// Redact replaces the registered secrets in s.
+func Redact(s string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	return replacer.Replace(s)
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter returns a writer which redacts the registered secrets from
+// every write to w, a secret split across writes is not redacted.
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return &redactingWriter{w: w}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// IsReference returns whether ref has the prefix of a supported reference.
+func IsReference(ref string) bool {
+	for _, prefix := range []string{PrefixEnv, PrefixFile, PrefixVault} {
+		if strings.HasPrefix(ref, prefix) && len(ref) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolver resolves secret references, the vault is decrypted by the first
+// vault reference.
+type Resolver struct {
+	// VaultFile is the vault of the vault references.
+	VaultFile string
+	// VaultPasswordFrom references the password of the vault,
+	// DefaultVaultPasswordFrom by default. It must not be a vault reference.
+	VaultPasswordFrom string
+
+	vault map[string]string
+}
+
+// Resolve returns the secret referenced by ref, and registers it to be
+// redacted.
+func (r *Resolver) Resolve(ref string) (string, error) {
+	value, err := r.resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		return "", fmt.Errorf("secret %s is empty", ref)
+	}
+	Register(value)
+	return value, nil
+}
+
+func (r *Resolver) resolve(ref string) (string, error) {
+	switch {
+	case !IsReference(ref):
+		return "", fmt.Errorf("unsupported secret reference %q, it must start with %s, %s or %s", ref, PrefixEnv, PrefixFile, PrefixVault)
+	case strings.HasPrefix(ref, PrefixEnv):
+		name := strings.TrimPrefix(ref, PrefixEnv)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, PrefixFile):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(ref, PrefixFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		if err := r.openVault(); err != nil {
+			return "", err
+		}
+		name := strings.TrimPrefix(ref, PrefixVault)
+		value, ok := r.vault[name]
+		if !ok {
+			return "", fmt.Errorf("secret %s is not in vault %s", name, r.VaultFile)
+		}
+		return value, nil
+	}
+}
+
+func (r *Resolver) openVault() error {
+	if r.vault != nil {
+		return nil
+	}
+	if r.VaultFile == "" {
+		return fmt.Errorf("no vault file is configured")
+	}
+	passwordFrom := r.VaultPasswordFrom
+	if passwordFrom == "" {
+		passwordFrom = DefaultVaultPasswordFrom
+	}
+	if strings.HasPrefix(passwordFrom, PrefixVault) {
+		return fmt.Errorf("the vault password cannot be stored in the vault")
+	}
+	password, err := r.Resolve(passwordFrom)
+	if err != nil {
+		return fmt.Errorf("failed to get vault password: %v", err)
+	}
+	vault, err := LoadVault(r.VaultFile, password)
+	if err != nil {
+		return err
+	}
+	for _, value := range vault {
+		Register(value)
+	}
+	r.vault = vault
+	return nil
+}
@@ -0,0 +1,208 @@
+package secret_test
+
+import (
+	"bytes"
+	"github.com/ruicao93/antrea-windows-ci/pkg/secret"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeVault(t *testing.T, plaintext, password string) string {
+	data, err := secret.EncryptVault([]byte(plaintext), password)
+	if err != nil {
+		t.Fatalf("Failed to encrypt vault: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "vault")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write vault: %v", err)
+	}
+	return path
+}
+
+func TestVaultRoundTrip(t *testing.T) {
+	path := writeVault(t, "winrm-admin: p@ss:word\nssh-key: \"line1\\nline2\"\n", "vault-password")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read vault: %v", err)
+	}
+	if bytes.Contains(data, []byte("p@ss:word")) {
+		t.Errorf("vault file contains a secret in plain text")
+	}
+	vault, err := secret.LoadVault(path, "vault-password")
+	if err != nil {
+		t.Fatalf("LoadVault failed: %v", err)
+	}
+	want := map[string]string{"winrm-admin": "p@ss:word", "ssh-key": "line1\nline2"}
+	if !reflect.DeepEqual(vault, want) {
+		t.Errorf("LoadVault() = %v, want %v", vault, want)
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	path := writeVault(t, "winrm-admin: password\n", "vault-password")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read vault: %v", err)
+	}
+	tampered := append([]byte{}, data...)
+	// Flip a character of the ciphertext after the header.
+	i := len(tampered) - 4
+	if tampered[i] == 'A' {
+		tampered[i] = 'B'
+	} else {
+		tampered[i] = 'A'
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		wantErr  string
+	}{
+		{
+			name:     "wrong password",
+			data:     data,
+			password: "wrong-password",
+			wantErr:  "the password is wrong or the file is corrupted",
+		},
+		{
+			name:     "tampered ciphertext",
+			data:     tampered,
+			password: "vault-password",
+			wantErr:  "the password is wrong or the file is corrupted",
+		},
+		{
+			name:     "not a vault",
+			data:     []byte("winrm-admin: password\n"),
+			password: "vault-password",
+			wantErr:  "not a vault file",
+		},
+		{
+			name:     "truncated",
+			data:     []byte("$ANTREA_WINDOWS_CI_VAULT;1\nAAAA\n"),
+			password: "vault-password",
+			wantErr:  "corrupted vault file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := secret.DecryptVault(tt.data, tt.password)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("DecryptVault returned error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncryptVaultInvalid(t *testing.T) {
+	if _, err := secret.EncryptVault([]byte("winrm-admin: password\n"), ""); err == nil {
+		t.Errorf("EncryptVault succeeded with an empty password")
+	}
+	if _, err := secret.EncryptVault([]byte("- not a map\n"), "vault-password"); err == nil {
+		t.Errorf("EncryptVault succeeded with a list of secrets")
+	}
+}
+
+func TestResolver(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("file-secret-value\r\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	vaultFile := writeVault(t, "winrm-admin: vault-secret-value\n", "resolver-vault-password")
+	os.Setenv("SECRET_TEST_VALUE", "env-secret-value")
+	os.Setenv("SECRET_TEST_VAULT_PASSWORD", "resolver-vault-password")
+	defer os.Unsetenv("SECRET_TEST_VALUE")
+	defer os.Unsetenv("SECRET_TEST_VAULT_PASSWORD")
+	tests := []struct {
+		name string
+		ref  string
+		// passwordFrom is VaultPasswordFrom of the resolver.
+		passwordFrom string
+		want         string
+		wantErr      string
+	}{
+		{name: "env", ref: "env:SECRET_TEST_VALUE", want: "env-secret-value"},
+		{name: "env not set", ref: "env:SECRET_TEST_UNSET", wantErr: "environment variable SECRET_TEST_UNSET is not set"},
+		{name: "file", ref: "file:" + secretFile, want: "file-secret-value"},
+		{name: "file not found", ref: "file:" + filepath.Join(dir, "missing"), wantErr: "failed to read secret file"},
+		{name: "vault", ref: "vault:winrm-admin", passwordFrom: "env:SECRET_TEST_VAULT_PASSWORD", want: "vault-secret-value"},
+		{name: "not in vault", ref: "vault:ssh-key", passwordFrom: "env:SECRET_TEST_VAULT_PASSWORD", wantErr: "secret ssh-key is not in vault"},
+		{name: "vault password in vault", ref: "vault:winrm-admin", passwordFrom: "vault:password", wantErr: "the vault password cannot be stored in the vault"},
+		{name: "unsupported", ref: "password", wantErr: "unsupported secret reference"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &secret.Resolver{VaultFile: vaultFile, VaultPasswordFrom: tt.passwordFrom}
+			value, err := r.Resolve(tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Resolve(%q) returned error %v, want %q", tt.ref, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%q) failed: %v", tt.ref, err)
+			}
+			if value != tt.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.ref, value, tt.want)
+			}
+			if redacted := secret.Redact("value: " + value); redacted != "value: "+secret.Redacted {
+				t.Errorf("resolved secret is not redacted: %q", redacted)
+			}
+		})
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	secret.Register("writer-secret")
+	secret.Register("writer-secret-longer")
+	secret.Register("")
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name:   "no secret",
+			writes: []string{"hostname\n"},
+			want:   "hostname\n",
+		},
+		{
+			name:   "secret",
+			writes: []string{"password: writer-secret\n"},
+			want:   "password: " + secret.Redacted + "\n",
+		},
+		{
+			name:   "secret containing another one",
+			writes: []string{"password: writer-secret-longer\n"},
+			want:   "password: " + secret.Redacted + "\n",
+		},
+		{
+			name:   "secrets in several writes",
+			writes: []string{"user: writer-secret\n", "password: writer-secret\n"},
+			want:   "user: " + secret.Redacted + "\npassword: " + secret.Redacted + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := secret.NewRedactingWriter(&out)
+			for _, s := range tt.writes {
+				n, err := w.Write([]byte(s))
+				if err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+				if n != len(s) {
+					t.Errorf("Write returned %d, want %d", n, len(s))
+				}
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
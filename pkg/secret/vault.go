@@ -0,0 +1,113 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+)
+
+// A vault file is the vaultHeader followed by the base64 encoded salt, nonce
+// and ciphertext. The plaintext is a YAML map of secret names to values,
+// encrypted with AES-256-GCM by a key derived from the password with scrypt.
+const vaultHeader = "$ANTREA_WINDOWS_CI_VAULT;1\n"
+
+const (
+	vaultSaltSize = 16
+	vaultKeySize  = 32
+	// The scrypt parameters recommended for interactive logins.
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+func vaultCipher(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, vaultKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptVault encrypts the YAML map of secrets in plaintext with password.
+func EncryptVault(plaintext []byte, password string) ([]byte, error) {
+	if password == "" {
+		return nil, fmt.Errorf("vault password is empty")
+	}
+	if _, err := parseVault(plaintext); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, vaultSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := vaultCipher(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	data := append(salt, nonce...)
+	data = aead.Seal(data, nonce, plaintext, []byte(vaultHeader))
+	return []byte(vaultHeader + base64.StdEncoding.EncodeToString(data) + "\n"), nil
+}
+
+// DecryptVault returns the YAML map of secrets encrypted in data.
+func DecryptVault(data []byte, password string) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(vaultHeader)) {
+		return nil, fmt.Errorf("not a vault file")
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data[len(vaultHeader):])))
+	if err != nil {
+		return nil, fmt.Errorf("corrupted vault file: %v", err)
+	}
+	if len(raw) < vaultSaltSize {
+		return nil, fmt.Errorf("corrupted vault file")
+	}
+	aead, err := vaultCipher(password, raw[:vaultSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[vaultSaltSize:]
+	if len(raw) < aead.NonceSize() {
+		return nil, fmt.Errorf("corrupted vault file")
+	}
+	plaintext, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(vaultHeader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault, the password is wrong or the file is corrupted")
+	}
+	return plaintext, nil
+}
+
+// LoadVault decrypts the vault file at path and returns its secrets.
+func LoadVault(path, password string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault: %v", err)
+	}
+	plaintext, err := DecryptVault(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault %s: %v", path, err)
+	}
+	return parseVault(plaintext)
+}
+
+func parseVault(plaintext []byte) (map[string]string, error) {
+	vault := map[string]string{}
+	if err := yaml.UnmarshalStrict(plaintext, &vault); err != nil {
+		return nil, fmt.Errorf("vault must be a map of secret names to values: %v", err)
+	}
+	return vault, nil
+}
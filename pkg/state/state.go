@@ -0,0 +1,173 @@
+// Package state records the results of the tasks of a run in a local file, so
+// that a later run can resume it and skip the tasks which already succeeded.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusSucceeded is the status of a task which succeeded, the statuses are
+// those of the task results.
+const StatusSucceeded = "succeeded"
+
+const stateFileExt = ".json"
+
+// State is the state of a run, saved in the file of its run ID after every
+// change.
+type State struct {
+	RunID      string                `json:"runID"`
+	StartTime  time.Time             `json:"startTime"`
+	UpdateTime time.Time             `json:"updateTime"`
+	Hosts      map[string]*HostState `json:"hosts"`
+
+	path string
+	lock sync.Mutex
+}
+
+// HostState is the state of the tasks of a host by task name.
+type HostState struct {
+	Tasks map[string]*TaskState `json:"tasks"`
+}
+
+// TaskState is the last result of a task on a host.
+type TaskState struct {
+	Status string `json:"status"`
+	// Fingerprint identifies the parameters the task was applied with, see
+	// config.Feature.Fingerprint.
+	Fingerprint string     `json:"fingerprint"`
+	StartTime   *time.Time `json:"startTime,omitempty"`
+	EndTime     *time.Time `json:"endTime,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// NewRunID returns the ID of a new run, the time it started.
+func NewRunID() string {
+	return time.Now().Format("20060102-150405")
+}
+
+// New creates the state of a new run in dir, it fails if the run already
+// exists.
+func New(dir, runID string) (*State, error) {
+	s := &State{
+		RunID:     runID,
+		StartTime: time.Now(),
+		Hosts:     map[string]*HostState{},
+		path:      statePath(dir, runID),
+	}
+	if _, err := os.Stat(s.path); err == nil {
+		return nil, fmt.Errorf("run %s already exists in %s", runID, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %v", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load loads the state of the run in dir, the latest run if runID is empty.
+func Load(dir, runID string) (*State, error) {
+	if runID == "" {
+		var err error
+		if runID, err = latestRunID(dir); err != nil {
+			return nil, err
+		}
+	}
+	path := statePath(dir, runID)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state of run %s: %v", runID, err)
+	}
+	s := &State{path: path}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	if s.Hosts == nil {
+		s.Hosts = map[string]*HostState{}
+	}
+	return s, nil
+}
+
+// latestRunID returns the run of dir whose state was saved last.
+func latestRunID(dir string) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to list runs: %v", err)
+	}
+	var latest os.FileInfo
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != stateFileExt {
+			continue
+		}
+		if latest == nil || file.ModTime().After(latest.ModTime()) {
+			latest = file
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("no run to resume in %s", dir)
+	}
+	return strings.TrimSuffix(latest.Name(), stateFileExt), nil
+}
+
+func statePath(dir, runID string) string {
+	return filepath.Join(dir, runID+stateFileExt)
+}
+
+// Path returns the file of the state.
+func (s *State) Path() string {
+	return s.path
+}
+
+// Succeeded returns whether the task succeeded on host with the parameters
+// identified by fingerprint.
+func (s *State) Succeeded(host, task, fingerprint string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	hostState, ok := s.Hosts[host]
+	if !ok {
+		return false
+	}
+	taskState, ok := hostState.Tasks[task]
+	return ok && taskState.Status == StatusSucceeded && taskState.Fingerprint == fingerprint
+}
+
+// Record sets the state of the task on host and saves the state.
+func (s *State) Record(host, task string, taskState *TaskState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	hostState, ok := s.Hosts[host]
+	if !ok {
+		hostState = &HostState{Tasks: map[string]*TaskState{}}
+		s.Hosts[host] = hostState
+	}
+	hostState.Tasks[task] = taskState
+	return s.save()
+}
+
+// save writes the state to a temporary file renamed over the state file, so
+// the file is complete if the run is killed.
+func (s *State) save() error {
+	s.UpdateTime = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	return nil
+}
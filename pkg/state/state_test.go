@@ -0,0 +1,160 @@
+package state_test
+
+import (
+	"github.com/ruicao93/antrea-windows-ci/pkg/state"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAndLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	s, err := state.New(dir, "run-1")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if want := filepath.Join(dir, "run-1.json"); s.Path() != want {
+		t.Errorf("Path() = %s, want %s", s.Path(), want)
+	}
+	if _, err := os.Stat(s.Path()); err != nil {
+		t.Errorf("state file is not saved: %v", err)
+	}
+	endTime := time.Now()
+	if err := s.Record("win-1", "ovs", &state.TaskState{Status: state.StatusSucceeded, Fingerprint: "sha256:1", EndTime: &endTime}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if err := s.Record("win-1", "containers", &state.TaskState{Status: "failed", Fingerprint: "sha256:2", Error: "install failed"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if _, err := state.New(dir, "run-1"); err == nil || !strings.Contains(err.Error(), "run run-1 already exists") {
+		t.Errorf("New of an existing run returned error %v", err)
+	}
+
+	loaded, err := state.Load(dir, "run-1")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.RunID != "run-1" || loaded.Path() != s.Path() {
+		t.Errorf("loaded run %s at %s, want run-1 at %s", loaded.RunID, loaded.Path(), s.Path())
+	}
+	if got := loaded.Hosts["win-1"].Tasks["containers"].Error; got != "install failed" {
+		t.Errorf("loaded task error %q, want %q", got, "install failed")
+	}
+	if !loaded.Succeeded("win-1", "ovs", "sha256:1") {
+		t.Errorf("loaded state does not record the succeeded task")
+	}
+	// The loaded state is saved to the same file.
+	if err := loaded.Record("win-2", "ovs", &state.TaskState{Status: state.StatusSucceeded, Fingerprint: "sha256:1"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	reloaded, err := state.Load(dir, "run-1")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !reloaded.Succeeded("win-1", "ovs", "sha256:1") || !reloaded.Succeeded("win-2", "ovs", "sha256:1") {
+		t.Errorf("reloaded state lost tasks: %+v", reloaded.Hosts)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "corrupted.json"), []byte("{"), 0644); err != nil {
+		t.Fatalf("Failed to write state file: %v", err)
+	}
+	tests := []struct {
+		name    string
+		dir     string
+		runID   string
+		wantErr string
+	}{
+		{name: "missing run", dir: dir, runID: "missing", wantErr: "failed to load state of run missing"},
+		{name: "corrupted file", dir: dir, runID: "corrupted", wantErr: "invalid state file"},
+		{name: "no run to resume", dir: filepath.Join(dir, "empty"), wantErr: "no run to resume in"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := state.Load(tt.dir, tt.runID); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load returned error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadLatest(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	// The latest run is the one saved last, not the last one by name.
+	for i, runID := range []string{"run-b", "run-a", "run-c"} {
+		s, err := state.New(dir, runID)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		modTime := now.Add(time.Duration(i-3) * time.Minute)
+		if runID == "run-a" {
+			modTime = now
+		}
+		if err := os.Chtimes(s.Path(), modTime, modTime); err != nil {
+			t.Fatalf("Failed to set the time of the state file: %v", err)
+		}
+	}
+	// Other files and directories are not runs.
+	if err := ioutil.WriteFile(filepath.Join(dir, "run-z.json.tmp"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "run-y.json"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	s, err := state.Load(dir, "")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if s.RunID != "run-a" {
+		t.Errorf("loaded run %s, want run-a", s.RunID)
+	}
+}
+
+func TestSucceeded(t *testing.T) {
+	s, err := state.New(t.TempDir(), "run-1")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	records := []struct {
+		host, task string
+		taskState  *state.TaskState
+	}{
+		{"win-1", "ovs", &state.TaskState{Status: state.StatusSucceeded, Fingerprint: "sha256:1"}},
+		{"win-1", "containers", &state.TaskState{Status: "failed", Fingerprint: "sha256:2"}},
+		{"win-2", "ovs", &state.TaskState{Status: "failed", Fingerprint: "sha256:1"}},
+		// The last result of a task replaces the earlier ones.
+		{"win-2", "ovs", &state.TaskState{Status: state.StatusSucceeded, Fingerprint: "sha256:1"}},
+	}
+	for _, record := range records {
+		if err := s.Record(record.host, record.task, record.taskState); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	tests := []struct {
+		name        string
+		host        string
+		task        string
+		fingerprint string
+		want        bool
+	}{
+		{name: "succeeded", host: "win-1", task: "ovs", fingerprint: "sha256:1", want: true},
+		{name: "succeeded after failing", host: "win-2", task: "ovs", fingerprint: "sha256:1", want: true},
+		{name: "parameters changed", host: "win-1", task: "ovs", fingerprint: "sha256:3"},
+		{name: "failed", host: "win-1", task: "containers", fingerprint: "sha256:2"},
+		{name: "unknown task", host: "win-1", task: "antrea", fingerprint: "sha256:1"},
+		{name: "unknown host", host: "win-3", task: "ovs", fingerprint: "sha256:1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Succeeded(tt.host, tt.task, tt.fingerprint); got != tt.want {
+				t.Errorf("Succeeded(%s, %s, %s) = %v, want %v", tt.host, tt.task, tt.fingerprint, got, tt.want)
+			}
+		})
+	}
+}
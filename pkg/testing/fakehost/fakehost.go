@@ -0,0 +1,651 @@
+// Package fakehost provides an in-memory Windows host which implements
+// executor.Executor, so features can be exercised without a real machine.
+//
+// The fake understands the PowerShell commands issued by the features in this
+// repository (Windows features, optional features, dism, pnputil, services,
+// registry lookups, reboots and basic file operations), keeps the resulting machine state, and
+// records every command it receives. Commands it does not understand fail with
+// exit code 1 unless a handler is registered for them with Handle.
+package fakehost
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/util"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StateInstalled        = "Installed"
+	StateAvailable        = "Available"
+	StateInstallPending   = "InstallPending"
+	StateUninstallPending = "UninstallPending"
+
+	StateEnabled        = "Enabled"
+	StateDisabled       = "Disabled"
+	StateEnablePending  = "EnablePending"
+	StateDisablePending = "DisablePending"
+
+	ServiceRunning = "Running"
+	ServiceStopped = "Stopped"
+)
+
+// Handler simulates a command. match holds the sub matches of the pattern the
+// handler is registered with.
+type Handler func(host *FakeHost, match []string) (code int, stdout string, stderr string)
+
+// Driver is a driver package listed by "pnputil.exe -e".
+type Driver struct {
+	PublishedName string
+	Provider      string
+	Class         string
+}
+
+type handler struct {
+	pattern *regexp.Regexp
+	fn      Handler
+}
+
+// FakeHost is an in-memory Windows host. All fields are protected by the
+// host lock, use the accessor methods to read or modify them when commands may
+// run concurrently.
+type FakeHost struct {
+	mu sync.Mutex
+
+	// WindowsFeatures maps a Windows feature name to its InstallState.
+	WindowsFeatures map[string]string
+	// OptionalFeatures maps an optional feature name to its State.
+	OptionalFeatures map[string]string
+	// LinkedFeatures maps a Windows feature to the optional features which are
+	// enabled or disabled together with it.
+	LinkedFeatures map[string][]string
+	// Services maps a service name to its status.
+	Services map[string]string
+	Drivers  []Driver
+	// Files maps a file path to its content, directories are stored with a nil
+	// content.
+	Files map[string][]byte
+	// URLs maps a URL to the content "curl.exe" downloads from it.
+	URLs map[string][]byte
+	// Registry maps a lower case registry key to its values. Pending feature
+	// changes create the Component Based Servicing RebootPending key, and a
+	// restart removes the keys and values reporting a pending reboot.
+	Registry map[string]map[string]string
+
+	// BootTime is the LastBootUpTime of the host, it advances on restarts.
+	BootTime time.Time
+	// Reboots is the number of times the host is restarted.
+	Reboots int
+	// DownCommands is the number of commands which fail with a transport error
+	// after the host is restarted, it simulates the host being down.
+	DownCommands int
+	// Unreachable makes every command fail with a transport error.
+	Unreachable bool
+
+	down       int
+	onReboot   []func()
+	handlers   []handler
+	builtins   []handler
+	transcript []string
+}
+
+// New returns a fake Windows Server with Containers and Hyper-V available but
+// not installed.
+func New() *FakeHost {
+	h := &FakeHost{
+		WindowsFeatures: map[string]string{
+			"Containers": StateAvailable,
+			"Hyper-V":    StateAvailable,
+		},
+		OptionalFeatures: map[string]string{
+			"Containers":               StateDisabled,
+			"Microsoft-Hyper-V":        StateDisabled,
+			"Microsoft-Hyper-V-Online": StateDisabled,
+		},
+		LinkedFeatures: map[string][]string{
+			"Containers": {"Containers"},
+			"Hyper-V":    {"Microsoft-Hyper-V", "Microsoft-Hyper-V-Online"},
+		},
+		Services:     map[string]string{},
+		Files:        map[string][]byte{},
+		URLs:         map[string][]byte{},
+		Registry:     map[string]map[string]string{},
+		BootTime:     time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC),
+		DownCommands: 1,
+	}
+	h.builtins = []handler{
+		{regexp.MustCompile(`^\$\(Get-WindowsFeature -Name (\S+) -ErrorAction SilentlyContinue\)\.InstallState$`), getWindowsFeature},
+		{regexp.MustCompile(`^\$\(Get-WindowsOptionalFeature -Online -FeatureName (\S+) -ErrorAction SilentlyContinue\)\.State$`), getOptionalFeature},
+		{regexp.MustCompile(`^Install-WindowsFeature -Name (\S+)$`), installWindowsFeature},
+		{regexp.MustCompile(`^Remove-WindowsFeature -Name (\S+)$`), removeWindowsFeature},
+		{regexp.MustCompile(`^dism /online /enable-feature /featurename:(\S+)( /all)? /NoRestart$`), enableOptionalFeature},
+		{regexp.MustCompile(`^dism /online /disable-feature /featurename:(\S+) /NoRestart$`), disableOptionalFeature},
+		{regexp.MustCompile(`^pnputil\.exe -e$`), listDrivers},
+		{regexp.MustCompile(`^pnputil\.exe /delete-driver (\S+)$`), deleteDriver},
+		{regexp.MustCompile(`^\$\(Get-Service "([^"]+)" -ErrorAction SilentlyContinue\)\.Name$`), getService},
+		{regexp.MustCompile(`^\$\(Get-Service "([^"]+)" -ErrorAction SilentlyContinue\)\.Status$`), getServiceStatus},
+		{regexp.MustCompile(`^\(Get-CimInstance -ClassName Win32_OperatingSystem\)\.LastBootUpTime\.ToUniversalTime\(\)\.ToString\('o'\)$`), getBootTime},
+		{regexp.MustCompile(`^Restart-Computer -Force$`), restartComputer},
+		{regexp.MustCompile(`^ls$`), func(*FakeHost, []string) (int, string, string) { return 0, "", "" }},
+		{regexp.MustCompile(`^mkdir -Force "([^"]+)"$`), createDir},
+		{regexp.MustCompile(`^rm (?:-r )?-Force "([^"]+)"$`), removePath},
+		{regexp.MustCompile(`^Get-Item (\S+)$`), getItem},
+		{regexp.MustCompile(`^curl\.exe -sLo (\S+) (\S+)$`), curl},
+		{regexp.MustCompile(`^Test-Path -Path '([^']+)'$`), testRegistryKey},
+		{regexp.MustCompile(`^\$null -ne \(Get-ItemProperty -Path '([^']+)' -Name (\S+) -ErrorAction SilentlyContinue\)$`), testRegistryValue},
+	}
+	return h
+}
+
+// Host returns a config.Host whose Executor is the fake host, and whose
+// SSHExecutor is a Session of it.
+func (h *FakeHost) Host(name string) *config.Host {
+	return &config.Host{
+		HostConfig:  &config.HostConfig{Host: name, User: config.DefaultUser},
+		Executor:    h,
+		SSHExecutor: h.Session(),
+	}
+}
+
+// Session returns a persistent connection to the host, as an SSH connection.
+// Unlike the host itself, whose commands behave as if each of them connected
+// again like WinRM, a session connected before a restart fails every command
+// until it is closed, the next command then connects again.
+func (h *FakeHost) Session() *Session {
+	return &Session{host: h}
+}
+
+// Handle registers a handler for the commands matching pattern. Handlers
+// registered later take precedence, and all of them take precedence over the
+// built-in simulation.
+func (h *FakeHost) Handle(pattern string, fn Handler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers = append([]handler{{regexp.MustCompile(pattern), fn}}, h.handlers...)
+}
+
+// OnReboot registers a function which is called once when the host restarts
+// next time, it can be used to apply a state change which only takes effect
+// after a reboot. fn is called with the host lock held, so it must modify the
+// fields directly instead of calling the accessor methods.
+func (h *FakeHost) OnReboot(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onReboot = append(h.onReboot, fn)
+}
+
+// Transcript returns the commands received by the host in order.
+func (h *FakeHost) Transcript() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.transcript...)
+}
+
+// Ran returns whether a command matching pattern is received by the host.
+func (h *FakeHost) Ran(pattern string) bool {
+	re := regexp.MustCompile(pattern)
+	for _, cmd := range h.Transcript() {
+		if re.MatchString(cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *FakeHost) WindowsFeature(name string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.WindowsFeatures[name]
+}
+
+func (h *FakeHost) OptionalFeature(name string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.OptionalFeatures[name]
+}
+
+// SetWindowsFeature sets the InstallState of a Windows feature, and the state
+// of its linked optional features accordingly.
+func (h *FakeHost) SetWindowsFeature(name, state string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.WindowsFeatures[name] = state
+	for _, optional := range h.LinkedFeatures[name] {
+		if state == StateInstalled {
+			h.OptionalFeatures[optional] = StateEnabled
+		} else if state == StateAvailable {
+			h.OptionalFeatures[optional] = StateDisabled
+		}
+	}
+}
+
+func (h *FakeHost) SetOptionalFeature(name, state string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.OptionalFeatures[name] = state
+}
+
+func (h *FakeHost) SetService(name, status string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Services[name] = status
+}
+
+func (h *FakeHost) AddDriver(driver Driver) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Drivers = append(h.Drivers, driver)
+}
+
+// SetRegistryValue creates a registry key and sets a value of it, the value is
+// not set if name is empty.
+func (h *FakeHost) SetRegistryValue(key, name, value string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.setRegistryValue(key, name, value)
+}
+
+// RegistryKeyExists returns whether a registry key exists.
+func (h *FakeHost) RegistryKeyExists(key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.Registry[strings.ToLower(key)]
+	return ok
+}
+
+func (h *FakeHost) setRegistryValue(key, name, value string) {
+	key = strings.ToLower(key)
+	if h.Registry[key] == nil {
+		h.Registry[key] = map[string]string{}
+	}
+	if name != "" {
+		h.Registry[key][name] = value
+	}
+}
+
+// pendReboot schedules fn to run on the next restart, and reports the pending
+// reboot in the registry like Windows does.
+func (h *FakeHost) pendReboot(fn func()) {
+	h.onReboot = append(h.onReboot, fn)
+	h.setRegistryValue(util.RegistryKeyCBSRebootPending, "", "")
+}
+
+func (h *FakeHost) RebootCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.Reboots
+}
+
+// File returns the content of a file and whether it exists.
+func (h *FakeHost) File(path string) ([]byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	content, ok := h.Files[normalizePath(path)]
+	return content, ok
+}
+
+func (h *FakeHost) RunPS(ctx context.Context, cmd string) (int, string, string, error) {
+	return h.run(ctx, cmd)
+}
+
+func (h *FakeHost) Run(ctx context.Context, cmd string) (int, string, string, error) {
+	return h.run(ctx, cmd)
+}
+
+func (h *FakeHost) Upload(ctx context.Context, src io.Reader, dstPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.transcript = append(h.transcript, fmt.Sprintf("<upload %s>", dstPath))
+	if err := h.checkReachable(); err != nil {
+		return err
+	}
+	h.Files[normalizePath(dstPath)] = content
+	return nil
+}
+
+func (h *FakeHost) Download(ctx context.Context, srcPath string, dst io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.transcript = append(h.transcript, fmt.Sprintf("<download %s>", srcPath))
+	if err := h.checkReachable(); err != nil {
+		h.mu.Unlock()
+		return err
+	}
+	content, ok := h.Files[normalizePath(srcPath)]
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("file %s not found", srcPath)
+	}
+	_, err := io.Copy(dst, bytes.NewReader(content))
+	return err
+}
+
+func (h *FakeHost) Close() error {
+	return nil
+}
+
+func (h *FakeHost) checkReachable() error {
+	if h.Unreachable {
+		return fmt.Errorf("fake host is unreachable")
+	}
+	if h.down > 0 {
+		h.down--
+		return fmt.Errorf("fake host is down")
+	}
+	return nil
+}
+
+func (h *FakeHost) run(ctx context.Context, cmd string) (int, string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", "", err
+	}
+	cmd = strings.TrimSpace(cmd)
+	h.mu.Lock()
+	h.transcript = append(h.transcript, cmd)
+	if err := h.checkReachable(); err != nil {
+		h.mu.Unlock()
+		return 0, "", "", err
+	}
+	handlers := h.handlers
+	h.mu.Unlock()
+
+	// Registered handlers run without the host lock, so they are free to call
+	// the accessor methods. They may block to simulate a hung command, which
+	// is abandoned when ctx is done.
+	for _, handler := range handlers {
+		if match := handler.pattern.FindStringSubmatch(cmd); match != nil {
+			type output struct {
+				code           int
+				stdout, stderr string
+			}
+			done := make(chan output, 1)
+			go func() {
+				code, stdout, stderr := handler.fn(h, match)
+				done <- output{code, stdout, stderr}
+			}()
+			select {
+			case out := <-done:
+				return out.code, out.stdout, out.stderr, nil
+			case <-ctx.Done():
+				return 0, "", "", ctx.Err()
+			}
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, handler := range h.builtins {
+		if match := handler.pattern.FindStringSubmatch(cmd); match != nil {
+			code, stdout, stderr := handler.fn(h, match)
+			return code, stdout, stderr, nil
+		}
+	}
+	return 1, "", fmt.Sprintf("fake host: unsupported command: %s", cmd), nil
+}
+
+func normalizePath(path string) string {
+	path = strings.ReplaceAll(path, `\`, "/")
+	return strings.TrimSuffix(strings.ToLower(path), "/")
+}
+
+func getWindowsFeature(h *FakeHost, match []string) (int, string, string) {
+	state, ok := h.WindowsFeatures[match[1]]
+	if !ok {
+		return 0, "", ""
+	}
+	return 0, state + "\r\n", ""
+}
+
+func getOptionalFeature(h *FakeHost, match []string) (int, string, string) {
+	state, ok := h.OptionalFeatures[match[1]]
+	if !ok {
+		return 0, "", ""
+	}
+	return 0, state + "\r\n", ""
+}
+
+func installWindowsFeature(h *FakeHost, match []string) (int, string, string) {
+	name := match[1]
+	state, ok := h.WindowsFeatures[name]
+	if !ok {
+		return 1, "", fmt.Sprintf("ArgumentNotValid: The role, role service, or feature name is not valid: '%s'", name)
+	}
+	if state == StateInstalled || state == StateInstallPending {
+		return 0, "Success Restart Needed Exit Code Feature Result\r\nTrue    No             NoChangeNeeded {}\r\n", ""
+	}
+	h.WindowsFeatures[name] = StateInstallPending
+	h.pendReboot(func() {
+		h.WindowsFeatures[name] = StateInstalled
+		for _, optional := range h.LinkedFeatures[name] {
+			h.OptionalFeatures[optional] = StateEnabled
+		}
+	})
+	return 0, "Success Restart Needed Exit Code      Feature Result\r\nTrue    Yes            SuccessRest... {" + name + "}\r\n", ""
+}
+
+func removeWindowsFeature(h *FakeHost, match []string) (int, string, string) {
+	name := match[1]
+	state, ok := h.WindowsFeatures[name]
+	if !ok {
+		return 1, "", fmt.Sprintf("ArgumentNotValid: The role, role service, or feature name is not valid: '%s'", name)
+	}
+	if state == StateAvailable || state == StateUninstallPending {
+		return 0, "Success Restart Needed Exit Code Feature Result\r\nTrue    No             NoChangeNeeded {}\r\n", ""
+	}
+	h.WindowsFeatures[name] = StateUninstallPending
+	h.pendReboot(func() {
+		h.WindowsFeatures[name] = StateAvailable
+		for _, optional := range h.LinkedFeatures[name] {
+			h.OptionalFeatures[optional] = StateDisabled
+		}
+	})
+	return 0, "Success Restart Needed Exit Code      Feature Result\r\nTrue    Yes            SuccessRest... {" + name + "}\r\n", ""
+}
+
+func enableOptionalFeature(h *FakeHost, match []string) (int, string, string) {
+	name := match[1]
+	if _, ok := h.OptionalFeatures[name]; !ok {
+		return 1, "", fmt.Sprintf("Error: 0x800f080c\r\nFeature name %s is unknown.", name)
+	}
+	h.OptionalFeatures[name] = StateEnablePending
+	h.pendReboot(func() {
+		h.OptionalFeatures[name] = StateEnabled
+	})
+	return 0, "The operation completed successfully.\r\n", ""
+}
+
+func disableOptionalFeature(h *FakeHost, match []string) (int, string, string) {
+	name := match[1]
+	if _, ok := h.OptionalFeatures[name]; !ok {
+		return 1, "", fmt.Sprintf("Error: 0x800f080c\r\nFeature name %s is unknown.", name)
+	}
+	h.OptionalFeatures[name] = StateDisablePending
+	h.pendReboot(func() {
+		h.OptionalFeatures[name] = StateDisabled
+	})
+	return 0, "The operation completed successfully.\r\n", ""
+}
+
+func listDrivers(h *FakeHost, match []string) (int, string, string) {
+	var out strings.Builder
+	out.WriteString("Microsoft PnP Utility\r\n\r\n")
+	for _, driver := range h.Drivers {
+		fmt.Fprintf(&out, "Published name :            %s\r\n", driver.PublishedName)
+		fmt.Fprintf(&out, "Driver package provider :   %s\r\n", driver.Provider)
+		fmt.Fprintf(&out, "Class :                     %s\r\n\r\n", driver.Class)
+	}
+	return 0, out.String(), ""
+}
+
+func deleteDriver(h *FakeHost, match []string) (int, string, string) {
+	for i, driver := range h.Drivers {
+		if driver.PublishedName == match[1] {
+			h.Drivers = append(h.Drivers[:i], h.Drivers[i+1:]...)
+			return 0, "Driver package deleted successfully.\r\n", ""
+		}
+	}
+	return 1, "", "Failed to delete driver package: The system cannot find the file specified.\r\n"
+}
+
+func getService(h *FakeHost, match []string) (int, string, string) {
+	if _, ok := h.Services[match[1]]; !ok {
+		return 0, "", ""
+	}
+	return 0, match[1] + "\r\n", ""
+}
+
+func getServiceStatus(h *FakeHost, match []string) (int, string, string) {
+	status, ok := h.Services[match[1]]
+	if !ok {
+		return 0, "", ""
+	}
+	return 0, status + "\r\n", ""
+}
+
+func getBootTime(h *FakeHost, match []string) (int, string, string) {
+	return 0, h.BootTime.Format("2006-01-02T15:04:05.0000000Z") + "\r\n", ""
+}
+
+func restartComputer(h *FakeHost, match []string) (int, string, string) {
+	h.Reboots++
+	h.BootTime = h.BootTime.Add(time.Minute)
+	for _, fn := range h.onReboot {
+		fn()
+	}
+	h.onReboot = nil
+	delete(h.Registry, strings.ToLower(util.RegistryKeyCBSRebootPending))
+	delete(h.Registry, strings.ToLower(util.RegistryKeyWURebootRequired))
+	if values := h.Registry[strings.ToLower(util.RegistryKeySessionManager)]; values != nil {
+		delete(values, util.RegistryValuePendingFileRenames)
+	}
+	h.down = h.DownCommands
+	return 0, "", ""
+}
+
+func createDir(h *FakeHost, match []string) (int, string, string) {
+	h.Files[normalizePath(match[1])] = nil
+	return 0, "", ""
+}
+
+func removePath(h *FakeHost, match []string) (int, string, string) {
+	path := normalizePath(match[1])
+	var removed []string
+	for file := range h.Files {
+		if file == path || strings.HasPrefix(file, path+"/") {
+			removed = append(removed, file)
+		}
+	}
+	if len(removed) == 0 {
+		return 1, "", fmt.Sprintf("Cannot find path '%s' because it does not exist.", match[1])
+	}
+	for _, file := range removed {
+		delete(h.Files, file)
+	}
+	return 0, "", ""
+}
+
+func getItem(h *FakeHost, match []string) (int, string, string) {
+	if _, ok := h.Files[normalizePath(match[1])]; !ok {
+		return 1, "", fmt.Sprintf("Cannot find path '%s' because it does not exist.", match[1])
+	}
+	return 0, match[1] + "\r\n", ""
+}
+
+func curl(h *FakeHost, match []string) (int, string, string) {
+	content, ok := h.URLs[match[2]]
+	if !ok {
+		content = []byte(fmt.Sprintf("# downloaded from %s\r\n", match[2]))
+	}
+	h.Files[normalizePath(match[1])] = content
+	return 0, "", ""
+}
+
+func testRegistryKey(h *FakeHost, match []string) (int, string, string) {
+	if _, ok := h.Registry[strings.ToLower(match[1])]; ok {
+		return 0, "True\r\n", ""
+	}
+	return 0, "False\r\n", ""
+}
+
+func testRegistryValue(h *FakeHost, match []string) (int, string, string) {
+	if _, ok := h.Registry[strings.ToLower(match[1])][match[2]]; ok {
+		return 0, "True\r\n", ""
+	}
+	return 0, "False\r\n", ""
+}
+
+type Session struct {
+	host *FakeHost
+
+	mu        sync.Mutex
+	connected bool
+	// reboots is the number of restarts of the host when the session
+	// connected.
+	reboots int
+}
+
+// connect connects the session if it is not, and returns an error if the host
+// restarted since it connected.
+func (s *Session) connect() error {
+	reboots := s.host.RebootCount()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.connected {
+		s.connected = true
+		s.reboots = reboots
+	}
+	if s.reboots != reboots {
+		return fmt.Errorf("fake host session is broken by a restart")
+	}
+	return nil
+}
+
+func (s *Session) RunPS(ctx context.Context, cmd string) (int, string, string, error) {
+	if err := s.connect(); err != nil {
+		return 0, "", "", err
+	}
+	return s.host.RunPS(ctx, cmd)
+}
+
+func (s *Session) Run(ctx context.Context, cmd string) (int, string, string, error) {
+	if err := s.connect(); err != nil {
+		return 0, "", "", err
+	}
+	return s.host.Run(ctx, cmd)
+}
+
+func (s *Session) Upload(ctx context.Context, src io.Reader, dstPath string) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+	return s.host.Upload(ctx, src, dstPath)
+}
+
+func (s *Session) Download(ctx context.Context, srcPath string, dst io.Writer) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+	return s.host.Download(ctx, srcPath, dst)
+}
+
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+	return nil
+}
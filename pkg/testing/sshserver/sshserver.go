@@ -0,0 +1,337 @@
+// Package sshserver provides an in-process SSH server with programmable
+// command handlers, so the SSH transport can be exercised in go test without a
+// Windows machine.
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// ExitMissing makes the server close the session without sending an exit
+	// status, the client gets an *ssh.ExitMissingError.
+	ExitMissing = -1
+)
+
+// CommandHandler handles a command received by the server and returns its exit
+// status. match holds the sub matches of the pattern the handler is registered
+// with, for PowerShell handlers they are matched against the decoded script.
+type CommandHandler func(match []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+type handler struct {
+	pattern *regexp.Regexp
+	ps      bool
+	fn      CommandHandler
+}
+
+// Server is an SSH server listening on the loopback interface.
+type Server struct {
+	User     string
+	Password string
+	// Backend runs the commands which no handler matches, e.g. a
+	// fakehost.FakeHost. If it is nil, such commands exit with status 127.
+	Backend executor.Executor
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.Signer
+
+	mu             sync.Mutex
+	authorizedKeys []ssh.PublicKey
+	handlers       []handler
+	commands       []string
+	conns          map[*ssh.ServerConn]struct{}
+	wg             sync.WaitGroup
+}
+
+// New starts a server which accepts password authentication with the given
+// credentials.
+func New(user, password string) (*Server, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %v", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host key signer: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %v", err)
+	}
+	s := &Server{
+		User:     user,
+		Password: password,
+		listener: listener,
+		hostKey:  hostKey,
+		conns:    map[*ssh.ServerConn]struct{}{},
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == s.User && s.Password != "" && string(password) == s.Password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, authorizedKey := range s.authorizedKeys {
+				if conn.User() == s.User && bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("public key rejected for %s", conn.User())
+		},
+	}
+	s.config.AddHostKey(hostKey)
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// AuthorizeKey accepts public key authentication of the user with key. Set
+// Password to "" to reject password authentication.
+func (s *Server) AuthorizeKey(key ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizedKeys = append(s.authorizedKeys, key)
+}
+
+// HostConfig returns a host config whose SSH settings point to the server,
+// the host key of the server is accepted without verification.
+func (s *Server) HostConfig() *config.HostConfig {
+	return &config.HostConfig{
+		Host:     s.Host(),
+		User:     s.User,
+		Password: s.Password,
+		SSH: config.SSHConfig{
+			Port:                  s.Port(),
+			InsecureIgnoreHostKey: true,
+		},
+	}
+}
+
+// Addr returns the "host:port" address the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr())
+	return host
+}
+
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// HostKey returns the public host key of the server.
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// Handle registers a handler for the raw commands matching pattern.
+func (s *Server) Handle(pattern string, fn CommandHandler) {
+	s.addHandler(handler{pattern: regexp.MustCompile(pattern), fn: fn})
+}
+
+// HandlePS registers a handler for the PowerShell commands, as sent by
+// executor.SSHExecutor.RunPS, whose script matches pattern.
+func (s *Server) HandlePS(pattern string, fn CommandHandler) {
+	s.addHandler(handler{pattern: regexp.MustCompile(pattern), ps: true, fn: fn})
+}
+
+func (s *Server) addHandler(h handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append([]handler{h}, s.handlers...)
+}
+
+// Commands returns the commands received by the server in order, PowerShell
+// commands are recorded as their decoded scripts.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Dial connects a client to the server with the server credentials.
+func (s *Server) Dial() (*ssh.Client, error) {
+	return ssh.Dial("tcp", s.Addr(), &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            []ssh.AuthMethod{ssh.Password(s.Password)},
+		HostKeyCallback: ssh.FixedHostKey(s.HostKey()),
+		Timeout:         5 * time.Second,
+	})
+}
+
+// Executor returns an executor.SSHExecutor connected to the server.
+func (s *Server) Executor() (*executor.SSHExecutor, error) {
+	client, err := s.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return executor.NewSSHExecutor(client), nil
+}
+
+// CloseConnections drops all client connections but keeps listening, it
+// simulates a broken SSH session.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Close stops the server and waits for all connections to finish.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.CloseConnections()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(netConn net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, s.config)
+	if err != nil {
+		netConn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	go ssh.DiscardRequests(reqs)
+	var wg sync.WaitGroup
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveSession(channel, requests)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Server) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			// The requests end when the client closes the session, which
+			// cancels the Backend command.
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				for range requests {
+				}
+				cancel()
+			}()
+			status := s.exec(ctx, payload.Command, channel)
+			cancel()
+			if status != ExitMissing {
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			}
+			return
+		case "env", "pty-req":
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *Server) exec(ctx context.Context, cmd string, channel ssh.Channel) int {
+	script, isPS := executor.DecodePS(cmd)
+	s.mu.Lock()
+	if isPS {
+		s.commands = append(s.commands, script)
+	} else {
+		s.commands = append(s.commands, cmd)
+	}
+	handlers := s.handlers
+	s.mu.Unlock()
+
+	for _, h := range handlers {
+		target := cmd
+		if h.ps {
+			if !isPS {
+				continue
+			}
+			target = script
+		}
+		if match := h.pattern.FindStringSubmatch(target); match != nil {
+			return h.fn(match, channel, channel, channel.Stderr())
+		}
+	}
+	if s.Backend == nil {
+		fmt.Fprintf(channel.Stderr(), "sshserver: unsupported command: %s", cmd)
+		return 127
+	}
+	var code int
+	var stdout, stderr string
+	var err error
+	if isPS {
+		code, stdout, stderr, err = s.Backend.RunPS(ctx, script)
+	} else {
+		code, stdout, stderr, err = s.Backend.Run(ctx, cmd)
+	}
+	if err != nil {
+		// A transport error of the backend is seen as a dropped session.
+		return ExitMissing
+	}
+	io.WriteString(channel, stdout)
+	io.WriteString(channel.Stderr(), stderr)
+	return code
+}
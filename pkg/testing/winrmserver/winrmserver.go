@@ -0,0 +1,472 @@
+// Package winrmserver provides an httptest based WinRM endpoint which speaks
+// enough WS-Management to serve github.com/masterzen/winrm clients: Create
+// Shell, Command, Send, Receive, Signal and Delete Shell. Tests register the
+// responses of raw and PowerShell commands, so the WinRM transport is exercised
+// at the protocol level without a Windows machine.
+package winrmserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ActionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	ActionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	ActionCommand = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	ActionSend    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	ActionReceive = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	ActionSignal  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+
+	commandStateDone = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+)
+
+// CommandHandler handles a command received by the server and returns its exit
+// code. match holds the sub matches of the pattern the handler is registered
+// with, for PowerShell handlers they are matched against the decoded script.
+type CommandHandler func(match []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+type handler struct {
+	pattern *regexp.Regexp
+	ps      bool
+	fn      CommandHandler
+}
+
+type command struct {
+	// cancel cancels the context of the Backend command on Signal.
+	cancel context.CancelFunc
+	stdin  *io.PipeWriter
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	code   int
+	done   chan struct{}
+}
+
+// Server is a WinRM endpoint listening on the loopback interface.
+type Server struct {
+	User     string
+	Password string
+	// Backend runs the commands which no handler matches, e.g. a
+	// fakehost.FakeHost. If it is nil, such commands exit with code 1.
+	Backend executor.Executor
+	// ReceiveTimeout is how long a Receive request waits for a running command
+	// before it is answered with an OperationTimeout fault.
+	ReceiveTimeout time.Duration
+
+	server *httptest.Server
+
+	mu         sync.Mutex
+	handlers   []handler
+	commands   []string
+	actions    []string
+	shells     map[string]map[string]*command
+	nextID     int
+	failures   int
+	failStatus int
+}
+
+// New starts a server which accepts Basic authentication with the given
+// credentials.
+func New(user, password string) *Server {
+	s := &Server{
+		User:           user,
+		Password:       password,
+		ReceiveTimeout: time.Second,
+		shells:         map[string]map[string]*command{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.server.Listener.Addr().String())
+	return host
+}
+
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// HostConfig returns a host config pointing to the server.
+func (s *Server) HostConfig() *config.HostConfig {
+	return &config.HostConfig{
+		Host:     s.Host(),
+		Port:     s.Port(),
+		User:     s.User,
+		Password: s.Password,
+	}
+}
+
+// Executor returns an executor.WinRMExecutor connected to the server with
+// config.NewWinRMClient.
+func (s *Server) Executor() (*executor.WinRMExecutor, error) {
+	client, err := config.NewWinRMClient(s.HostConfig())
+	if err != nil {
+		return nil, err
+	}
+	return executor.NewWinRMExecutor(client), nil
+}
+
+// Handle registers a handler for the raw commands, i.e. the command and its
+// arguments joined by spaces, matching pattern.
+func (s *Server) Handle(pattern string, fn CommandHandler) {
+	s.addHandler(handler{pattern: regexp.MustCompile(pattern), fn: fn})
+}
+
+// HandlePS registers a handler for the PowerShell commands, as sent by
+// executor.WinRMExecutor.RunPS, whose script matches pattern.
+func (s *Server) HandlePS(pattern string, fn CommandHandler) {
+	s.addHandler(handler{pattern: regexp.MustCompile(pattern), ps: true, fn: fn})
+}
+
+// RespondPS registers a fixed response for the PowerShell scripts matching
+// pattern.
+func (s *Server) RespondPS(pattern string, code int, stdout, stderr string) {
+	s.HandlePS(pattern, func(_ []string, _ io.Reader, outW, errW io.Writer) int {
+		io.WriteString(outW, stdout)
+		io.WriteString(errW, stderr)
+		return code
+	})
+}
+
+func (s *Server) addHandler(h handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append([]handler{h}, s.handlers...)
+}
+
+// FailRequests makes the next n requests fail with a plain HTTP error of the
+// status code, it simulates transient failures of the WinRM service.
+func (s *Server) FailRequests(n int, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+	s.failStatus = status
+}
+
+// Commands returns the commands received by the server in order, PowerShell
+// commands are recorded as their decoded scripts.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Actions returns the WS-Management actions received by the server in order.
+func (s *Server) Actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.actions...)
+}
+
+// OpenShells returns the number of shells which are not deleted.
+func (s *Server) OpenShells() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.shells)
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+type selector struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type envelope struct {
+	Header struct {
+		Action    string     `xml:"Action"`
+		MessageID string     `xml:"MessageID"`
+		Selectors []selector `xml:"SelectorSet>Selector"`
+	} `xml:"Header"`
+	Body struct {
+		CommandLine *struct {
+			Command   string   `xml:"Command"`
+			Arguments []string `xml:"Arguments"`
+		} `xml:"CommandLine"`
+		Send *struct {
+			Stream struct {
+				CommandID string `xml:"CommandId,attr"`
+				End       bool   `xml:"End,attr"`
+				Content   string `xml:",chardata"`
+			} `xml:"Stream"`
+		} `xml:"Send"`
+		Receive *struct {
+			DesiredStream struct {
+				CommandID string `xml:"CommandId,attr"`
+			} `xml:"DesiredStream"`
+		} `xml:"Receive"`
+		Signal *struct {
+			CommandID string `xml:"CommandId,attr"`
+		} `xml:"Signal"`
+	} `xml:"Body"`
+}
+
+func (e *envelope) shellID() string {
+	for _, s := range e.Header.Selectors {
+		if s.Name == "ShellId" {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != s.User || password != s.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req envelope
+	if err := xml.Unmarshal(data, &req); err != nil {
+		s.fault(w, http.StatusBadRequest, "", "w:InvalidMessage", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.actions = append(s.actions, req.Header.Action)
+	if s.failures > 0 {
+		s.failures--
+		status := s.failStatus
+		s.mu.Unlock()
+		// A SOAP fault with a non-200 status is not reported as an error by
+		// the winrm client, so answer with a plain HTTP error instead.
+		http.Error(w, "injected failure", status)
+		return
+	}
+	s.mu.Unlock()
+
+	switch req.Header.Action {
+	case ActionCreate:
+		s.createShell(w, &req)
+	case ActionDelete:
+		s.deleteShell(w, &req)
+	case ActionCommand:
+		s.startCommand(w, &req)
+	case ActionSend:
+		s.send(w, &req)
+	case ActionReceive:
+		s.receive(w, &req)
+	case ActionSignal:
+		s.signal(w, &req)
+	default:
+		s.fault(w, http.StatusBadRequest, req.Header.MessageID, "w:ActionNotSupported", req.Header.Action)
+	}
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextID)
+}
+
+func (s *Server) lookup(shellID, commandID string) (*command, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd, ok := s.shells[shellID][commandID]
+	return cmd, ok
+}
+
+func (s *Server) createShell(w http.ResponseWriter, req *envelope) {
+	s.mu.Lock()
+	shellID := s.newID()
+	s.shells[shellID] = map[string]*command{}
+	s.mu.Unlock()
+	s.respond(w, "http://schemas.xmlsoap.org/ws/2004/09/transfer/CreateResponse", req.Header.MessageID, fmt.Sprintf(
+		`<x:ResourceCreated><a:Address>%s</a:Address><a:ReferenceParameters><w:ResourceURI>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd</w:ResourceURI><w:SelectorSet><w:Selector Name="ShellId">%s</w:Selector></w:SelectorSet></a:ReferenceParameters></x:ResourceCreated><rsp:Shell><rsp:ShellId>%s</rsp:ShellId></rsp:Shell>`,
+		s.server.URL, shellID, shellID))
+}
+
+func (s *Server) deleteShell(w http.ResponseWriter, req *envelope) {
+	s.mu.Lock()
+	_, ok := s.shells[req.shellID()]
+	delete(s.shells, req.shellID())
+	s.mu.Unlock()
+	if !ok {
+		s.fault(w, http.StatusBadRequest, req.Header.MessageID, "w:InvalidSelectors", "unknown shell")
+		return
+	}
+	s.respond(w, "http://schemas.xmlsoap.org/ws/2004/09/transfer/DeleteResponse", req.Header.MessageID, "")
+}
+
+func (s *Server) startCommand(w http.ResponseWriter, req *envelope) {
+	if req.Body.CommandLine == nil {
+		s.fault(w, http.StatusBadRequest, req.Header.MessageID, "w:InvalidMessage", "missing CommandLine")
+		return
+	}
+	line := strings.TrimSpace(strings.Join(append([]string{req.Body.CommandLine.Command}, req.Body.CommandLine.Arguments...), " "))
+	script, isPS := executor.DecodePS(line)
+
+	s.mu.Lock()
+	commands, ok := s.shells[req.shellID()]
+	if !ok {
+		s.mu.Unlock()
+		s.fault(w, http.StatusBadRequest, req.Header.MessageID, "w:InvalidSelectors", "unknown shell")
+		return
+	}
+	commandID := s.newID()
+	stdinR, stdinW := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := &command{cancel: cancel, stdin: stdinW, done: make(chan struct{})}
+	commands[commandID] = cmd
+	if isPS {
+		s.commands = append(s.commands, script)
+	} else {
+		s.commands = append(s.commands, line)
+	}
+	handlers := s.handlers
+	s.mu.Unlock()
+
+	go func() {
+		defer close(cmd.done)
+		defer stdinR.Close()
+		defer cancel()
+		cmd.code = s.exec(ctx, handlers, line, script, isPS, stdinR, &cmd.stdout, &cmd.stderr)
+	}()
+	s.respond(w, "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandResponse", req.Header.MessageID,
+		fmt.Sprintf(`<rsp:CommandResponse><rsp:CommandId>%s</rsp:CommandId></rsp:CommandResponse>`, commandID))
+}
+
+func (s *Server) exec(ctx context.Context, handlers []handler, line, script string, isPS bool, stdin io.Reader, stdout, stderr *bytes.Buffer) int {
+	for _, h := range handlers {
+		target := line
+		if h.ps {
+			if !isPS {
+				continue
+			}
+			target = script
+		}
+		if match := h.pattern.FindStringSubmatch(target); match != nil {
+			return h.fn(match, stdin, stdout, stderr)
+		}
+	}
+	if s.Backend == nil {
+		fmt.Fprintf(stderr, "winrmserver: unsupported command: %s", line)
+		return 1
+	}
+	var code int
+	var out, errOut string
+	var err error
+	if isPS {
+		code, out, errOut, err = s.Backend.RunPS(ctx, script)
+	} else {
+		code, out, errOut, err = s.Backend.Run(ctx, line)
+	}
+	if err != nil {
+		// 16001 is the code the winrm client uses for a broken connection.
+		fmt.Fprint(stderr, err.Error())
+		return 16001
+	}
+	stdout.WriteString(out)
+	stderr.WriteString(errOut)
+	return code
+}
+
+func (s *Server) send(w http.ResponseWriter, req *envelope) {
+	stream := req.Body.Send.Stream
+	cmd, ok := s.lookup(req.shellID(), stream.CommandID)
+	if !ok {
+		s.fault(w, http.StatusBadRequest, req.Header.MessageID, "w:InvalidSelectors", "unknown command")
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Content))
+	if err != nil {
+		s.fault(w, http.StatusBadRequest, req.Header.MessageID, "w:InvalidMessage", err.Error())
+		return
+	}
+	if len(data) > 0 {
+		// The handler may have returned without reading stdin.
+		go cmd.stdin.Write(data)
+	}
+	if stream.End {
+		cmd.stdin.Close()
+	}
+	s.respond(w, "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/SendResponse", req.Header.MessageID, "<rsp:SendResponse/>")
+}
+
+func (s *Server) receive(w http.ResponseWriter, req *envelope) {
+	commandID := req.Body.Receive.DesiredStream.CommandID
+	cmd, ok := s.lookup(req.shellID(), commandID)
+	if !ok {
+		s.fault(w, http.StatusBadRequest, req.Header.MessageID, "w:InvalidSelectors", "unknown command")
+		return
+	}
+	select {
+	case <-cmd.done:
+	case <-time.After(s.ReceiveTimeout):
+		s.fault(w, http.StatusInternalServerError, req.Header.MessageID, "w:TimedOut",
+			"The WS-Management service cannot complete the operation within the time specified in OperationTimeout.")
+		return
+	}
+	var body strings.Builder
+	body.WriteString("<rsp:ReceiveResponse>")
+	if cmd.stdout.Len() > 0 {
+		fmt.Fprintf(&body, `<rsp:Stream Name="stdout" CommandId="%s">%s</rsp:Stream>`, commandID, base64.StdEncoding.EncodeToString(cmd.stdout.Bytes()))
+	}
+	if cmd.stderr.Len() > 0 {
+		fmt.Fprintf(&body, `<rsp:Stream Name="stderr" CommandId="%s">%s</rsp:Stream>`, commandID, base64.StdEncoding.EncodeToString(cmd.stderr.Bytes()))
+	}
+	fmt.Fprintf(&body, `<rsp:CommandState CommandId="%s" State="%s"><rsp:ExitCode>%d</rsp:ExitCode></rsp:CommandState>`, commandID, commandStateDone, cmd.code)
+	body.WriteString("</rsp:ReceiveResponse>")
+	s.respond(w, "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse", req.Header.MessageID, body.String())
+}
+
+func (s *Server) signal(w http.ResponseWriter, req *envelope) {
+	cmd, ok := s.lookup(req.shellID(), req.Body.Signal.CommandID)
+	if !ok {
+		s.fault(w, http.StatusBadRequest, req.Header.MessageID, "w:InvalidSelectors", "unknown command")
+		return
+	}
+	cmd.stdin.Close()
+	cmd.cancel()
+	s.mu.Lock()
+	delete(s.shells[req.shellID()], req.Body.Signal.CommandID)
+	s.mu.Unlock()
+	s.respond(w, "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/SignalResponse", req.Header.MessageID, "<rsp:SignalResponse/>")
+}
+
+const envelopeTemplate = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:x="http://schemas.xmlsoap.org/ws/2004/09/transfer" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd"><s:Header><a:Action>%s</a:Action><a:MessageID>uuid:%s</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>%s</a:RelatesTo></s:Header><s:Body>%s</s:Body></s:Envelope>`
+
+func (s *Server) respond(w http.ResponseWriter, action, relatesTo, body string) {
+	s.mu.Lock()
+	id := s.newID()
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, envelopeTemplate, action, id, relatesTo, body)
+}
+
+func (s *Server) fault(w http.ResponseWriter, status int, relatesTo, subcode, reason string) {
+	var text bytes.Buffer
+	xml.EscapeText(&text, []byte(reason))
+	body := fmt.Sprintf(`<s:Fault><s:Code><s:Value>s:Receiver</s:Value><s:Subcode><s:Value>%s</s:Value></s:Subcode></s:Code><s:Reason><s:Text xml:lang="en-US">%s</s:Text></s:Reason></s:Fault>`, subcode, text.String())
+	s.mu.Lock()
+	id := s.newID()
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, envelopeTemplate, "http://schemas.dmtf.org/wbem/wsman/1/wsman/fault", id, relatesTo, body)
+}
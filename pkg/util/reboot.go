@@ -0,0 +1,225 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"github.com/ruicao93/antrea-windows-ci/pkg/config"
+	"github.com/ruicao93/antrea-windows-ci/pkg/executor"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"strings"
+	"time"
+)
+
+const (
+	RegistryKeyCBSRebootPending     = `HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\Component Based Servicing\RebootPending`
+	RegistryKeyWURebootRequired     = `HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\WindowsUpdate\Auto Update\RebootRequired`
+	RegistryKeySessionManager       = `HKLM:\SYSTEM\CurrentControlSet\Control\Session Manager`
+	RegistryValuePendingFileRenames = "PendingFileRenameOperations"
+)
+
+func RegistryKeyExists(ctx context.Context, client executor.Executor, key string) (bool, error) {
+	cmd := fmt.Sprintf("Test-Path -Path '%s'", key)
+	out, err := CallPSCommand(ctx, client, cmd)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(strings.TrimSpace(out), "True"), nil
+}
+
+func RegistryValueExists(ctx context.Context, client executor.Executor, key, name string) (bool, error) {
+	cmd := fmt.Sprintf("$null -ne (Get-ItemProperty -Path '%s' -Name %s -ErrorAction SilentlyContinue)", key, name)
+	out, err := CallPSCommand(ctx, client, cmd)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(strings.TrimSpace(out), "True"), nil
+}
+
+// RebootPending checks the registry keys Windows sets when a reboot is
+// required to complete a change, e.g. a Windows feature installation. It
+// returns the key which reports the pending reboot, or "" if there is none.
+func RebootPending(ctx context.Context, client executor.Executor) (string, error) {
+	for _, key := range []string{RegistryKeyCBSRebootPending, RegistryKeyWURebootRequired} {
+		exists, err := RegistryKeyExists(ctx, client, key)
+		if err != nil {
+			return "", fmt.Errorf("failed to check registry key %s: %v", key, err)
+		}
+		if exists {
+			return key, nil
+		}
+	}
+	exists, err := RegistryValueExists(ctx, client, RegistryKeySessionManager, RegistryValuePendingFileRenames)
+	if err != nil {
+		return "", fmt.Errorf("failed to check registry value %s of %s: %v", RegistryValuePendingFileRenames, RegistryKeySessionManager, err)
+	}
+	if exists {
+		return fmt.Sprintf(`%s\%s`, RegistryKeySessionManager, RegistryValuePendingFileRenames), nil
+	}
+	return "", nil
+}
+
+const getLastBootUpTimeCmd = "(Get-CimInstance -ClassName Win32_OperatingSystem).LastBootUpTime.ToUniversalTime().ToString('o')"
+
+// GetLastBootUpTime returns the time the host last booted.
+func GetLastBootUpTime(ctx context.Context, client executor.Executor) (time.Time, error) {
+	out, err := CallPSCommand(ctx, client, getLastBootUpTimeCmd)
+	if err != nil {
+		return time.Time{}, err
+	}
+	bootTime, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(out))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse boot time %q: %v", strings.TrimSpace(out), err)
+	}
+	return bootTime, nil
+}
+
+// pollImmediate is wait.PollImmediate which also stops when ctx is done. The
+// condition is called with a context which is done once the poll times out, so
+// a hung command does not outlast the timeout. The returned error tells whether
+// waiting for what timed out or was interrupted.
+func pollImmediate(ctx context.Context, interval, timeout time.Duration, what string, condition func(ctx context.Context) (bool, error)) error {
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(interval, func() (bool, error) {
+		done, err := condition(pollCtx)
+		if pollCtx.Err() != nil {
+			// The condition was cut short, its result does not tell the
+			// state of the host.
+			return false, pollCtx.Err()
+		}
+		return done, err
+	}, pollCtx.Done())
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted waiting for %s: %v", what, ctx.Err())
+	}
+	if err == wait.ErrWaitTimeout || pollCtx.Err() != nil {
+		return fmt.Errorf("timeout to wait %s after %v", what, timeout)
+	}
+	return err
+}
+
+// RestartComputer restarts the host. If waitReboot is true, it waits for the
+// host to report a new boot time and to pass the readiness probes of
+// host.HostConfig.Reboot, an error is returned if either times out or ctx is
+// done.
+func RestartComputer(ctx context.Context, host *config.Host, waitReboot bool) error {
+	client := host.Executor
+	rebootConfig := host.HostConfig.Reboot
+	rebootConfig.SetDefaults()
+
+	bootTime, err := GetLastBootUpTime(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to get boot time of host %s: %v", host.HostConfig.Host, err)
+	}
+	// Restart-Computer is not retried as it may break the connection after the
+	// restart began, neither are the polls which are expected to fail while the
+	// host restarts.
+	ctx = executor.WithRetryPolicy(ctx, executor.NoRetry)
+	rc, _, stderr, err := client.RunPS(ctx, "Restart-Computer -Force")
+	if err != nil {
+		// The connection may break because the host is already going down,
+		// the new boot time below tells whether it restarted.
+		klog.Infof("Restart-Computer on host %s returned error: %v", host.HostConfig.Host, err)
+	} else if rc != 0 {
+		return fmt.Errorf("failed to restart host %s, exit code: %d, error: %s", host.HostConfig.Host, rc, stderr)
+	}
+	if !waitReboot {
+		return nil
+	}
+
+	// Wait down, a host which restarts between two polls is only noticed by
+	// its new boot time.
+	rebooted := false
+	err = pollImmediate(ctx, rebootConfig.PollInterval, rebootConfig.DownTimeout, fmt.Sprintf("host %s down", host.HostConfig.Host), func(ctx context.Context) (done bool, err error) {
+		newBootTime, err := GetLastBootUpTime(ctx, client)
+		if err != nil {
+			klog.Infof("host %s is down now", host.HostConfig.Host)
+			return true, nil
+		}
+		if newBootTime.After(bootTime) {
+			klog.Infof("host %s restarted at %v", host.HostConfig.Host, newBootTime)
+			rebooted = true
+			return true, nil
+		}
+		klog.Infof("Waiting for host %s down", host.HostConfig.Host)
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Wait up
+	deadline := time.Now().Add(rebootConfig.UpTimeout)
+	if !rebooted {
+		err = pollImmediate(ctx, rebootConfig.PollInterval, rebootConfig.UpTimeout, fmt.Sprintf("host %s up with a new boot time", host.HostConfig.Host), func(ctx context.Context) (done bool, err error) {
+			newBootTime, err := GetLastBootUpTime(ctx, client)
+			if err != nil {
+				klog.Infof("Waiting for host %s up", host.HostConfig.Host)
+				return false, nil
+			}
+			if !newBootTime.After(bootTime) {
+				// A transient error may be taken for the host going down.
+				klog.Infof("Waiting for host %s to restart, boot time is still %v", host.HostConfig.Host, bootTime)
+				return false, nil
+			}
+			klog.Infof("host %s is up now, restarted at %v", host.HostConfig.Host, newBootTime)
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The SSH connection does not survive the restart, close it so the next
+	// command connects again instead of failing on the dead connection.
+	if host.SSHExecutor != nil {
+		host.SSHExecutor.Close()
+	}
+
+	// Wait ready
+	for _, probe := range rebootConfig.Probes {
+		timeout := time.Until(deadline)
+		if timeout < rebootConfig.PollInterval {
+			timeout = rebootConfig.PollInterval
+		}
+		var probeErr error
+		err = pollImmediate(ctx, rebootConfig.PollInterval, timeout, fmt.Sprintf("probe %s of host %s", probe, host.HostConfig.Host), func(ctx context.Context) (done bool, err error) {
+			if probeErr = runProbe(ctx, host, probe); probeErr != nil {
+				klog.Infof("Waiting for probe %s of host %s: %v", probe, host.HostConfig.Host, probeErr)
+				return false, nil
+			}
+			return true, nil
+		})
+		if err != nil {
+			return fmt.Errorf("%v: %v", err, probeErr)
+		}
+	}
+	klog.Infof("host %s is ready", host.HostConfig.Host)
+	return nil
+}
+
+// runProbe returns an error unless the readiness probe passes.
+func runProbe(ctx context.Context, host *config.Host, probe string) error {
+	switch {
+	case probe == config.ProbeWinRM:
+		return InvokePSCommand(ctx, host.Executor, "ls")
+	case probe == config.ProbeSSH:
+		return InvokeCommand(ctx, host.SSHExecutor, "hostname")
+	case strings.HasPrefix(probe, config.ProbeServicePrefix):
+		svcName := strings.TrimPrefix(probe, config.ProbeServicePrefix)
+		status, err := GetServiceStatus(ctx, host.Executor, svcName)
+		if err != nil {
+			return err
+		}
+		if status != "Running" {
+			return fmt.Errorf("service %s is not running, status: %q", svcName, status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported probe %s", probe)
+	}
+}